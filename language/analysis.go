@@ -0,0 +1,390 @@
+package language
+
+import (
+	"callmemaybe/language/assemblyoutput"
+	"callmemaybe/language/memorymodel"
+	"callmemaybe/language/typesystem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Diagnostic is an error in the source. Length is the number of characters
+// it covers, or 0 if it covers the rest of the line.
+type Diagnostic struct {
+	Position Position
+	Length   int
+	Message  string
+}
+
+// Symbol is what an identifier refers to. It is either an element, or the
+// member of a struct if Element is nil.
+type Symbol struct {
+	Element *memorymodel.ContextElement
+	Struct  string
+	Member  string
+}
+
+// Occurrence is an identifier in the source that declares or refers to a symbol
+type Occurrence struct {
+	Symbol
+	Name        string
+	Position    Position
+	Declaration bool
+}
+
+// TypedExpression is the type of an expression that is not an identifier,
+// like a call or a read from a struct, at the token that identifies it
+type TypedExpression struct {
+	Position Position
+	Type     typesystem.Type
+}
+
+// Scope is what is visible from Start until End
+type Scope struct {
+	Start    Position
+	End      Position
+	Elements []*memorymodel.ContextElement
+	Structs  []typesystem.Type
+}
+
+// Analysis is what editors need to know about a program. Checking stops at
+// the first error, so only the identifiers and scopes before it are known.
+// Nothing is known if the program could not be parsed.
+type Analysis struct {
+	Parsed      bool
+	Diagnostics []Diagnostic
+	Occurrences []Occurrence
+	Expressions []TypedExpression
+	Scopes      []Scope
+	lines       [][]rune
+	// external are the names that C knows, of externs and exported functions
+	external map[string]bool
+}
+
+// Analyze parses and type checks the source, and records where every
+// identifier is declared and used
+func Analyze(source string) *Analysis {
+	analysis := &Analysis{}
+	for _, line := range strings.Split(source, "\n") {
+		analysis.lines = append(analysis.lines, []rune(line))
+	}
+	parser := NewParser(strings.NewReader(source))
+	parser.Positions = true
+	ast, err := parser.Parse()
+	if err != nil {
+		analysis.addDiagnostic(err, true)
+		return analysis
+	}
+	analysis.Parsed = true
+	analysis.external = externalNames(ast)
+	ast, err = Fold(ast)
+	if err != nil {
+		analysis.addDiagnostic(err, false)
+		return analysis
+	}
+	mm := memorymodel.NewMemoryModel()
+	mm.Listener = recorder{analysis}
+	err = check(ast, mm)
+	if err != nil {
+		analysis.addDiagnostic(err, false)
+	}
+	return analysis
+}
+
+// check type checks the program. The checker assumes that programs are
+// mostly right, so a panic is reported like any other error, since editors
+// analyse programs while they are being written.
+func check(ast Stmt, mm *memorymodel.MemoryModel) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("failed to check the program: %v", recovered)
+		}
+	}()
+	return GenerateProgram(ast, assemblyoutput.NewAssemblyOutput(), mm)
+}
+
+func externalNames(ast Stmt) map[string]bool {
+	names := make(map[string]bool)
+	seq, ok := ast.(StmtSeq)
+	if !ok {
+		return names
+	}
+	for _, stmt := range seq.Statements {
+		switch declaration := stmt.(type) {
+		case StmtExtern:
+			names[declaration.Name] = true
+		case StmtFunctionDeclaration:
+			if declaration.Exported {
+				names[declaration.Name] = true
+			}
+		}
+	}
+	return names
+}
+
+// addDiagnostic adds the error at its position. Parse errors cover the
+// token they happened at, and other errors the rest of the statement's line.
+func (analysis *Analysis) addDiagnostic(err error, token bool) {
+	diagnostic := Diagnostic{
+		Position: Position{Line: 1, Column: 1},
+		Message:  err.Error(),
+	}
+	var positioned *PositionError
+	if errors.As(err, &positioned) {
+		diagnostic.Position = positioned.Position
+		diagnostic.Message = positioned.Err.Error()
+	}
+	if token {
+		diagnostic.Length = analysis.tokenLength(diagnostic.Position)
+	}
+	analysis.Diagnostics = append(analysis.Diagnostics, diagnostic)
+}
+
+// tokenLength is the number of characters in the token at the position.
+// Identifiers and keywords are words, and every other token is counted as
+// a single character.
+func (analysis *Analysis) tokenLength(position Position) int {
+	if position.Line > len(analysis.lines) {
+		return 1
+	}
+	line := analysis.lines[position.Line-1]
+	length := 0
+	for i := position.Column - 1; i >= 0 && i < len(line) && isWordCharacter(line[i]); i++ {
+		length++
+	}
+	if length == 0 {
+		return 1
+	}
+	return length
+}
+
+func isWordCharacter(character rune) bool {
+	return validIdentifierChar(character) || unicode.IsDigit(character)
+}
+
+// contains is true if the position is inside the token that starts at start
+func (analysis *Analysis) contains(start Position, position Position) bool {
+	return start.Line == position.Line &&
+		position.Column >= start.Column &&
+		position.Column < start.Column+analysis.tokenLength(start)
+}
+
+// OccurrenceAt returns the identifier at the position
+func (analysis *Analysis) OccurrenceAt(position Position) (Occurrence, bool) {
+	for _, occurrence := range analysis.Occurrences {
+		if analysis.contains(occurrence.Position, position) {
+			return occurrence, true
+		}
+	}
+	return Occurrence{}, false
+}
+
+// TypeAt returns the type of the identifier or expression at the position,
+// and the position and length of the token it was found at
+func (analysis *Analysis) TypeAt(position Position) (typesystem.Type, Position, int, bool) {
+	if occurrence, ok := analysis.OccurrenceAt(position); ok && occurrence.Element != nil {
+		return occurrence.Element.Type, occurrence.Position, len([]rune(occurrence.Name)), true
+	}
+	for _, expression := range analysis.Expressions {
+		if analysis.contains(expression.Position, position) {
+			return expression.Type, expression.Position, analysis.tokenLength(expression.Position), true
+		}
+	}
+	return typesystem.Type{}, Position{}, 0, false
+}
+
+// ScopeAt returns what is visible at the position. Of the scopes that the
+// position is in, the innermost one is the one that starts last, and the
+// last one that was recorded wins if they start at the same place.
+func (analysis *Analysis) ScopeAt(position Position) (Scope, bool) {
+	found := -1
+	for i, scope := range analysis.Scopes {
+		if before(position, scope.Start) || before(scope.End, position) {
+			continue
+		}
+		if found < 0 || !before(scope.Start, analysis.Scopes[found].Start) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return Scope{}, false
+	}
+	return analysis.Scopes[found], true
+}
+
+// Lookup returns the element with the name that is visible at the position
+func (analysis *Analysis) Lookup(position Position, name string) (*memorymodel.ContextElement, bool) {
+	scope, ok := analysis.ScopeAt(position)
+	if !ok {
+		return nil, false
+	}
+	for _, element := range scope.Elements {
+		if element.Name == name {
+			return element, true
+		}
+	}
+	return nil, false
+}
+
+// StructType returns the declaration of the struct with the name that is
+// visible at the position
+func (analysis *Analysis) StructType(position Position, name string) (typesystem.Type, bool) {
+	scope, ok := analysis.ScopeAt(position)
+	if !ok {
+		return typesystem.Type{}, false
+	}
+	for _, kind := range scope.Structs {
+		if kind.StructName == name {
+			return kind, true
+		}
+	}
+	return typesystem.Type{}, false
+}
+
+// References returns the occurrences of the symbol at the position, in the
+// order they are in the source
+func (analysis *Analysis) References(position Position) []Occurrence {
+	at, ok := analysis.OccurrenceAt(position)
+	if !ok {
+		return nil
+	}
+	var found []Occurrence
+	for _, occurrence := range analysis.Occurrences {
+		if occurrence.Symbol == at.Symbol {
+			found = append(found, occurrence)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return before(found[i].Position, found[j].Position)
+	})
+	// An identifier is only counted once, even if it was reported twice
+	var references []Occurrence
+	for _, occurrence := range found {
+		if len(references) > 0 && references[len(references)-1].Position == occurrence.Position {
+			continue
+		}
+		references = append(references, occurrence)
+	}
+	return references
+}
+
+// Definition returns where the symbol at the position is declared
+func (analysis *Analysis) Definition(position Position) (Occurrence, bool) {
+	for _, occurrence := range analysis.References(position) {
+		if occurrence.Declaration {
+			return occurrence, true
+		}
+	}
+	return Occurrence{}, false
+}
+
+// Rename returns the occurrences that must be replaced with the new name to
+// rename the symbol at the position. Renaming is refused if the program has
+// errors, since not every occurrence is known then, or if the new name would
+// refer to something else somewhere.
+func (analysis *Analysis) Rename(position Position, name string) ([]Occurrence, error) {
+	if len(analysis.Diagnostics) > 0 {
+		return nil, fmt.Errorf("can not rename in a program with errors")
+	}
+	if !isIdentifier(name) || name == "_" {
+		return nil, fmt.Errorf("%s is not a valid name", name)
+	}
+	references := analysis.References(position)
+	if len(references) == 0 {
+		return nil, fmt.Errorf("there is nothing to rename here")
+	}
+	symbol := references[0].Symbol
+	old := references[0].Name
+	if _, ok := analysis.Definition(position); !ok {
+		return nil, fmt.Errorf("%s is not declared in this program", old)
+	}
+	if symbol.Element == nil {
+		for _, occurrence := range analysis.Occurrences {
+			if occurrence.Element == nil && occurrence.Struct == symbol.Struct && occurrence.Member == name {
+				return nil, fmt.Errorf("%s already has a member named %s", symbol.Struct, name)
+			}
+		}
+		return references, nil
+	}
+	if analysis.external[old] {
+		return nil, fmt.Errorf("%s is known to C by its name", old)
+	}
+	for _, reference := range references {
+		if element, ok := analysis.Lookup(reference.Position, name); ok && element != symbol.Element {
+			return nil, fmt.Errorf("%s would refer to another %s at %d:%d", old, name, reference.Position.Line, reference.Position.Column)
+		}
+	}
+	for _, occurrence := range analysis.Occurrences {
+		if occurrence.Element == nil || occurrence.Name != name {
+			continue
+		}
+		if element, ok := analysis.Lookup(occurrence.Position, old); ok && element == symbol.Element {
+			return nil, fmt.Errorf("%s at %d:%d would refer to the renamed %s", name, occurrence.Position.Line, occurrence.Position.Column, old)
+		}
+	}
+	return references, nil
+}
+
+func isIdentifier(name string) bool {
+	for i, character := range name {
+		if !validIdentifierChar(character) && (i == 0 || !unicode.IsDigit(character)) {
+			return false
+		}
+	}
+	_, keyword := keywords[name]
+	return name != "" && !keyword
+}
+
+func before(a Position, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// recorder records what the type checker resolves in the analysis
+type recorder struct {
+	analysis *Analysis
+}
+
+func (r recorder) Declared(position Position, element *memorymodel.ContextElement) {
+	r.analysis.Occurrences = append(r.analysis.Occurrences, Occurrence{
+		Symbol:      Symbol{Element: element},
+		Name:        element.Name,
+		Position:    position,
+		Declaration: true,
+	})
+}
+
+func (r recorder) Used(position Position, element *memorymodel.ContextElement) {
+	r.analysis.Occurrences = append(r.analysis.Occurrences, Occurrence{
+		Symbol:   Symbol{Element: element},
+		Name:     element.Name,
+		Position: position,
+	})
+}
+
+func (r recorder) Member(position Position, structName string, member string, declaration bool) {
+	r.analysis.Occurrences = append(r.analysis.Occurrences, Occurrence{
+		Symbol:      Symbol{Struct: structName, Member: member},
+		Name:        member,
+		Position:    position,
+		Declaration: declaration,
+	})
+}
+
+func (r recorder) Typed(position Position, kind typesystem.Type) {
+	r.analysis.Expressions = append(r.analysis.Expressions, TypedExpression{
+		Position: position,
+		Type:     kind,
+	})
+}
+
+func (r recorder) Scope(start Position, end Position, elements []*memorymodel.ContextElement, structs []typesystem.Type) {
+	r.analysis.Scopes = append(r.analysis.Scopes, Scope{
+		Start:    start,
+		End:      end,
+		Elements: elements,
+		Structs:  structs,
+	})
+}
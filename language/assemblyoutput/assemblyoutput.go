@@ -7,8 +7,25 @@ import (
 type AssemblyOutput struct {
 	procedureStack       *ProcedureStack
 	nameGeneratorCounter int
+	mainFrame            frame
 	EvaluatedProcedures  []*procedure
-	MainOperations       []string
+	MainOperations       []Instruction
+	Externs              []string
+	Exports              []string
+	Data                 []Data
+	Globals              []string
+	// Library output has no main. The top-level code initializes the library
+	// when it is loaded instead, and all code is position independent.
+	Library bool
+	// Freestanding output does not use libc. It starts at _start, and brings
+	// its own runtime for output and allocation on top of system calls.
+	Freestanding bool
+}
+
+// Data is a labeled sequence of bytes in the data section
+type Data struct {
+	Label Label
+	Bytes []byte
 }
 
 func NewAssemblyOutput() *AssemblyOutput {
@@ -19,103 +36,203 @@ func NewAssemblyOutput() *AssemblyOutput {
 	}
 }
 
-func (ao *AssemblyOutput) Push(r string) {
-	ao.addOperation(fmt.Sprintf("push %s", r))
+func (ao *AssemblyOutput) Push(r Operand) {
+	ao.addOperation(OpPush, r)
+}
+
+func (ao *AssemblyOutput) Pop(r Operand) {
+	ao.addOperation(OpPop, r)
+}
+
+func (ao *AssemblyOutput) Add(r1 Operand, r2 Operand) {
+	ao.addOperation(OpAdd, r1, r2)
 }
 
-func (ao *AssemblyOutput) Pop(r string) {
-	ao.addOperation(fmt.Sprintf("pop %s", r))
+func (ao *AssemblyOutput) Imul(r1 Operand, r2 Operand) {
+	ao.addOperation(OpImul, r1, r2)
 }
 
-func (ao *AssemblyOutput) Add(r1 string, r2 string) {
-	ao.addOperation(fmt.Sprintf("add %s, %s", r1, r2))
+func (ao *AssemblyOutput) Mov(r1 Operand, r2 Operand) {
+	ao.addOperation(OpMov, r1, r2)
 }
 
-func (ao *AssemblyOutput) Imul(r1 string, r2 string) {
-	ao.addOperation(fmt.Sprintf("imul %s, %s", r1, r2))
+func (ao *AssemblyOutput) Xor(r1 Operand, r2 Operand) {
+	ao.addOperation(OpXor, r1, r2)
 }
 
-func (ao *AssemblyOutput) Mov(r1 string, r2 string) {
-	ao.addOperation(fmt.Sprintf("mov %s, %s", r1, r2))
+func (ao *AssemblyOutput) Movzx(r1 Operand, r2 Operand) {
+	ao.addOperation(OpMovzx, r1, r2)
 }
 
-func (ao *AssemblyOutput) Xor(r1 string, r2 string) {
-	ao.addOperation(fmt.Sprintf("xor %s, %s", r1, r2))
+func (ao *AssemblyOutput) And(r1 Operand, r2 Operand) {
+	ao.addOperation(OpAnd, r1, r2)
 }
 
 func (ao *AssemblyOutput) CallPrintf() {
-	ao.addOperation(fmt.Sprintf("call printf"))
+	ao.CallExternal(Label("printf"))
+}
+
+// CallMalloc allocates the number of bytes in rdi, and returns the address in rax
+func (ao *AssemblyOutput) CallMalloc() {
+	if ao.Freestanding {
+		ao.Call(Allocate)
+		return
+	}
+	ao.CallExternal(Label("malloc"))
+}
+
+// CallFree frees the memory at the address in rdi
+func (ao *AssemblyOutput) CallFree() {
+	if ao.Freestanding {
+		ao.Call(Deallocate)
+		return
+	}
+	ao.CallExternal(Label("free"))
 }
 
 func (ao *AssemblyOutput) Ret() {
-	ao.addOperation(fmt.Sprintf("ret"))
+	ao.addOperation(OpRet)
 }
 
-func (ao *AssemblyOutput) Call(name string) {
-	ao.addOperation(fmt.Sprintf("call %s", name))
+func (ao *AssemblyOutput) Call(target Operand) {
+	ao.addOperation(OpCall, target)
 }
 
-func (ao *AssemblyOutput) Cmp(r1 string, r2 string) {
-	ao.addOperation(fmt.Sprintf("cmp %s, %s", r1, r2))
+func (ao *AssemblyOutput) Cmp(r1 Operand, r2 Operand) {
+	ao.addOperation(OpCmp, r1, r2)
 }
 
 func (ao *AssemblyOutput) Je(name string) {
-	ao.addOperation(fmt.Sprintf("je %s", name))
+	ao.addOperation(OpJe, Label(name))
 }
 
 func (ao *AssemblyOutput) Jne(name string) {
-	ao.addOperation(fmt.Sprintf("jne %s", name))
+	ao.addOperation(OpJne, Label(name))
 }
 
 func (ao *AssemblyOutput) Jg(name string) {
-	ao.addOperation(fmt.Sprintf("jg %s", name))
+	ao.addOperation(OpJg, Label(name))
 }
 
 func (ao *AssemblyOutput) Jl(name string) {
-	ao.addOperation(fmt.Sprintf("jl %s", name))
+	ao.addOperation(OpJl, Label(name))
 }
 
 func (ao *AssemblyOutput) Jle(name string) {
-	ao.addOperation(fmt.Sprintf("jle %s", name))
+	ao.addOperation(OpJle, Label(name))
 }
 
 func (ao *AssemblyOutput) Jge(name string) {
-	ao.addOperation(fmt.Sprintf("jge %s", name))
+	ao.addOperation(OpJge, Label(name))
 }
 
 func (ao *AssemblyOutput) Jmp(name string) {
-	ao.addOperation(fmt.Sprintf("jmp %s", name))
+	ao.addOperation(OpJmp, Label(name))
+}
+
+func (ao *AssemblyOutput) Sub(r1, r2 Operand) {
+	ao.addOperation(OpSub, r1, r2)
+}
+
+func (ao *AssemblyOutput) Div(r Operand) {
+	ao.addOperation(OpDiv, r)
+}
+
+func (ao *AssemblyOutput) Shl(r Operand, count Immediate) {
+	ao.addOperation(OpShl, r, count)
+}
+
+func (ao *AssemblyOutput) Sar(r Operand, count Immediate) {
+	ao.addOperation(OpSar, r, count)
+}
+
+func (ao *AssemblyOutput) Neg(r Operand) {
+	ao.addOperation(OpNeg, r)
+}
+
+func (ao *AssemblyOutput) Syscall() {
+	ao.addOperation(OpSyscall)
 }
 
-func (ao *AssemblyOutput) Sub(r1, r2 string) {
-	ao.addOperation(fmt.Sprintf("sub %s, %s", r1, r2))
+// Ud2 raises an invalid opcode exception, which stops the program with SIGILL
+func (ao *AssemblyOutput) Ud2() {
+	ao.addOperation(OpUd2)
 }
 
-func (ao *AssemblyOutput) Div(r string) {
-	ao.addOperation(fmt.Sprintf("div %s", r))
+// CheckIndex stops the program if the index is not inside the list
+func (ao *AssemblyOutput) CheckIndex(index Register, list Register) {
+	ao.Cmp(index, Immediate(0))
+	ao.Jl(string(IndexOutOfRange))
+	ao.Cmp(index, Memory{Size: "qword", Base: list})
+	ao.Jge(string(IndexOutOfRange))
 }
 
 func (ao *AssemblyOutput) NewSection(name string) {
-	ao.addOperation(fmt.Sprintf("%s:", name))
+	ao.addInstruction(Instruction{
+		Opcode: OpLabel,
+		Label:  name,
+	})
+}
+
+func (ao *AssemblyOutput) addOperation(opcode Opcode, operands ...Operand) {
+	ao.addInstruction(Instruction{
+		Opcode:   opcode,
+		Operands: operands,
+	})
 }
 
-func (ao *AssemblyOutput) addOperation(operation string) {
+func (ao *AssemblyOutput) addInstruction(instruction Instruction) {
 	procedure := ao.procedureStack.Peek()
 	if procedure == nil {
-		ao.MainOperations = append(ao.MainOperations, operation)
+		ao.MainOperations = append(ao.MainOperations, instruction)
 	} else {
-		procedure.Operations = append(procedure.Operations, operation)
+		procedure.Operations = append(procedure.Operations, instruction)
 	}
 }
 
-func (ao *AssemblyOutput) PushProcedure(initialStackSize int, numberOfArguments int) string {
-	name := ao.GenerateUniqueName()
+func (ao *AssemblyOutput) PushProcedure(name string) {
 	ao.procedureStack.Push(&procedure{
-		Name:                              name,
-		StackSizeBeforeFunctionGeneration: initialStackSize,
-		NumberOfArgs:                      numberOfArguments,
+		Name: name,
 	})
-	return name
+}
+
+// EnterFrame sets up the stack frame of the current procedure, and stores the
+// registers that must be preserved for the caller in the first slots. The
+// space for variables is reserved by SetFrameSize once the whole body is
+// generated.
+func (ao *AssemblyOutput) EnterFrame(saved []Register) {
+	frame := ao.currentFrame()
+	ao.Push(RBP)
+	ao.Mov(RBP, RSP)
+	frame.sizeIndex = len(ao.currentOperations())
+	frame.saved = saved
+	ao.Sub(RSP, Immediate(0))
+	for i, register := range saved {
+		ao.Mov(FrameAddress(-8*(i+1)), register)
+	}
+}
+
+// SetFrameSize reserves the given number of quad words below rbp in the
+// frame of the current procedure
+func (ao *AssemblyOutput) SetFrameSize(slots int) {
+	ao.currentOperations()[ao.currentFrame().sizeIndex].Operands[1] = Immediate(slots * 8)
+}
+
+// LeaveFrame restores the saved registers and frees the stack frame of the
+// current procedure, which must be done before it returns
+func (ao *AssemblyOutput) LeaveFrame() {
+	for i, register := range ao.currentFrame().saved {
+		ao.Mov(register, FrameAddress(-8*(i+1)))
+	}
+	ao.Mov(RSP, RBP)
+	ao.Pop(RBP)
+}
+
+func (ao *AssemblyOutput) currentFrame() *frame {
+	if procedure := ao.procedureStack.Peek(); procedure != nil {
+		return &procedure.frame
+	}
+	return &ao.mainFrame
 }
 
 func (ao *AssemblyOutput) PopProcedure() {
@@ -129,47 +246,91 @@ func (ao *AssemblyOutput) GenerateUniqueName() string {
 	return fmt.Sprintf("unique%d", ao.nameGeneratorCounter)
 }
 
+// AddExtern declares a symbol that is defined outside of the program
+func (ao *AssemblyOutput) AddExtern(name string) {
+	for _, extern := range ao.Externs {
+		if extern == name {
+			return
+		}
+	}
+	ao.Externs = append(ao.Externs, name)
+}
+
+// AddExport makes a procedure visible outside of the program
+func (ao *AssemblyOutput) AddExport(name string) {
+	ao.Exports = append(ao.Exports, name)
+}
+
+// NewGlobal reserves a zero initialized quad word at the given label
+func (ao *AssemblyOutput) NewGlobal(name string) {
+	ao.Globals = append(ao.Globals, name)
+}
+
 func (ao *AssemblyOutput) CurrentProcedure() *procedure {
 	return ao.procedureStack.Peek()
 }
 
 func (ao *AssemblyOutput) Start() {
-	ao.addOperation("extern printf")
-	ao.addOperation("extern malloc")
-	ao.addOperation("extern free")
-	ao.addOperation("global main")
-	ao.addOperation("section .date")
-	ao.addOperation("digitNewlineFormat: db '%d', 10, 0")
-	ao.addOperation("charNewlineFormat: db '%c', 10, 0")
-	ao.addOperation("charFormat: db '%c', 0")
-	ao.addOperation("section .text")
-	ao.addOperation("main:")
-	ao.addOperation("push rbx")
-}
-
-func (ao *AssemblyOutput) End(stackSize int) {
-	ao.addOperation("pop rbx")
-	for i := 0; i < stackSize; i++ {
-		ao.addOperation("pop rbx")
-	}
-	ao.addOperation("mov rax, 0")
-	ao.addOperation("ret")
+	if !ao.Freestanding {
+		ao.Externs = []string{"printf", "malloc", "free", "fflush"}
+	}
+	ao.Data = []Data{
+		{Label: DigitNewlineFormat, Bytes: []byte("%d\n\x00")},
+		{Label: CharNewlineFormat, Bytes: []byte("%c\n\x00")},
+		{Label: CharFormat, Bytes: []byte("%c\x00")},
+	}
+	if ao.Library {
+		ao.NewSection(string(Initialize))
+	} else {
+		ao.NewSection(ao.Entry())
+	}
+}
+
+// Entry is the symbol where execution of a program starts
+func (ao *AssemblyOutput) Entry() string {
+	if ao.Freestanding {
+		return "_start"
+	}
+	return "main"
+}
+
+func (ao *AssemblyOutput) End(frameSize int) {
+	ao.SetFrameSize(frameSize)
+	ao.LeaveFrame()
+	if ao.Freestanding {
+		ao.Exit()
+	} else {
+		ao.Mov(RAX, Immediate(0))
+		ao.Ret()
+	}
+
+	// Procedure for stopping the program when a list is indexed outside of
+	// it. The output so far is written first, and the program is stopped
+	// like __builtin_trap stops the programs of the C backend.
+	ao.NewSection(string(IndexOutOfRange))
+	if ao.Freestanding {
+		ao.Call(FlushOutput)
+	} else {
+		ao.Mov(RDI, Immediate(0))
+		ao.CallExternal(Label("fflush"))
+	}
+	ao.Ud2()
 
 	// Procedure for printing all registers in a list
 	// RAX: list address, RBX: format
-	ao.NewSection("printListWithFormat")
+	ao.NewSection(string(PrintListWithFormat))
 	ao.Mov(RDX, RAX)
-	ao.Mov(RCX, "0")
+	ao.Mov(RCX, Immediate(0))
 
-	ao.Cmp(fmt.Sprintf("qword [%s]", RDX), "0")
+	ao.Cmp(Memory{Size: "qword", Base: RDX}, Immediate(0))
 	ao.Je("printListWithFormatLoopEnd")
 
 	ao.NewSection("printListWithFormatLoopStart")
-	ao.Add(RCX, "1")
-	ao.Mov(RAX, fmt.Sprintf("[%s+%s*8]", RDX, RCX))
-	ao.Call("printRegisterWithFormat")
+	ao.Add(RCX, Immediate(1))
+	ao.Mov(RAX, Memory{Base: RDX, Index: RCX, Scale: 8})
+	ao.Call(PrintRegisterWithFormat)
 
-	ao.Cmp(RCX, fmt.Sprintf("[%s]", RDX))
+	ao.Cmp(RCX, Memory{Base: RDX})
 	ao.Je("printListWithFormatLoopEnd")
 	ao.Jne("printListWithFormatLoopStart")
 
@@ -178,18 +339,120 @@ func (ao *AssemblyOutput) End(stackSize int) {
 
 	// Procedure for printing a register
 	// RAX: register to print, RBX: format
-	ao.NewSection("printRegisterWithFormat")
+	ao.NewSection(string(PrintRegisterWithFormat))
 	ao.Push(RAX)
 	ao.Push(RBX)
 	ao.Push(RCX)
 	ao.Push(RDX)
 	ao.Mov(RDI, RBX)
 	ao.Mov(RSI, RAX)
-	ao.Xor(RAX, RAX)
-	ao.CallPrintf()
+	if ao.Freestanding {
+		ao.Call(Format)
+	} else {
+		ao.Xor(RAX, RAX)
+		ao.CallPrintf()
+	}
 	ao.Pop(RDX)
 	ao.Pop(RCX)
 	ao.Pop(RBX)
 	ao.Pop(RAX)
 	ao.Ret()
+
+	// Procedure for copying a list of chars to a null terminated string,
+	// which must be freed by the caller
+	// RAX: list address, returns the string in RAX
+	ao.NewSection(string(ListToCString))
+	ao.Push(RAX)
+	ao.Mov(RDI, Memory{Base: RAX})
+	ao.Add(RDI, Immediate(1))
+	ao.CallMalloc()
+	ao.Mov(RSI, RAX)
+	ao.Pop(RDX)
+	ao.Mov(RCX, Immediate(0))
+
+	ao.NewSection("listToCStringLoopStart")
+	ao.Cmp(RCX, Memory{Base: RDX})
+	ao.Je("listToCStringLoopEnd")
+	ao.Mov(RAX, Memory{Base: RDX, Index: RCX, Scale: 8, Displacement: 8})
+	ao.Mov(Memory{Size: "byte", Base: RSI, Index: RCX, Scale: 1}, AL)
+	ao.Add(RCX, Immediate(1))
+	ao.Jmp("listToCStringLoopStart")
+
+	ao.NewSection("listToCStringLoopEnd")
+	ao.Mov(Memory{Size: "byte", Base: RSI, Index: RCX, Scale: 1}, Immediate(0))
+	ao.Mov(RAX, RSI)
+	ao.Ret()
+
+	// Procedure for copying a null terminated string to a new list of chars.
+	// A null pointer becomes the empty list.
+	// RAX: string address, returns the list in RAX
+	ao.NewSection(string(CStringToList))
+	ao.Mov(RCX, Immediate(0))
+	ao.Cmp(RAX, Immediate(0))
+	ao.Je("cStringToListLengthEnd")
+
+	ao.NewSection("cStringToListLengthStart")
+	ao.Cmp(Memory{Size: "byte", Base: RAX, Index: RCX, Scale: 1}, Immediate(0))
+	ao.Je("cStringToListLengthEnd")
+	ao.Add(RCX, Immediate(1))
+	ao.Jmp("cStringToListLengthStart")
+
+	ao.NewSection("cStringToListLengthEnd")
+	ao.Push(RAX)
+	ao.Push(RCX)
+	ao.Mov(RDI, RCX)
+	ao.Add(RDI, Immediate(1))
+	ao.Imul(RDI, Immediate(8))
+	ao.CallMalloc()
+	ao.Pop(RCX)
+	ao.Pop(RDX)
+	ao.Mov(Memory{Size: "qword", Base: RAX}, RCX)
+	ao.Mov(RSI, Immediate(0))
+
+	ao.NewSection("cStringToListCopyStart")
+	ao.Cmp(RSI, RCX)
+	ao.Je("cStringToListCopyEnd")
+	ao.Movzx(RDI, Memory{Size: "byte", Base: RDX, Index: RSI, Scale: 1})
+	ao.Mov(Memory{Base: RAX, Index: RSI, Scale: 8, Displacement: 8}, RDI)
+	ao.Add(RSI, Immediate(1))
+	ao.Jmp("cStringToListCopyStart")
+
+	ao.NewSection("cStringToListCopyEnd")
+	ao.Ret()
+
+	if ao.Freestanding {
+		ao.freestandingRuntime()
+	}
+}
+
+// Snapshot marks the end of the output generated so far
+type Snapshot struct {
+	operations int
+	procedures int
+}
+
+func (ao *AssemblyOutput) Snapshot() Snapshot {
+	return Snapshot{
+		operations: len(ao.currentOperations()),
+		procedures: len(ao.EvaluatedProcedures),
+	}
+}
+
+// Restore throws away all output generated after the snapshot was taken
+func (ao *AssemblyOutput) Restore(snapshot Snapshot) {
+	procedure := ao.procedureStack.Peek()
+	if procedure == nil {
+		ao.MainOperations = ao.MainOperations[:snapshot.operations]
+	} else {
+		procedure.Operations = procedure.Operations[:snapshot.operations]
+	}
+	ao.EvaluatedProcedures = ao.EvaluatedProcedures[:snapshot.procedures]
+}
+
+func (ao *AssemblyOutput) currentOperations() []Instruction {
+	procedure := ao.procedureStack.Peek()
+	if procedure == nil {
+		return ao.MainOperations
+	}
+	return procedure.Operations
 }
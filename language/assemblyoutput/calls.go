@@ -0,0 +1,49 @@
+package assemblyoutput
+
+// ArgumentRegisters hold the first arguments of a call in the System V ABI.
+// The remaining arguments are passed on the stack.
+var ArgumentRegisters = []Register{RDI, RSI, RDX, RCX, R8, R9}
+
+// CallExternal calls a C function with the stack aligned to 16 bytes, as the
+// System V ABI requires. The stack pointer is pushed twice, so that one copy
+// is right above the aligned stack pointer whether the and moved it or not.
+func (ao *AssemblyOutput) CallExternal(target Operand) {
+	ao.Push(RSP)
+	ao.Push(Memory{Size: "qword", Base: RSP})
+	ao.And(RSP, Immediate(-16))
+	ao.Call(target)
+	ao.Mov(RSP, StackAddress(8))
+}
+
+// CallFunction calls a function with the System V calling convention. The
+// function address and then each of the arguments must have been pushed,
+// and are popped again after the call. The return value is left in rax.
+//
+// rbx points at the pushed arguments during the call, which is safe since
+// every function preserves it.
+func (ao *AssemblyOutput) CallFunction(numberOfArguments int) {
+	argument := func(i int) Memory {
+		return Memory{Size: "qword", Base: RBX, Displacement: 8 * (numberOfArguments - 1 - i)}
+	}
+	ao.Mov(RBX, RSP)
+	ao.And(RSP, Immediate(-16))
+	onStack := numberOfArguments - len(ArgumentRegisters)
+	if onStack > 0 && onStack%2 == 1 {
+		ao.Sub(RSP, Immediate(8))
+	}
+	for i := numberOfArguments - 1; i >= len(ArgumentRegisters); i-- {
+		ao.Push(argument(i))
+	}
+	for i := 0; i < numberOfArguments && i < len(ArgumentRegisters); i++ {
+		ao.Mov(ArgumentRegisters[i], argument(i))
+	}
+	ao.Call(Memory{Base: RBX, Displacement: 8 * numberOfArguments})
+	ao.Mov(RSP, RBX)
+	ao.Add(RSP, Immediate(8*(numberOfArguments+1)))
+}
+
+// StackArgumentOffset is the offset from rbp of an argument that is passed on
+// the stack, where the return address and the saved rbp are right below it
+func StackArgumentOffset(index int) int {
+	return 16 + 8*(index-len(ArgumentRegisters))
+}
@@ -1,16 +1,41 @@
 package assemblyoutput
 
 const (
-	RAX = "rax"
-	RBX = "rbx"
-	RDI = "rdi"
-	RSI = "rsi"
-	RDX = "rdx"
-	RCX = "rcx"
+	RAX Register = "rax"
+	RBX Register = "rbx"
+	RDI Register = "rdi"
+	RSI Register = "rsi"
+	RDX Register = "rdx"
+	RCX Register = "rcx"
+	RSP Register = "rsp"
+	RBP Register = "rbp"
+	R8  Register = "r8"
+	R9  Register = "r9"
+	R10 Register = "r10"
+	R11 Register = "r11"
+	R12 Register = "r12"
+	R13 Register = "r13"
+	R14 Register = "r14"
+	R15 Register = "r15"
+	AL  Register = "al"
 
-	DigitNewlineFormat      = "digitNewlineFormat"
-	CharNewlineFormat       = "charNewlineFormat"
-	CharFormat              = "charFormat"
-	PrintListWithFormat     = "printListWithFormat"
-	PrintRegisterWithFormat = "printRegisterWithFormat"
+	DigitNewlineFormat      Label = "digitNewlineFormat"
+	CharNewlineFormat       Label = "charNewlineFormat"
+	CharFormat              Label = "charFormat"
+	PrintListWithFormat     Label = "printListWithFormat"
+	PrintRegisterWithFormat Label = "printRegisterWithFormat"
+	ListToCString           Label = "listToCString"
+	CStringToList           Label = "cStringToList"
+	Initialize              Label = "initialize"
+	Format                  Label = "format"
+	WriteChar               Label = "writeChar"
+	WriteDecimal            Label = "writeDecimal"
+	FlushOutput             Label = "flushOutput"
+	OutputBuffer            Label = "outputBuffer"
+	OutputLength            Label = "outputLength"
+	Allocate                Label = "allocate"
+	Deallocate              Label = "deallocate"
+	HeapNext                Label = "heapNext"
+	HeapEnd                 Label = "heapEnd"
+	IndexOutOfRange         Label = "indexOutOfRange"
 )
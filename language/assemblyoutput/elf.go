@@ -0,0 +1,260 @@
+package assemblyoutput
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ELF constants for relocatable x86-64 objects
+const (
+	elfHeaderSize     = 64
+	sectionHeaderSize = 64
+	symbolSize        = 24
+	relocationSize    = 24
+
+	sectionProgbits  = 1
+	sectionSymtab    = 2
+	sectionStrtab    = 3
+	sectionRela      = 4
+	sectionInitArray = 14
+
+	flagWrite        = 1
+	flagAlloc        = 2
+	flagExecute      = 4
+	flagInfoLink     = 0x40
+	bindLocal        = 0
+	bindGlobal       = 1
+	typeNone         = 0
+	typeFunction     = 2
+	typeSection      = 3
+	undefinedSection = 0
+)
+
+type section struct {
+	name      string
+	kind      uint32
+	flags     uint64
+	data      []byte
+	link      uint32
+	info      uint32
+	align     uint64
+	entrySize uint64
+}
+
+type symbol struct {
+	name    string
+	info    byte
+	section uint16
+	value   uint64
+}
+
+type relocation struct {
+	offset uint64
+	symbol int
+	kind   uint32
+	addend int64
+}
+
+// stringTable is a string table, where names are referred to by their offset
+type stringTable struct {
+	data []byte
+}
+
+func (table *stringTable) add(name string) uint32 {
+	if name == "" {
+		return 0
+	}
+	offset := len(table.data)
+	table.data = append(table.data, name...)
+	table.data = append(table.data, 0)
+	return uint32(offset)
+}
+
+// Elf encodes the whole program as a relocatable ELF64 object file, which
+// can be linked like the output of nasm -f elf64
+func (ao *AssemblyOutput) Elf() ([]byte, error) {
+	e, err := ao.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// The data section has the same layout as the one printed by Nasm, but
+	// globals are aligned to quad words
+	var data []byte
+	dataLabels := make(map[string]int)
+	for _, d := range ao.Data {
+		dataLabels[string(d.Label)] = len(data)
+		data = append(data, d.Bytes...)
+	}
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	for _, global := range ao.Globals {
+		dataLabels[global] = len(data)
+		data = append(data, make([]byte, 8)...)
+	}
+
+	const (
+		textIndex = 1
+		dataIndex = 2
+		initIndex = 3
+	)
+	symbols := []symbol{
+		{},
+		{info: bindLocal<<4 | typeSection, section: textIndex},
+		{info: bindLocal<<4 | typeSection, section: dataIndex},
+	}
+	textSymbol, dataSymbol := 1, 2
+	firstGlobal := len(symbols)
+
+	var defined []string
+	if !ao.Library {
+		defined = append(defined, ao.Entry())
+	}
+	defined = append(defined, ao.Exports...)
+	for _, name := range defined {
+		offset, ok := e.labels[name]
+		if !ok {
+			return nil, fmt.Errorf("missing label %s", name)
+		}
+		symbols = append(symbols, symbol{name: name, info: bindGlobal<<4 | typeFunction, section: textIndex, value: uint64(offset)})
+	}
+	externSymbols := make(map[string]int)
+	for _, extern := range ao.Externs {
+		externSymbols[extern] = len(symbols)
+		symbols = append(symbols, symbol{name: extern, info: bindGlobal<<4 | typeNone, section: undefinedSection})
+	}
+
+	var textRelocations []relocation
+	for _, f := range e.fixups {
+		if offset, ok := e.labels[f.label]; ok {
+			if f.relocation == relocationPC32 || f.relocation == relocationPLT32 {
+				binary.LittleEndian.PutUint32(e.code[f.offset:], uint32(int32(int64(offset)+f.addend-int64(f.offset))))
+				continue
+			}
+			textRelocations = append(textRelocations, relocation{uint64(f.offset), textSymbol, f.relocation, int64(offset) + f.addend})
+			continue
+		}
+		if offset, ok := dataLabels[f.label]; ok {
+			textRelocations = append(textRelocations, relocation{uint64(f.offset), dataSymbol, f.relocation, int64(offset) + f.addend})
+			continue
+		}
+		if index, ok := externSymbols[f.label]; ok {
+			textRelocations = append(textRelocations, relocation{uint64(f.offset), index, f.relocation, f.addend})
+			continue
+		}
+		return nil, fmt.Errorf("undefined label %s", f.label)
+	}
+
+	sections := []section{
+		{},
+		{name: ".text", kind: sectionProgbits, flags: flagAlloc | flagExecute, data: e.code, align: 16},
+		{name: ".data", kind: sectionProgbits, flags: flagAlloc | flagWrite, data: data, align: 8},
+	}
+	var initRelocations []relocation
+	if ao.Library {
+		// The initialization runs the top-level code when the library is loaded
+		sections = append(sections, section{name: ".init_array", kind: sectionInitArray, flags: flagAlloc | flagWrite, data: make([]byte, 8), align: 8, entrySize: 8})
+		initRelocations = append(initRelocations, relocation{0, textSymbol, relocation64, int64(e.labels[string(Initialize)])})
+	}
+	sections = append(sections, section{name: ".note.GNU-stack", kind: sectionProgbits, align: 1})
+
+	symtabIndex := uint32(len(sections))
+	strtabIndex := symtabIndex + 1
+	var names stringTable
+	names.data = []byte{0}
+	var symtab bytes.Buffer
+	for _, s := range symbols {
+		binary.Write(&symtab, binary.LittleEndian, struct {
+			Name    uint32
+			Info    byte
+			Other   byte
+			Section uint16
+			Value   uint64
+			Size    uint64
+		}{names.add(s.name), s.info, 0, s.section, s.value, 0})
+	}
+	sections = append(sections,
+		section{name: ".symtab", kind: sectionSymtab, data: symtab.Bytes(), link: strtabIndex, info: uint32(firstGlobal), align: 8, entrySize: symbolSize},
+		section{name: ".strtab", kind: sectionStrtab, data: names.data, align: 1},
+		section{name: ".rela.text", kind: sectionRela, flags: flagInfoLink, data: relocations(textRelocations), link: symtabIndex, info: textIndex, align: 8, entrySize: relocationSize},
+	)
+	if ao.Library {
+		sections = append(sections, section{name: ".rela.init_array", kind: sectionRela, flags: flagInfoLink, data: relocations(initRelocations), link: symtabIndex, info: initIndex, align: 8, entrySize: relocationSize})
+	}
+
+	shstrtabIndex := len(sections)
+	sections = append(sections, section{name: ".shstrtab", kind: sectionStrtab, align: 1})
+	var sectionNames stringTable
+	sectionNames.data = []byte{0}
+	nameOffsets := make([]uint32, len(sections))
+	for i, s := range sections {
+		nameOffsets[i] = sectionNames.add(s.name)
+	}
+	sections[shstrtabIndex].data = sectionNames.data
+
+	// The sections follow the ELF header, and the section headers come last
+	var body bytes.Buffer
+	offsets := make([]uint64, len(sections))
+	for i, s := range sections {
+		if i == 0 {
+			continue
+		}
+		for (elfHeaderSize+body.Len())%8 != 0 {
+			body.WriteByte(0)
+		}
+		offsets[i] = uint64(elfHeaderSize + body.Len())
+		body.Write(s.data)
+	}
+	for (elfHeaderSize+body.Len())%8 != 0 {
+		body.WriteByte(0)
+	}
+	sectionHeaders := uint64(elfHeaderSize + body.Len())
+
+	var output bytes.Buffer
+	output.Write([]byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	binary.Write(&output, binary.LittleEndian, struct {
+		Type               uint16
+		Machine            uint16
+		Version            uint32
+		Entry              uint64
+		ProgramHeaders     uint64
+		SectionHeaders     uint64
+		Flags              uint32
+		HeaderSize         uint16
+		ProgramHeaderSize  uint16
+		ProgramHeaderCount uint16
+		SectionHeaderSize  uint16
+		SectionHeaderCount uint16
+		SectionNameTable   uint16
+	}{1, 62, 1, 0, 0, sectionHeaders, 0, elfHeaderSize, 0, 0, sectionHeaderSize, uint16(len(sections)), uint16(shstrtabIndex)})
+	output.Write(body.Bytes())
+	for i, s := range sections {
+		binary.Write(&output, binary.LittleEndian, struct {
+			Name      uint32
+			Type      uint32
+			Flags     uint64
+			Address   uint64
+			Offset    uint64
+			Size      uint64
+			Link      uint32
+			Info      uint32
+			Align     uint64
+			EntrySize uint64
+		}{nameOffsets[i], s.kind, s.flags, 0, offsets[i], uint64(len(s.data)), s.link, s.info, s.align, s.entrySize})
+	}
+	return output.Bytes(), nil
+}
+
+func relocations(entries []relocation) []byte {
+	var buffer bytes.Buffer
+	for _, r := range entries {
+		binary.Write(&buffer, binary.LittleEndian, struct {
+			Offset uint64
+			Info   uint64
+			Addend int64
+		}{r.offset, uint64(r.symbol)<<32 | uint64(r.kind), r.addend})
+	}
+	return buffer.Bytes()
+}
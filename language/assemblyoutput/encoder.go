@@ -0,0 +1,475 @@
+package assemblyoutput
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// registerNumbers are the numbers that registers are encoded with. Numbers
+// from 8 and up need an extra bit in the REX prefix.
+var registerNumbers = map[Register]byte{
+	RAX: 0, RCX: 1, RDX: 2, RBX: 3, RSP: 4, RBP: 5, RSI: 6, RDI: 7,
+	R8: 8, R9: 9, R10: 10, R11: 11, R12: 12, R13: 13, R14: 14, R15: 15,
+	AL: 0,
+}
+
+// arithmetic are the encodings of the instructions that combine two
+// operands: the opcode when the register is the source, the opcode when it
+// is the destination, and the opcode extension used with immediates
+var arithmetic = map[Opcode]struct {
+	store, load, extension byte
+}{
+	OpAdd: {0x01, 0x03, 0},
+	OpAnd: {0x21, 0x23, 4},
+	OpSub: {0x29, 0x2b, 5},
+	OpXor: {0x31, 0x33, 6},
+	OpCmp: {0x39, 0x3b, 7},
+}
+
+var conditions = map[Opcode]byte{
+	OpJe:  0x84,
+	OpJne: 0x85,
+	OpJl:  0x8c,
+	OpJge: 0x8d,
+	OpJle: 0x8e,
+	OpJg:  0x8f,
+}
+
+// Relocation types of the x86-64 ELF ABI
+const (
+	relocation64       = 1
+	relocationPC32     = 2
+	relocationPLT32    = 4
+	relocationGOTPCREL = 9
+	relocation32S      = 11
+)
+
+// fixup is a field in the machine code that holds the address of a label,
+// which is either patched when the label is in the same section, or left to
+// the linker as a relocation
+type fixup struct {
+	offset     int
+	relocation uint32
+	label      string
+	addend     int64
+}
+
+type encoder struct {
+	code    []byte
+	labels  map[string]int
+	fixups  []fixup
+	library bool
+	externs map[string]bool
+}
+
+// encode encodes the instructions of the whole program as x86-64 machine
+// code, in the same order as they are printed by Nasm
+func (ao *AssemblyOutput) encode() (*encoder, error) {
+	e := &encoder{
+		labels:  make(map[string]int),
+		library: ao.Library,
+		externs: make(map[string]bool),
+	}
+	for _, extern := range ao.Externs {
+		e.externs[extern] = true
+	}
+	for _, instruction := range ao.MainOperations {
+		err := e.instruction(instruction)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, procedure := range ao.EvaluatedProcedures {
+		err := e.define(procedure.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, instruction := range procedure.Operations {
+			err := e.instruction(instruction)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e, nil
+}
+
+// define places the label at the end of the code, like Nasm it refuses to
+// define a label twice
+func (e *encoder) define(label string) error {
+	if _, ok := e.labels[label]; ok {
+		return fmt.Errorf("label %s redefined", label)
+	}
+	e.labels[label] = len(e.code)
+	return nil
+}
+
+func (e *encoder) instruction(instruction Instruction) error {
+	err := e.encodeInstruction(instruction)
+	if err != nil {
+		return fmt.Errorf("can not encode %s: %w", instruction, err)
+	}
+	return nil
+}
+
+func (e *encoder) encodeInstruction(instruction Instruction) error {
+	operands := instruction.Operands
+	switch instruction.Opcode {
+	case OpLabel:
+		return e.define(instruction.Label)
+	case OpRet:
+		e.code = append(e.code, 0xc3)
+		return nil
+	case OpPush:
+		switch operand := operands[0].(type) {
+		case Register:
+			return e.short(0x50, operand, false)
+		case Immediate:
+			if !fitsInt32(operand) {
+				return fmt.Errorf("immediate does not fit in 32 bits")
+			}
+			e.code = append(e.code, 0x68)
+			e.code = append(e.code, int32Bytes(int(operand))...)
+			return nil
+		}
+		return e.modrm(false, []byte{0xff}, 6, operands[0], nil)
+	case OpPop:
+		if register, ok := operands[0].(Register); ok {
+			return e.short(0x58, register, false)
+		}
+		return e.modrm(false, []byte{0x8f}, 0, operands[0], nil)
+	case OpAdd, OpAnd, OpSub, OpXor, OpCmp:
+		return e.arithmetic(instruction.Opcode, operands[0], operands[1])
+	case OpImul:
+		destination, ok := operands[0].(Register)
+		if !ok {
+			return fmt.Errorf("the destination must be a register")
+		}
+		number, err := registerNumber(destination)
+		if err != nil {
+			return err
+		}
+		if immediate, ok := operands[1].(Immediate); ok {
+			if fitsInt8(immediate) {
+				return e.modrm(true, []byte{0x6b}, number, destination, []byte{byte(immediate)})
+			}
+			if fitsInt32(immediate) {
+				return e.modrm(true, []byte{0x69}, number, destination, int32Bytes(int(immediate)))
+			}
+			return fmt.Errorf("immediate does not fit in 32 bits")
+		}
+		return e.modrm(true, []byte{0x0f, 0xaf}, number, operands[1], nil)
+	case OpDiv:
+		return e.modrm(true, []byte{0xf7}, 6, operands[0], nil)
+	case OpNeg:
+		return e.modrm(true, []byte{0xf7}, 3, operands[0], nil)
+	case OpShl, OpSar:
+		count, ok := operands[1].(Immediate)
+		if !ok || count < 0 || count > 63 {
+			return fmt.Errorf("the count must be an immediate from 0 to 63")
+		}
+		extension := byte(4)
+		if instruction.Opcode == OpSar {
+			extension = 7
+		}
+		return e.modrm(true, []byte{0xc1}, extension, operands[0], []byte{byte(count)})
+	case OpSyscall:
+		e.code = append(e.code, 0x0f, 0x05)
+		return nil
+	case OpUd2:
+		e.code = append(e.code, 0x0f, 0x0b)
+		return nil
+	case OpMov:
+		return e.mov(operands[0], operands[1])
+	case OpMovzx:
+		destination, ok := operands[0].(Register)
+		if !ok {
+			return fmt.Errorf("the destination must be a register")
+		}
+		number, err := registerNumber(destination)
+		if err != nil {
+			return err
+		}
+		return e.modrm(true, []byte{0x0f, 0xb6}, number, operands[1], nil)
+	case OpCall:
+		if label, ok := operands[0].(Label); ok {
+			e.code = append(e.code, 0xe8)
+			e.relative(label)
+			return nil
+		}
+		return e.modrm(false, []byte{0xff}, 2, operands[0], nil)
+	case OpJmp:
+		if label, ok := operands[0].(Label); ok {
+			e.code = append(e.code, 0xe9)
+			e.relative(label)
+			return nil
+		}
+		return e.modrm(false, []byte{0xff}, 4, operands[0], nil)
+	case OpJe, OpJne, OpJg, OpJl, OpJle, OpJge:
+		label, ok := operands[0].(Label)
+		if !ok {
+			return fmt.Errorf("can only jump to labels")
+		}
+		e.code = append(e.code, 0x0f, conditions[instruction.Opcode])
+		e.relative(label)
+		return nil
+	}
+	return fmt.Errorf("unknown instruction")
+}
+
+func (e *encoder) arithmetic(opcode Opcode, destination Operand, source Operand) error {
+	encoding := arithmetic[opcode]
+	switch source := source.(type) {
+	case Register:
+		number, err := registerNumber(source)
+		if err != nil {
+			return err
+		}
+		return e.modrm(true, []byte{encoding.store}, number, destination, nil)
+	case Memory:
+		register, ok := destination.(Register)
+		if !ok {
+			return fmt.Errorf("only one operand can be in memory")
+		}
+		number, err := registerNumber(register)
+		if err != nil {
+			return err
+		}
+		return e.modrm(true, []byte{encoding.load}, number, source, nil)
+	case Immediate:
+		if memory, ok := destination.(Memory); ok && memory.Size == "byte" {
+			if !fitsInt8(source) {
+				return fmt.Errorf("immediate does not fit in a byte")
+			}
+			return e.modrm(false, []byte{0x80}, encoding.extension, destination, []byte{byte(source)})
+		}
+		if fitsInt8(source) {
+			return e.modrm(true, []byte{0x83}, encoding.extension, destination, []byte{byte(source)})
+		}
+		if fitsInt32(source) {
+			return e.modrm(true, []byte{0x81}, encoding.extension, destination, int32Bytes(int(source)))
+		}
+		return fmt.Errorf("immediate does not fit in 32 bits")
+	}
+	return fmt.Errorf("unsupported source operand")
+}
+
+func (e *encoder) mov(destination Operand, source Operand) error {
+	switch source := source.(type) {
+	case Register:
+		number, err := registerNumber(source)
+		if err != nil {
+			return err
+		}
+		if memory, ok := destination.(Memory); ok && (memory.Size == "byte" || source == AL) {
+			return e.modrm(false, []byte{0x88}, number, destination, nil)
+		}
+		return e.modrm(true, []byte{0x89}, number, destination, nil)
+	case Memory:
+		register, ok := destination.(Register)
+		if !ok {
+			return fmt.Errorf("only one operand can be in memory")
+		}
+		number, err := registerNumber(register)
+		if err != nil {
+			return err
+		}
+		return e.modrm(true, []byte{0x8b}, number, source, nil)
+	case Immediate:
+		if memory, ok := destination.(Memory); ok && memory.Size == "byte" {
+			return e.modrm(false, []byte{0xc6}, 0, destination, []byte{byte(source)})
+		}
+		if fitsInt32(source) {
+			return e.modrm(true, []byte{0xc7}, 0, destination, int32Bytes(int(source)))
+		}
+		register, ok := destination.(Register)
+		if !ok {
+			return fmt.Errorf("immediate does not fit in 32 bits")
+		}
+		immediate := make([]byte, 8)
+		binary.LittleEndian.PutUint64(immediate, uint64(source))
+		err := e.short(0xb8, register, true)
+		e.code = append(e.code, immediate...)
+		return err
+	case Label:
+		register, ok := destination.(Register)
+		if !ok {
+			return fmt.Errorf("the address of a label can only be moved to a register")
+		}
+		number, err := registerNumber(register)
+		if err != nil {
+			return err
+		}
+		if !e.library {
+			err = e.short(0xb8, register, true)
+			e.fixups = append(e.fixups, fixup{offset: len(e.code), relocation: relocation64, label: string(source)})
+			e.code = append(e.code, make([]byte, 8)...)
+			return err
+		}
+		// Libraries are position independent, like the printed lea and GOT loads
+		relocation := uint32(relocationPC32)
+		opcode := byte(0x8d)
+		if e.externs[string(source)] {
+			relocation = relocationGOTPCREL
+			opcode = 0x8b
+		}
+		e.rex(true, number, 0, 0)
+		e.code = append(e.code, opcode, 0x05|(number&7)<<3)
+		e.fixups = append(e.fixups, fixup{offset: len(e.code), relocation: relocation, label: string(source), addend: -4})
+		e.code = append(e.code, make([]byte, 4)...)
+		return nil
+	}
+	return fmt.Errorf("unsupported source operand")
+}
+
+// short encodes an instruction where the register is added to the opcode
+func (e *encoder) short(opcode byte, register Register, wide bool) error {
+	number, err := registerNumber(register)
+	if err != nil {
+		return err
+	}
+	e.rex(wide, 0, 0, number)
+	e.code = append(e.code, opcode+number&7)
+	return nil
+}
+
+// relative encodes the 32 bit offset to a label from the end of a jump or
+// call. Calls to other objects go through the PLT.
+func (e *encoder) relative(label Label) {
+	relocation := uint32(relocationPC32)
+	if e.externs[string(label)] {
+		relocation = relocationPLT32
+	}
+	e.fixups = append(e.fixups, fixup{offset: len(e.code), relocation: relocation, label: string(label), addend: -4})
+	e.code = append(e.code, make([]byte, 4)...)
+}
+
+// rex adds the REX prefix if the instruction is 64 bit or uses any of the
+// registers r8 to r15
+func (e *encoder) rex(wide bool, reg byte, index byte, base byte) {
+	var rex byte
+	if wide {
+		rex |= 8
+	}
+	rex |= (reg >> 3) << 2
+	rex |= (index >> 3) << 1
+	rex |= base >> 3
+	if rex != 0 {
+		e.code = append(e.code, 0x40|rex)
+	}
+}
+
+// modrm encodes an instruction with a ModRM byte, where reg is a register or
+// an opcode extension and rm is a register or a memory operand. The
+// immediate follows the address.
+func (e *encoder) modrm(wide bool, opcode []byte, reg byte, rm Operand, immediate []byte) error {
+	switch rm := rm.(type) {
+	case Register:
+		number, err := registerNumber(rm)
+		if err != nil {
+			return err
+		}
+		e.rex(wide, reg, 0, number)
+		e.code = append(e.code, opcode...)
+		e.code = append(e.code, 0xc0|(reg&7)<<3|number&7)
+	case Memory:
+		err := e.memory(wide, opcode, reg, rm, len(immediate))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("expected a register or memory operand")
+	}
+	e.code = append(e.code, immediate...)
+	return nil
+}
+
+func (e *encoder) memory(wide bool, opcode []byte, reg byte, memory Memory, trailing int) error {
+	if memory.Label != "" {
+		if memory.Base != "" || memory.Index != "" {
+			return fmt.Errorf("labels can not be combined with registers")
+		}
+		e.rex(wide, reg, 0, 0)
+		e.code = append(e.code, opcode...)
+		if e.library {
+			// Relative to the next instruction, which starts after the immediate
+			e.code = append(e.code, 0x05|(reg&7)<<3)
+			e.fixups = append(e.fixups, fixup{offset: len(e.code), relocation: relocationPC32, label: string(memory.Label), addend: int64(memory.Displacement - 4 - trailing)})
+		} else {
+			e.code = append(e.code, 0x04|(reg&7)<<3, 0x25)
+			e.fixups = append(e.fixups, fixup{offset: len(e.code), relocation: relocation32S, label: string(memory.Label), addend: int64(memory.Displacement)})
+		}
+		e.code = append(e.code, make([]byte, 4)...)
+		return nil
+	}
+	if memory.Base == "" {
+		return fmt.Errorf("memory operands need a base register")
+	}
+	base, err := registerNumber(memory.Base)
+	if err != nil {
+		return err
+	}
+	index := byte(4)
+	if memory.Index != "" {
+		index, err = registerNumber(memory.Index)
+		if err != nil {
+			return err
+		}
+		if index == 4 {
+			return fmt.Errorf("rsp can not be an index")
+		}
+	}
+	e.rex(wide, reg, index&8, base)
+	e.code = append(e.code, opcode...)
+
+	var mod byte
+	displacement := memory.Displacement
+	switch {
+	case displacement == 0 && base&7 != 5:
+		mod = 0
+	case displacement >= math.MinInt8 && displacement <= math.MaxInt8:
+		mod = 1
+	default:
+		mod = 2
+	}
+	if memory.Index == "" && base&7 != 4 {
+		e.code = append(e.code, mod<<6|(reg&7)<<3|base&7)
+	} else {
+		scales := map[int]byte{0: 0, 1: 0, 2: 1, 4: 2, 8: 3}
+		scale, ok := scales[memory.Scale]
+		if !ok {
+			return fmt.Errorf("invalid scale %d", memory.Scale)
+		}
+		e.code = append(e.code, mod<<6|(reg&7)<<3|4, scale<<6|(index&7)<<3|base&7)
+	}
+	switch mod {
+	case 1:
+		e.code = append(e.code, byte(int8(displacement)))
+	case 2:
+		e.code = append(e.code, int32Bytes(displacement)...)
+	}
+	return nil
+}
+
+func registerNumber(register Register) (byte, error) {
+	number, ok := registerNumbers[register]
+	if !ok {
+		return 0, fmt.Errorf("unknown register %s", register)
+	}
+	return number, nil
+}
+
+func fitsInt8(immediate Immediate) bool {
+	return immediate >= math.MinInt8 && immediate <= math.MaxInt8
+}
+
+func fitsInt32(immediate Immediate) bool {
+	return immediate >= math.MinInt32 && immediate <= math.MaxInt32
+}
+
+func int32Bytes(value int) []byte {
+	bytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bytes, uint32(int32(value)))
+	return bytes
+}
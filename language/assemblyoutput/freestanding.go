@@ -0,0 +1,209 @@
+package assemblyoutput
+
+// Linux system call numbers
+const (
+	sysWrite     = 1
+	sysMmap      = 9
+	sysExitGroup = 231
+)
+
+const (
+	// outputBufferSize is the number of bytes that are written at most in a
+	// single system call
+	outputBufferSize = 4096
+	// heapChunkSize is the smallest amount of memory that is mapped at a time
+	heapChunkSize = 1 << 20
+)
+
+// Exit flushes the output and terminates the process with exit code 0
+func (ao *AssemblyOutput) Exit() {
+	ao.Call(FlushOutput)
+	ao.Mov(RDI, Immediate(0))
+	ao.Mov(RAX, Immediate(sysExitGroup))
+	ao.Syscall()
+}
+
+// freestandingRuntime generates the procedures that replace printf, malloc
+// and free when the program is not linked with libc. They follow the same
+// calling convention, so the rest of the program does not change.
+func (ao *AssemblyOutput) freestandingRuntime() {
+	ao.Data = append(ao.Data, Data{Label: OutputBuffer, Bytes: make([]byte, outputBufferSize)})
+	ao.NewGlobal(string(OutputLength))
+	ao.NewGlobal(string(HeapNext))
+	ao.NewGlobal(string(HeapEnd))
+
+	// Procedure for printing a value with a format, which supports the %d
+	// and %c conversions that are used by println
+	// RDI: format, RSI: value to print
+	ao.NewSection(string(Format))
+	ao.Mov(R8, RDI)
+	ao.Mov(R9, RSI)
+
+	ao.NewSection("formatLoopStart")
+	ao.Movzx(RAX, Memory{Size: "byte", Base: R8})
+	ao.Cmp(RAX, Immediate(0))
+	ao.Je("formatLoopEnd")
+	ao.Cmp(RAX, Immediate('%'))
+	ao.Jne("formatLiteral")
+	ao.Add(R8, Immediate(1))
+	ao.Movzx(RAX, Memory{Size: "byte", Base: R8})
+	ao.Cmp(RAX, Immediate('d'))
+	ao.Je("formatDecimal")
+	ao.Mov(RAX, R9)
+	ao.Call(WriteChar)
+	ao.Jmp("formatNext")
+
+	ao.NewSection("formatDecimal")
+	ao.Mov(RAX, R9)
+	ao.Call(WriteDecimal)
+	ao.Jmp("formatNext")
+
+	ao.NewSection("formatLiteral")
+	ao.Call(WriteChar)
+
+	ao.NewSection("formatNext")
+	ao.Add(R8, Immediate(1))
+	ao.Jmp("formatLoopStart")
+
+	ao.NewSection("formatLoopEnd")
+	ao.Ret()
+
+	// Procedure for printing the low 32 bits of a register as a signed
+	// decimal number, like %d in printf
+	// RAX: number to print
+	ao.NewSection(string(WriteDecimal))
+	ao.Shl(RAX, 32)
+	ao.Sar(RAX, 32)
+	ao.Mov(RDI, Immediate(0))
+	ao.Cmp(RAX, Immediate(0))
+	ao.Jge("writeDecimalDivide")
+	ao.Push(RAX)
+	ao.Mov(RAX, Immediate('-'))
+	ao.Call(WriteChar)
+	ao.Pop(RAX)
+	ao.Neg(RAX)
+
+	// The digits are pushed from the least significant one, so they are
+	// popped in the order they are printed
+	ao.NewSection("writeDecimalDivide")
+	ao.Mov(RCX, Immediate(10))
+	ao.Mov(RDX, Immediate(0))
+	ao.Div(RCX)
+	ao.Push(RDX)
+	ao.Add(RDI, Immediate(1))
+	ao.Cmp(RAX, Immediate(0))
+	ao.Jne("writeDecimalDivide")
+
+	ao.NewSection("writeDecimalDigit")
+	ao.Pop(RAX)
+	ao.Add(RAX, Immediate('0'))
+	ao.Call(WriteChar)
+	ao.Sub(RDI, Immediate(1))
+	ao.Cmp(RDI, Immediate(0))
+	ao.Jne("writeDecimalDigit")
+	ao.Ret()
+
+	// Procedure for adding a character to the output buffer, which is
+	// flushed first when it is full
+	// RAX: character to print
+	ao.NewSection(string(WriteChar))
+	ao.Push(RCX)
+	ao.Push(RDI)
+	ao.Mov(RCX, Memory{Size: "qword", Label: OutputLength})
+	ao.Cmp(RCX, Immediate(outputBufferSize))
+	ao.Jl("writeCharStore")
+	ao.Call(FlushOutput)
+	ao.Mov(RCX, Immediate(0))
+
+	ao.NewSection("writeCharStore")
+	ao.Mov(RDI, OutputBuffer)
+	ao.Mov(Memory{Size: "byte", Base: RDI, Index: RCX, Scale: 1}, AL)
+	ao.Add(RCX, Immediate(1))
+	ao.Mov(Memory{Size: "qword", Label: OutputLength}, RCX)
+	ao.Pop(RDI)
+	ao.Pop(RCX)
+	ao.Ret()
+
+	// Procedure for writing the output buffer to stdout, and emptying it.
+	// The write is repeated until everything is written or it fails.
+	ao.NewSection(string(FlushOutput))
+	ao.Push(RAX)
+	ao.Push(RCX)
+	ao.Push(RDX)
+	ao.Push(RSI)
+	ao.Push(RDI)
+	ao.Mov(RSI, OutputBuffer)
+	ao.Mov(RDX, Memory{Size: "qword", Label: OutputLength})
+
+	ao.NewSection("flushOutputLoopStart")
+	ao.Cmp(RDX, Immediate(0))
+	ao.Jle("flushOutputLoopEnd")
+	ao.Mov(RAX, Immediate(sysWrite))
+	ao.Mov(RDI, Immediate(1))
+	ao.Syscall()
+	ao.Cmp(RAX, Immediate(0))
+	ao.Jl("flushOutputLoopEnd")
+	ao.Add(RSI, RAX)
+	ao.Sub(RDX, RAX)
+	ao.Jmp("flushOutputLoopStart")
+
+	ao.NewSection("flushOutputLoopEnd")
+	ao.Mov(Memory{Size: "qword", Label: OutputLength}, Immediate(0))
+	ao.Pop(RDI)
+	ao.Pop(RSI)
+	ao.Pop(RDX)
+	ao.Pop(RCX)
+	ao.Pop(RAX)
+	ao.Ret()
+
+	// Procedure for allocating memory with a bump allocator. Memory is mapped
+	// from the kernel in chunks, and the rest of a chunk is thrown away when
+	// an allocation does not fit in it. Returns 0 if no memory can be mapped.
+	// RDI: number of bytes, returns the address in RAX
+	ao.NewSection(string(Allocate))
+	ao.Add(RDI, Immediate(7))
+	ao.And(RDI, Immediate(-8))
+	ao.Mov(RAX, Memory{Size: "qword", Label: HeapNext})
+	ao.Mov(RCX, RAX)
+	ao.Add(RCX, RDI)
+	ao.Cmp(RCX, Memory{Size: "qword", Label: HeapEnd})
+	ao.Jle("allocateBump")
+
+	ao.Mov(RSI, RDI)
+	ao.Cmp(RSI, Immediate(heapChunkSize))
+	ao.Jge("allocateMap")
+	ao.Mov(RSI, Immediate(heapChunkSize))
+
+	// mmap(0, size, PROT_READ | PROT_WRITE, MAP_PRIVATE | MAP_ANONYMOUS, -1, 0)
+	ao.NewSection("allocateMap")
+	ao.Push(RDI)
+	ao.Push(RSI)
+	ao.Mov(RAX, Immediate(sysMmap))
+	ao.Mov(RDI, Immediate(0))
+	ao.Mov(RDX, Immediate(3))
+	ao.Mov(R10, Immediate(0x22))
+	ao.Mov(R8, Immediate(-1))
+	ao.Mov(R9, Immediate(0))
+	ao.Syscall()
+	ao.Pop(RSI)
+	ao.Pop(RDI)
+	ao.Cmp(RAX, Immediate(0))
+	ao.Jl("allocateFailed")
+	ao.Mov(RCX, RAX)
+	ao.Add(RCX, RSI)
+	ao.Mov(Memory{Size: "qword", Label: HeapEnd}, RCX)
+	ao.Mov(RCX, RAX)
+	ao.Add(RCX, RDI)
+
+	ao.NewSection("allocateBump")
+	ao.Mov(Memory{Size: "qword", Label: HeapNext}, RCX)
+	ao.Ret()
+
+	ao.NewSection("allocateFailed")
+	ao.Mov(RAX, Immediate(0))
+	ao.Ret()
+
+	// Memory is never given back to the kernel, so free does nothing
+	ao.NewSection(string(Deallocate))
+	ao.Ret()
+}
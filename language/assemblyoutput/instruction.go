@@ -0,0 +1,202 @@
+package assemblyoutput
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Opcode int
+
+const (
+	OpLabel Opcode = iota
+	OpPush
+	OpPop
+	OpAdd
+	OpSub
+	OpImul
+	OpDiv
+	OpMov
+	OpMovzx
+	OpXor
+	OpAnd
+	OpCmp
+	OpCall
+	OpRet
+	OpJmp
+	OpJe
+	OpJne
+	OpJg
+	OpJl
+	OpJle
+	OpJge
+	OpShl
+	OpSar
+	OpNeg
+	OpSyscall
+	OpUd2
+)
+
+var mnemonics = map[Opcode]string{
+	OpPush:    "push",
+	OpPop:     "pop",
+	OpAdd:     "add",
+	OpSub:     "sub",
+	OpImul:    "imul",
+	OpDiv:     "div",
+	OpMov:     "mov",
+	OpMovzx:   "movzx",
+	OpXor:     "xor",
+	OpAnd:     "and",
+	OpCmp:     "cmp",
+	OpCall:    "call",
+	OpRet:     "ret",
+	OpJmp:     "jmp",
+	OpJe:      "je",
+	OpJne:     "jne",
+	OpJg:      "jg",
+	OpJl:      "jl",
+	OpJle:     "jle",
+	OpJge:     "jge",
+	OpShl:     "shl",
+	OpSar:     "sar",
+	OpNeg:     "neg",
+	OpSyscall: "syscall",
+	OpUd2:     "ud2",
+}
+
+func (op Opcode) String() string {
+	return mnemonics[op]
+}
+
+// IsConditionalJump is true for the jumps that depend on the flags set by cmp
+func (op Opcode) IsConditionalJump() bool {
+	switch op {
+	case OpJe, OpJne, OpJg, OpJl, OpJle, OpJge:
+		return true
+	}
+	return false
+}
+
+// Operand is a register, an immediate, the address of a label or a memory
+// reference. String formats the operand with NASM syntax.
+type Operand interface {
+	String() string
+	Uses(r Register) bool
+}
+
+type Register string
+
+type Immediate int
+
+type Label string
+
+// Memory is the address [Label+Base+Index*Scale+Displacement], where all parts are optional
+type Memory struct {
+	Size         string
+	Label        Label
+	Base         Register
+	Index        Register
+	Scale        int
+	Displacement int
+}
+
+func (r Register) String() string {
+	return string(r)
+}
+
+func (r Register) Uses(other Register) bool {
+	return r == other
+}
+
+func (i Immediate) String() string {
+	return fmt.Sprintf("%d", int(i))
+}
+
+func (i Immediate) Uses(Register) bool {
+	return false
+}
+
+func (l Label) String() string {
+	return string(l)
+}
+
+func (l Label) Uses(Register) bool {
+	return false
+}
+
+// String formats the operand for NASM. A displacement of zero is left out,
+// so the top of the stack is [rsp].
+func (m Memory) String() string {
+	var parts []string
+	if m.Label != "" {
+		parts = append(parts, string(m.Label))
+	}
+	if m.Base != "" {
+		parts = append(parts, string(m.Base))
+	}
+	if m.Index != "" {
+		parts = append(parts, fmt.Sprintf("%s*%d", m.Index, m.Scale))
+	}
+	address := strings.Join(parts, "+")
+	if m.Displacement > 0 && address != "" {
+		address += fmt.Sprintf("+%d", m.Displacement)
+	} else if m.Displacement != 0 || address == "" {
+		address += fmt.Sprintf("%d", m.Displacement)
+	}
+	if m.Size != "" {
+		return fmt.Sprintf("%s [%s]", m.Size, address)
+	}
+	return fmt.Sprintf("[%s]", address)
+}
+
+func (m Memory) Uses(r Register) bool {
+	return m.Base == r || m.Index == r
+}
+
+// StackAddress is the qword at the given offset from the stack pointer
+func StackAddress(offset int) Memory {
+	return Memory{
+		Base:         RSP,
+		Displacement: offset,
+	}
+}
+
+// FrameAddress is the qword at the given offset from the frame pointer
+func FrameAddress(offset int) Memory {
+	return Memory{
+		Base:         RBP,
+		Displacement: offset,
+	}
+}
+
+// Instruction is a single operation, or the definition of a label if the
+// opcode is OpLabel
+type Instruction struct {
+	Opcode   Opcode
+	Operands []Operand
+	Label    string
+}
+
+func (ins Instruction) String() string {
+	if ins.Opcode == OpLabel {
+		return ins.Label + ":"
+	}
+	if len(ins.Operands) == 0 {
+		return ins.Opcode.String()
+	}
+	var operands []string
+	for _, operand := range ins.Operands {
+		operands = append(operands, operand.String())
+	}
+	return fmt.Sprintf("%s %s", ins.Opcode, strings.Join(operands, ", "))
+}
+
+// Uses is true if any operand of the instruction reads or writes the register
+func (ins Instruction) Uses(r Register) bool {
+	for _, operand := range ins.Operands {
+		if operand.Uses(r) {
+			return true
+		}
+	}
+	return false
+}
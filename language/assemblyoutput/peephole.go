@@ -0,0 +1,239 @@
+package assemblyoutput
+
+import (
+	"regexp"
+)
+
+var negatedJumps = map[Opcode]Opcode{
+	OpJe:  OpJne,
+	OpJne: OpJe,
+	OpJg:  OpJle,
+	OpJle: OpJg,
+	OpJl:  OpJge,
+	OpJge: OpJl,
+}
+
+var registers = []Register{RAX, RBX, RCX, RDX, RSI, RDI, R12, R13, R14, R15}
+
+var uniqueLabel = regexp.MustCompile(`^unique[0-9]+$`)
+
+// Optimize rewrites the main operations and all procedures with a peephole
+// optimiser until no more rewrites apply. The observable behaviour of the
+// program is unchanged.
+func (ao *AssemblyOutput) Optimize() {
+	for {
+		referenced := ao.referencedLabels()
+		changed := false
+		var done bool
+		ao.MainOperations, done = optimize(ao.MainOperations, referenced)
+		changed = changed || done
+		for _, procedure := range ao.EvaluatedProcedures {
+			procedure.Operations, done = optimize(procedure.Operations, referenced)
+			changed = changed || done
+		}
+		if !changed {
+			return
+		}
+	}
+}
+
+func (ao *AssemblyOutput) referencedLabels() map[string]bool {
+	referenced := make(map[string]bool)
+	add := func(operations []Instruction) {
+		for _, operation := range operations {
+			for _, operand := range operation.Operands {
+				switch operand := operand.(type) {
+				case Label:
+					referenced[string(operand)] = true
+				case Memory:
+					referenced[string(operand.Label)] = true
+				}
+			}
+		}
+	}
+	add(ao.MainOperations)
+	for _, procedure := range ao.EvaluatedProcedures {
+		add(procedure.Operations)
+	}
+	return referenced
+}
+
+// optimize makes a single pass over the operations, and reports whether anything changed
+func optimize(operations []Instruction, referenced map[string]bool) ([]Instruction, bool) {
+	var result []Instruction
+	changed := false
+	for i := 0; i < len(operations); i++ {
+		ins := operations[i]
+		if ins.Opcode == OpLabel {
+			if uniqueLabel.MatchString(ins.Label) && !referenced[ins.Label] {
+				changed = true
+				continue
+			}
+			result = append(result, ins)
+			continue
+		}
+
+		// mov rax, rax
+		if ins.Opcode == OpMov && ins.Operands[0] == ins.Operands[1] {
+			changed = true
+			continue
+		}
+
+		// add rsp, 0
+		if (ins.Opcode == OpAdd || ins.Opcode == OpSub) && ins.Operands[1] == Immediate(0) {
+			changed = true
+			continue
+		}
+
+		// A jump to the label that follows it
+		if ins.Opcode == OpJmp || ins.Opcode.IsConditionalJump() {
+			if jumpsToFollowingLabel(ins.Operands[0], operations[i+1:]) {
+				changed = true
+				continue
+			}
+		}
+
+		// jmp or ret followed by code that can not be reached
+		if ins.Opcode == OpJmp || ins.Opcode == OpRet {
+			result = append(result, ins)
+			for i+1 < len(operations) && operations[i+1].Opcode != OpLabel {
+				changed = true
+				i++
+			}
+			continue
+		}
+
+		// je a, jne b, a: is the same as jne b, a:
+		if negated, ok := negatedJumps[ins.Opcode]; ok && i+2 < len(operations) {
+			next := operations[i+1]
+			if next.Opcode == negated && jumpsToFollowingLabel(ins.Operands[0], operations[i+2:]) {
+				changed = true
+				continue
+			}
+		}
+
+		// push a, ..., pop b
+		if ins.Opcode == OpPush {
+			if end, ok := matchingPop(operations, i); ok {
+				pop := operations[end]
+				if pop.Operands[0] != ins.Operands[0] {
+					result = append(result, Instruction{
+						Opcode:   OpMov,
+						Operands: []Operand{pop.Operands[0], ins.Operands[0]},
+					})
+				}
+				result = append(result, operations[i+1:end]...)
+				i = end
+				changed = true
+				continue
+			}
+		}
+
+		if ins.Opcode == OpMov && isRegister(ins.Operands[0]) && i+1 < len(operations) {
+			register := ins.Operands[0].(Register)
+			next := operations[i+1]
+
+			// mov rax, a, mov rax, b
+			if next.Opcode == OpMov && next.Operands[0] == register && !next.Operands[1].Uses(register) {
+				changed = true
+				continue
+			}
+
+			// mov rax, 1, cmp rax, 1, je a
+			if next.Opcode == OpCmp && next.Operands[0] == register {
+				left, leftOk := ins.Operands[1].(Immediate)
+				right, rightOk := next.Operands[1].(Immediate)
+				if leftOk && rightOk {
+					result = append(result, ins)
+					i++
+					for i+1 < len(operations) && operations[i+1].Opcode.IsConditionalJump() {
+						jump := operations[i+1]
+						if isTaken(jump.Opcode, int(left), int(right)) {
+							result = append(result, Instruction{
+								Opcode:   OpJmp,
+								Operands: jump.Operands,
+							})
+						}
+						i++
+					}
+					changed = true
+					continue
+				}
+			}
+		}
+
+		result = append(result, ins)
+	}
+	return result, changed
+}
+
+// matchingPop finds a pop after the push at index start, where the operations
+// in between neither use the stack nor the popped register. The pushed value
+// can then be moved directly into the popped register instead.
+func matchingPop(operations []Instruction, start int) (int, bool) {
+	var between []Instruction
+	for i := start + 1; i < len(operations); i++ {
+		ins := operations[i]
+		if ins.Opcode == OpPop {
+			register, ok := ins.Operands[0].(Register)
+			if !ok {
+				return 0, false
+			}
+			for _, other := range between {
+				if other.Uses(register) {
+					return 0, false
+				}
+			}
+			return i, true
+		}
+		switch ins.Opcode {
+		case OpMov, OpAdd, OpSub, OpImul, OpXor:
+		default:
+			return 0, false
+		}
+		if ins.Uses(RSP) {
+			return 0, false
+		}
+		between = append(between, ins)
+	}
+	return 0, false
+}
+
+func jumpsToFollowingLabel(target Operand, operations []Instruction) bool {
+	for _, operation := range operations {
+		if operation.Opcode != OpLabel {
+			return false
+		}
+		if Label(operation.Label) == target {
+			return true
+		}
+	}
+	return false
+}
+
+func isTaken(jump Opcode, left int, right int) bool {
+	switch jump {
+	case OpJe:
+		return left == right
+	case OpJne:
+		return left != right
+	case OpJg:
+		return left > right
+	case OpJle:
+		return left <= right
+	case OpJl:
+		return left < right
+	case OpJge:
+		return left >= right
+	}
+	return false
+}
+
+func isRegister(operand Operand) bool {
+	for _, register := range registers {
+		if operand == register {
+			return true
+		}
+	}
+	return false
+}
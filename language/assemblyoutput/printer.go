@@ -0,0 +1,94 @@
+package assemblyoutput
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Nasm formats the whole program as NASM assembly for x86-64. Strings and
+// globals are in the .data section, and the instructions in .text.
+func (ao *AssemblyOutput) Nasm() string {
+	var builder strings.Builder
+	if ao.Library {
+		builder.WriteString("default rel\n")
+	}
+	for _, extern := range ao.Externs {
+		builder.WriteString(fmt.Sprintf("extern %s\n", extern))
+	}
+	if !ao.Library {
+		builder.WriteString(fmt.Sprintf("global %s\n", ao.Entry()))
+	}
+	for _, export := range ao.Exports {
+		builder.WriteString(fmt.Sprintf("global %s:function\n", export))
+	}
+	if ao.Library {
+		builder.WriteString("section .init_array\n")
+		builder.WriteString(fmt.Sprintf("dq %s\n", Initialize))
+	}
+	builder.WriteString("section .data\n")
+	for _, data := range ao.Data {
+		builder.WriteString(fmt.Sprintf("%s: db %s\n", data.Label, nasmBytes(data.Bytes)))
+	}
+	for _, global := range ao.Globals {
+		builder.WriteString(fmt.Sprintf("%s: dq 0\n", global))
+	}
+	builder.WriteString("section .text\n")
+	for _, instruction := range ao.MainOperations {
+		builder.WriteString(ao.nasmInstruction(instruction) + "\n")
+	}
+	for _, procedure := range ao.EvaluatedProcedures {
+		builder.WriteString(procedure.Name + ":\n")
+		for _, instruction := range procedure.Operations {
+			builder.WriteString(ao.nasmInstruction(instruction) + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// nasmInstruction formats an instruction, which has to be position
+// independent for libraries. Memory operands are already relative to rip
+// with default rel, so only addresses of labels are changed. Symbols from
+// other objects are reached through the PLT and the GOT.
+func (ao *AssemblyOutput) nasmInstruction(instruction Instruction) string {
+	if !ao.Library || len(instruction.Operands) == 0 {
+		return instruction.String()
+	}
+	label, ok := instruction.Operands[len(instruction.Operands)-1].(Label)
+	if !ok {
+		return instruction.String()
+	}
+	extern := false
+	for _, name := range ao.Externs {
+		extern = extern || name == string(label)
+	}
+	switch {
+	case instruction.Opcode == OpCall && extern:
+		return fmt.Sprintf("call %s wrt ..plt", label)
+	case instruction.Opcode == OpMov && extern:
+		return fmt.Sprintf("mov %s, [rel %s wrt ..got]", instruction.Operands[0], label)
+	case instruction.Opcode == OpMov:
+		return fmt.Sprintf("lea %s, [rel %s]", instruction.Operands[0], label)
+	}
+	return instruction.String()
+}
+
+// nasmBytes formats bytes as a db argument, with printable characters quoted
+func nasmBytes(bytes []byte) string {
+	var parts []string
+	quoted := ""
+	for _, b := range bytes {
+		if b >= ' ' && b <= '~' && b != '\'' {
+			quoted += string(b)
+			continue
+		}
+		if quoted != "" {
+			parts = append(parts, fmt.Sprintf("'%s'", quoted))
+			quoted = ""
+		}
+		parts = append(parts, fmt.Sprintf("%d", b))
+	}
+	if quoted != "" {
+		parts = append(parts, fmt.Sprintf("'%s'", quoted))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -1,8 +1,15 @@
 package assemblyoutput
 
 type procedure struct {
-	Name                              string
-	StackSizeBeforeFunctionGeneration int
-	NumberOfArgs                      int
-	Operations                        []string
+	Name       string
+	Operations []Instruction
+	frame      frame
+}
+
+// frame is the layout of the stack frame of a procedure
+type frame struct {
+	// sizeIndex is the index of the instruction that reserves the frame
+	sizeIndex int
+	// saved are the registers stored in the first slots of the frame
+	saved []Register
 }
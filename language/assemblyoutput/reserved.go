@@ -0,0 +1,107 @@
+package assemblyoutput
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	runtimeSymbols     map[string]bool
+	runtimeSymbolsOnce sync.Once
+)
+
+// ReservedSymbol reports whether a symbol is used by the generated code
+// itself, so that a procedure with the same name would clash with it. This
+// is the entry point, the procedures, data and globals of every runtime, the
+// C functions they call, the prefixes used for generated labels, and the
+// keywords of the assembler.
+func ReservedSymbol(name string) bool {
+	runtimeSymbolsOnce.Do(collectRuntimeSymbols)
+	if runtimeSymbols[name] || AssemblerKeyword(name) {
+		return true
+	}
+	for _, prefix := range []string{"fn_", "extern_"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	counter := strings.TrimPrefix(name, "unique")
+	return counter != name && counter != "" && strings.Trim(counter, "0123456789") == ""
+}
+
+// collectRuntimeSymbols generates an empty program for every kind of output,
+// and records the symbols it defines or uses
+func collectRuntimeSymbols() {
+	runtimeSymbols = make(map[string]bool)
+	for _, ao := range []*AssemblyOutput{
+		{Library: false, Freestanding: false},
+		{Library: true, Freestanding: false},
+		{Library: false, Freestanding: true},
+	} {
+		ao.procedureStack = NewProcedureStack()
+		ao.Start()
+		ao.EnterFrame(nil)
+		ao.End(0)
+		labels := func(instructions []Instruction) {
+			for _, instruction := range instructions {
+				if instruction.Opcode == OpLabel {
+					runtimeSymbols[instruction.Label] = true
+				}
+			}
+		}
+		labels(ao.MainOperations)
+		for _, procedure := range ao.EvaluatedProcedures {
+			runtimeSymbols[procedure.Name] = true
+			labels(procedure.Operations)
+		}
+		for _, data := range ao.Data {
+			runtimeSymbols[string(data.Label)] = true
+		}
+		for _, symbol := range append(ao.Globals, ao.Externs...) {
+			runtimeSymbols[symbol] = true
+		}
+	}
+}
+
+// assemblerKeywords are the registers, sizes, directives and operators of
+// NASM, which it does not read as symbols
+var assemblerKeywords = collectAssemblerKeywords()
+
+// AssemblerKeyword reports whether NASM reads the name as a keyword instead
+// of a symbol. Keywords are not case sensitive.
+func AssemblerKeyword(name string) bool {
+	return assemblerKeywords[strings.ToLower(name)]
+}
+
+func collectAssemblerKeywords() map[string]bool {
+	keywords := make(map[string]bool)
+	for _, group := range []string{
+		"rax rbx rcx rdx rsi rdi rsp rbp rip eax ebx ecx edx esi edi esp ebp eip",
+		"ax bx cx dx si di sp bp al bl cl dl ah bh ch dh sil dil spl bpl",
+		"cs ds es fs gs ss",
+		"byte word dword qword tword oword yword zword",
+		"db dw dd dq dt do dy dz resb resw resd resq rest reso resy resz incbin equ times dup",
+		"bits use16 use32 use64 default section segment absolute extern global common static required",
+		"cpu float org align alignb struc endstruc istruc iend at",
+		"seg wrt rel abs strict near far short to nosplit",
+	} {
+		for _, keyword := range strings.Fields(group) {
+			keywords[keyword] = true
+		}
+	}
+	for i := 8; i <= 15; i++ {
+		for _, suffix := range []string{"", "d", "w", "b", "l"} {
+			keywords[fmt.Sprintf("r%d%s", i, suffix)] = true
+		}
+	}
+	for _, bank := range []struct {
+		prefix string
+		count  int
+	}{{"cr", 16}, {"dr", 16}, {"tr", 8}, {"st", 8}, {"mm", 8}, {"xmm", 32}, {"ymm", 32}, {"zmm", 32}, {"k", 8}, {"bnd", 4}} {
+		for i := 0; i < bank.count; i++ {
+			keywords[fmt.Sprintf("%s%d", bank.prefix, i)] = true
+		}
+	}
+	return keywords
+}
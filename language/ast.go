@@ -14,14 +14,17 @@ type Stmt interface {
 	Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error
 }
 
+// ExpBop is a binary operator. The Position of an operator is where the
+// operator is.
 type ExpBop interface {
 	LeftExp() Exp
 	RightExp() Exp
 }
 
 type ExpPlus struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpPlus) LeftExp() Exp {
@@ -33,8 +36,9 @@ func (exp ExpPlus) RightExp() Exp {
 }
 
 type ExpMinus struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpMinus) LeftExp() Exp {
@@ -46,8 +50,9 @@ func (exp ExpMinus) RightExp() Exp {
 }
 
 type ExpDivide struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpDivide) LeftExp() Exp {
@@ -59,8 +64,9 @@ func (exp ExpDivide) RightExp() Exp {
 }
 
 type ExpModulo struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpModulo) LeftExp() Exp {
@@ -71,13 +77,16 @@ func (exp ExpModulo) RightExp() Exp {
 	return exp.Right
 }
 
+// Position is where the - is
 type ExpNegative struct {
-	Inside Exp
+	Inside   Exp
+	Position Position
 }
 
 type ExpMultiply struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpMultiply) LeftExp() Exp {
@@ -89,8 +98,9 @@ func (exp ExpMultiply) RightExp() Exp {
 }
 
 type ExpLess struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpLess) LeftExp() Exp {
@@ -102,8 +112,9 @@ func (exp ExpLess) RightExp() Exp {
 }
 
 type ExpGreater struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpGreater) LeftExp() Exp {
@@ -115,8 +126,9 @@ func (exp ExpGreater) RightExp() Exp {
 }
 
 type ExpEquals struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpEquals) LeftExp() Exp {
@@ -128,8 +140,9 @@ func (exp ExpEquals) RightExp() Exp {
 }
 
 type ExpNotEquals struct {
-	Left  Exp
-	Right Exp
+	Left     Exp
+	Right    Exp
+	Position Position
 }
 
 func (exp ExpNotEquals) LeftExp() Exp {
@@ -140,76 +153,128 @@ func (exp ExpNotEquals) RightExp() Exp {
 	return exp.Right
 }
 
+// Position is where the ( is
 type ExpParentheses struct {
-	Inside Exp
+	Inside   Exp
+	Position Position
 }
 
+// Positions of literals are where the literal is
 type ExpNum struct {
-	Value int
+	Value    int
+	Position Position
 }
 
 type ExpChar struct {
-	Value string
+	Value    string
+	Position Position
 }
 
 type ExpBool struct {
-	Value bool
+	Value    bool
+	Position Position
 }
 
+type ExpNone struct {
+	Position Position
+}
+
+// Position is where the some keyword is
+type ExpSome struct {
+	Inside   Exp
+	Position Position
+}
+
+// Positions are only set when the program is parsed with positions, so
+// editors can map them back to the source
 type ExpIdentifier struct {
-	Name string
+	Name     string
+	Position Position
 }
 
+// Position is where the < of the list or the quote of the string is
 type ExpList struct {
 	Elements []Exp
 	Type     typesystem.Type
 	Size     int
+	Position Position
 }
 
+// Position is where the ( is
+type ExpTuple struct {
+	Elements []Exp
+	Position Position
+}
+
+// Position is where the [ of the index is
 type ExpGetFromList struct {
-	List  Exp
-	Index Exp
+	List     Exp
+	Index    Exp
+	Position Position
 }
 
+// Position is where the name of the field is
 type ExpReadFromStruct struct {
-	Field  string
-	Struct Exp
+	Field    string
+	Struct   Exp
+	Position Position
 }
 
 type StmtUpdateList struct {
-	List Exp
-	Index Exp
+	List     Exp
+	Index    Exp
 	NewValue Exp
 }
 
 type StmtUpdateStruct struct {
-	Struct Exp
-	Member string
+	Struct   Exp
+	Member   string
 	NewValue Exp
+	Position Position
 }
 
+// Position is where the len keyword is
 type ExpLength struct {
-	List Exp
+	List     Exp
+	Position Position
 }
 
+// Positions are where the names of the arguments are, and Position is where
+// the first | is
 type ExpFunction struct {
-	Recurse string
-	Body    Stmt
-	Type    typesystem.Type
+	Recurse   string
+	Body      Stmt
+	Type      typesystem.Type
+	Positions []Position
+	Position  Position
 }
 
+// Position is where the # of the call is
 type FunctionCall struct {
 	Exp       Exp
 	Arguments []Exp
+	Position  Position
 }
 
+// Positions are where the statements start, and End is where the token
+// after the last statement is. Start is where the { of a body is.
 type StmtSeq struct {
 	Statements []Stmt
+	Positions  []Position
+	Start      Position
+	End        Position
 }
 
+// Identifiers is used instead of Identifier when destructuring a tuple.
+// Type is the annotated type of the identifier, or nil if it has none.
+// Positions are where each of the identifiers are.
 type StmtAssign struct {
-	Identifier string
-	Expression Exp
+	Identifier  string
+	Expression  Exp
+	Identifiers []string
+	Constant    bool
+	Type        *typesystem.Type
+	Positions   []Position
 }
 
 type StmtPrintln struct {
@@ -230,16 +295,62 @@ type StmtLoop struct {
 	Body      Stmt
 }
 
+// Position is where the identifier is
+type StmtIfSome struct {
+	Identifier string
+	Expression Exp
+	Body       Stmt
+	Position   Position
+}
+
+type StmtLoopSome struct {
+	Identifier string
+	Expression Exp
+	Body       Stmt
+	Position   Position
+}
+
+// StmtBlock is a body that always runs in its own scope, like the body of if true
+type StmtBlock struct {
+	Body Stmt
+}
+
+// StmtUnreachable is a body that never runs, like the body of if false. It is
+// still type checked, but no code is generated for it.
+type StmtUnreachable struct {
+	Body Stmt
+}
+
+// Positions are where the names of the members are
 type StmtStructDeclaration struct {
-	Type typesystem.Type
+	Type      typesystem.Type
+	Positions []Position
+}
+
+type StmtFunctionDeclaration struct {
+	Name     string
+	Function ExpFunction
+	// Exported functions can be called from C with their own name
+	Exported bool
+	Position Position
+}
+
+// StmtExtern declares a C function that the program is linked with
+type StmtExtern struct {
+	Name     string
+	Type     typesystem.Type
+	Position Position
 }
 
+// Position is where the @ is
 type StructExp struct {
-	Name    string
-	Members []StructMember
+	Name     string
+	Members  []StructMember
+	Position Position
 }
 
 type StructMember struct {
-	Name string
-	Exp  Exp
+	Name     string
+	Exp      Exp
+	Position Position
 }
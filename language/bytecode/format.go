@@ -0,0 +1,199 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Magic starts every .cmmb file, and is followed by Version
+const Magic = "CMMB"
+
+// Version is increased whenever the format or the meaning of an opcode
+// changes, so old files are rejected instead of running incorrectly
+const Version = 1
+
+// Encode serialises the program. After the magic and the version come the
+// number of globals, the strings and the functions. Numbers are varints,
+// and strings are prefixed with their length.
+func (program *Program) Encode() []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(Magic)
+	writeNumber(&buffer, Version)
+	writeNumber(&buffer, int64(program.Globals))
+	writeNumber(&buffer, int64(len(program.Strings)))
+	for _, value := range program.Strings {
+		writeString(&buffer, value)
+	}
+	writeNumber(&buffer, int64(len(program.Functions)))
+	for _, function := range program.Functions {
+		writeString(&buffer, function.Name)
+		writeNumber(&buffer, int64(function.Arguments))
+		writeNumber(&buffer, int64(function.Locals))
+		extern := int64(0)
+		if function.Extern {
+			extern = 1
+		}
+		writeNumber(&buffer, extern)
+		writeNumber(&buffer, int64(len(function.Code)))
+		for _, instruction := range function.Code {
+			buffer.WriteByte(byte(instruction.Op))
+			writeNumber(&buffer, instruction.A)
+			writeNumber(&buffer, instruction.B)
+		}
+	}
+	return buffer.Bytes()
+}
+
+// Decode reads a program that was serialised with Encode, and checks that
+// every instruction only refers to functions, strings, variables and
+// instructions that exist
+func Decode(data []byte) (*Program, error) {
+	if !bytes.HasPrefix(data, []byte(Magic)) {
+		return nil, fmt.Errorf("not a bytecode file")
+	}
+	reader := &reader{data: data[len(Magic):]}
+	version := reader.number()
+	if reader.err == nil && version != Version {
+		return nil, fmt.Errorf("unsupported bytecode version %d, expected %d", version, Version)
+	}
+
+	program := &Program{Globals: reader.count()}
+	strings := reader.count()
+	for i := 0; i < strings && reader.err == nil; i++ {
+		program.Strings = append(program.Strings, reader.string())
+	}
+	functions := reader.count()
+	for i := 0; i < functions && reader.err == nil; i++ {
+		function := &Function{Name: reader.string()}
+		function.Arguments = reader.count()
+		function.Locals = reader.count()
+		function.Extern = reader.number() != 0
+		instructions := reader.count()
+		for j := 0; j < instructions && reader.err == nil; j++ {
+			op := Opcode(reader.byte())
+			function.Code = append(function.Code, Instruction{Op: op, A: reader.number(), B: reader.number()})
+		}
+		program.Functions = append(program.Functions, function)
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if len(reader.data) > 0 {
+		return nil, fmt.Errorf("unexpected data after the last function")
+	}
+	if len(program.Functions) == 0 {
+		return nil, fmt.Errorf("the program has no functions")
+	}
+	for _, function := range program.Functions {
+		err := program.validate(function)
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", function.Name, err)
+		}
+	}
+	return program, nil
+}
+
+// validate checks the operands of the instructions of the function
+func (program *Program) validate(function *Function) error {
+	if function.Arguments > function.Locals {
+		return fmt.Errorf("more arguments than locals")
+	}
+	inRange := func(operand int64, limit int) bool {
+		return operand >= 0 && operand < int64(limit)
+	}
+	for i, instruction := range function.Code {
+		valid := true
+		switch instruction.Op {
+		case OpString:
+			valid = inRange(instruction.A, len(program.Strings))
+		case OpFunction:
+			valid = inRange(instruction.A, len(program.Functions))
+		case OpLoad, OpStore:
+			valid = inRange(instruction.A, function.Locals)
+		case OpLoadGlobal, OpStoreGlobal:
+			valid = inRange(instruction.A, program.Globals)
+		case OpJump, OpJumpIfFalse, OpJumpIfNone:
+			valid = inRange(instruction.A, len(function.Code)+1)
+		case OpList:
+			valid = instruction.A >= 0 && inRange(instruction.B, int(instruction.A)+1)
+		case OpTuple, OpStruct, OpField, OpSetField, OpElement, OpCall, OpTailCall:
+			valid = instruction.A >= 0
+		default:
+			valid = instruction.Op < opcodeCount
+		}
+		if !valid {
+			return fmt.Errorf("invalid instruction %d: %s", i, instruction)
+		}
+	}
+	return nil
+}
+
+func writeNumber(buffer *bytes.Buffer, value int64) {
+	var encoded [binary.MaxVarintLen64]byte
+	buffer.Write(encoded[:binary.PutVarint(encoded[:], value)])
+}
+
+func writeString(buffer *bytes.Buffer, value string) {
+	writeNumber(buffer, int64(len(value)))
+	buffer.WriteString(value)
+}
+
+// reader reads the parts of a serialised program. The first error is kept,
+// and everything read after it is zero.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) number() int64 {
+	if r.err != nil {
+		return 0
+	}
+	value, size := binary.Varint(r.data)
+	if size <= 0 {
+		r.err = fmt.Errorf("invalid number in bytecode")
+		return 0
+	}
+	r.data = r.data[size:]
+	return value
+}
+
+// count reads a number that can not be negative or larger than the rest of the file
+func (r *reader) count() int {
+	value := r.number()
+	if value < 0 || value > int64(len(r.data))*8+1<<20 {
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid count %d in bytecode", value)
+		}
+		return 0
+	}
+	return int(value)
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) == 0 {
+		r.err = fmt.Errorf("unexpected end of bytecode")
+		return 0
+	}
+	value := r.data[0]
+	r.data = r.data[1:]
+	return value
+}
+
+func (r *reader) string() string {
+	length := r.count()
+	if r.err != nil {
+		return ""
+	}
+	if length > len(r.data) {
+		r.err = fmt.Errorf("unexpected end of bytecode")
+		return ""
+	}
+	value := string(r.data[:length])
+	r.data = r.data[length:]
+	return value
+}
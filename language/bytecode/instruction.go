@@ -0,0 +1,188 @@
+package bytecode
+
+import "fmt"
+
+// Opcode is an instruction of the stack machine. Instructions pop their
+// operands from the stack and push their result. A and B are the operands
+// that are stored in the instruction itself.
+type Opcode byte
+
+const (
+	// OpPush pushes A, which is an int, a char or a bool
+	OpPush Opcode = iota
+	// OpString pushes a new list with the chars of string A
+	OpString
+	// OpFunction pushes function A
+	OpFunction
+	OpNone
+	// OpSome wraps the value on top of the stack in an option
+	OpSome
+	// OpUnwrap replaces an option with the value it holds
+	OpUnwrap
+	// OpLoad pushes local A, and OpStore pops the value of local A
+	OpLoad
+	OpStore
+	// OpLoadGlobal pushes global A, and OpStoreGlobal pops the value of global A
+	OpLoadGlobal
+	OpStoreGlobal
+	OpPop
+	OpDup
+	// OpList pops B elements and pushes a list of length A that starts with them
+	OpList
+	// OpTuple pops A elements and pushes a tuple of them
+	OpTuple
+	// OpStruct pops A members and pushes a struct of them
+	OpStruct
+	// OpIndex pops a list and an index below it, and pushes the element
+	OpIndex
+	// OpSetIndex pops an index, a value and a list, and stores the value
+	OpSetIndex
+	// OpLength replaces a list with its length
+	OpLength
+	// OpField replaces a struct with member A
+	OpField
+	// OpSetField pops a value and a struct, and stores the value in member A
+	OpSetField
+	// OpElement replaces a tuple with element A
+	OpElement
+	OpAdd
+	OpSub
+	OpMul
+	// OpDiv and OpMod divide unsigned numbers
+	OpDiv
+	OpMod
+	OpNeg
+	OpLess
+	OpGreater
+	OpEqual
+	OpNotEqual
+	// OpJump continues at instruction A
+	OpJump
+	// OpJumpIfFalse pops a bool, and continues at instruction A if it is false
+	OpJumpIfFalse
+	// OpJumpIfNone pops an option, and continues at instruction A if it is none
+	OpJumpIfNone
+	// OpCall pops A arguments and the function below them, and pushes
+	// the value that the function returns
+	OpCall
+	// OpTailCall is like OpCall, but the called function replaces the
+	// current one, so the frame stack does not grow
+	OpTailCall
+	// OpReturn pops the value that the current function returns
+	OpReturn
+	// OpPrintInt prints the low 32 bits of an int or a bool, like %d in printf
+	OpPrintInt
+	OpPrintChar
+	OpPrintString
+	opcodeCount
+)
+
+var mnemonics = map[Opcode]string{
+	OpPush:        "push",
+	OpString:      "string",
+	OpFunction:    "function",
+	OpNone:        "none",
+	OpSome:        "some",
+	OpUnwrap:      "unwrap",
+	OpLoad:        "load",
+	OpStore:       "store",
+	OpLoadGlobal:  "loadglobal",
+	OpStoreGlobal: "storeglobal",
+	OpPop:         "pop",
+	OpDup:         "dup",
+	OpList:        "list",
+	OpTuple:       "tuple",
+	OpStruct:      "struct",
+	OpIndex:       "index",
+	OpSetIndex:    "setindex",
+	OpLength:      "length",
+	OpField:       "field",
+	OpSetField:    "setfield",
+	OpElement:     "element",
+	OpAdd:         "add",
+	OpSub:         "sub",
+	OpMul:         "mul",
+	OpDiv:         "div",
+	OpMod:         "mod",
+	OpNeg:         "neg",
+	OpLess:        "less",
+	OpGreater:     "greater",
+	OpEqual:       "equal",
+	OpNotEqual:    "notequal",
+	OpJump:        "jump",
+	OpJumpIfFalse: "jumpiffalse",
+	OpJumpIfNone:  "jumpifnone",
+	OpCall:        "call",
+	OpTailCall:    "tailcall",
+	OpReturn:      "return",
+	OpPrintInt:    "printint",
+	OpPrintChar:   "printchar",
+	OpPrintString: "printstring",
+}
+
+func (op Opcode) String() string {
+	return mnemonics[op]
+}
+
+type Instruction struct {
+	Op Opcode
+	A  int64
+	B  int64
+}
+
+func (instruction Instruction) String() string {
+	return fmt.Sprintf("%s %d %d", instruction.Op, instruction.A, instruction.B)
+}
+
+// Function is the code of a function. Its arguments are the first of its
+// locals. Externs are C functions, which have no code and can not be
+// called by the virtual machine.
+type Function struct {
+	Name      string
+	Arguments int
+	Locals    int
+	Extern    bool
+	Code      []Instruction
+}
+
+// Program is a compiled program, which starts by running the first function
+type Program struct {
+	Functions []*Function
+	Strings   []string
+	Globals   int
+}
+
+// AddFunction adds a function without code, and returns its index
+func (program *Program) AddFunction(name string) int {
+	program.Functions = append(program.Functions, &Function{Name: name})
+	return len(program.Functions) - 1
+}
+
+// AddString adds a string constant, and returns its index
+func (program *Program) AddString(value string) int {
+	for i, existing := range program.Strings {
+		if existing == value {
+			return i
+		}
+	}
+	program.Strings = append(program.Strings, value)
+	return len(program.Strings) - 1
+}
+
+// Emit adds an instruction to the function, and returns its index
+func (function *Function) Emit(op Opcode, operands ...int64) int {
+	instruction := Instruction{Op: op}
+	if len(operands) > 0 {
+		instruction.A = operands[0]
+	}
+	if len(operands) > 1 {
+		instruction.B = operands[1]
+	}
+	function.Code = append(function.Code, instruction)
+	return len(function.Code) - 1
+}
+
+// Patch sets the target of the jump at the given index to the next instruction
+func (function *Function) Patch(jump int) {
+	function.Code[jump].A = int64(len(function.Code))
+}
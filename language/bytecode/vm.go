@@ -0,0 +1,335 @@
+package bytecode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Value is a value on the stack of the virtual machine. Ints, chars and
+// bools are all int64, like in the registers of the x86 backend. Lists and
+// structs are pointers, so they are shared. None is nil.
+type Value interface{}
+
+type List struct {
+	Elements []Value
+}
+
+type Struct struct {
+	Members []Value
+}
+
+type Tuple []Value
+
+type Some struct {
+	Value Value
+}
+
+// FunctionValue is the index of a function in the program
+type FunctionValue int
+
+// frame is a call of a function. Its locals are on the stack from base,
+// right above the function that was called.
+type frame struct {
+	function *Function
+	pc       int
+	base     int
+}
+
+type machine struct {
+	program *Program
+	out     *bufio.Writer
+	stack   []Value
+	globals []Value
+	frames  []frame
+}
+
+// Run runs the program, and writes what it prints to out. Operations that
+// would crash the compiled program, like dividing by zero, return an error.
+func Run(program *Program, out io.Writer) error {
+	m := &machine{
+		program: program,
+		out:     bufio.NewWriter(out),
+		globals: make([]Value, program.Globals),
+	}
+	m.stack = append(m.stack, FunctionValue(0))
+	err := m.enter(program.Functions[0], 0)
+	if err == nil {
+		err = m.run()
+	}
+	flushErr := m.out.Flush()
+	if err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// enter starts a call of the function, whose arguments are on top of the stack
+func (m *machine) enter(function *Function, arguments int) error {
+	if function.Extern {
+		return fmt.Errorf("the virtual machine can not call the C function %s", function.Name)
+	}
+	if arguments != function.Arguments {
+		return fmt.Errorf("%s takes %d arguments, but got %d", function.Name, function.Arguments, arguments)
+	}
+	base := len(m.stack) - arguments
+	for i := arguments; i < function.Locals; i++ {
+		m.stack = append(m.stack, nil)
+	}
+	m.frames = append(m.frames, frame{function: function, base: base})
+	return nil
+}
+
+func (m *machine) push(value Value) {
+	m.stack = append(m.stack, value)
+}
+
+func (m *machine) pop() Value {
+	value := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return value
+}
+
+// popInt pops an int, a char or a bool. Elements of lists that were never
+// set are nil, and are read as 0.
+func (m *machine) popInt() int64 {
+	value, _ := m.pop().(int64)
+	return value
+}
+
+// run runs instructions until the first function returns. Decode checks
+// the operands of every instruction, but not how they use the stack, so
+// bytecode that pops values that are not there is reported as invalid.
+func (m *machine) run() (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("invalid bytecode: %v", recovered)
+		}
+	}()
+	for {
+		current := &m.frames[len(m.frames)-1]
+		if current.pc >= len(current.function.Code) {
+			return fmt.Errorf("%s ended without returning", current.function.Name)
+		}
+		instruction := current.function.Code[current.pc]
+		current.pc++
+		switch instruction.Op {
+		case OpPush:
+			m.push(instruction.A)
+		case OpString:
+			value := m.program.Strings[instruction.A]
+			list := &List{Elements: make([]Value, len(value))}
+			for i := range list.Elements {
+				list.Elements[i] = int64(value[i])
+			}
+			m.push(list)
+		case OpFunction:
+			m.push(FunctionValue(instruction.A))
+		case OpNone:
+			m.push(nil)
+		case OpSome:
+			m.push(Some{Value: m.pop()})
+		case OpUnwrap:
+			option, ok := m.pop().(Some)
+			if !ok {
+				return fmt.Errorf("can not unwrap none")
+			}
+			m.push(option.Value)
+		case OpLoad:
+			m.push(m.stack[current.base+int(instruction.A)])
+		case OpStore:
+			m.stack[current.base+int(instruction.A)] = m.pop()
+		case OpLoadGlobal:
+			m.push(m.globals[instruction.A])
+		case OpStoreGlobal:
+			m.globals[instruction.A] = m.pop()
+		case OpPop:
+			m.pop()
+		case OpDup:
+			m.push(m.stack[len(m.stack)-1])
+		case OpList:
+			list := &List{Elements: make([]Value, instruction.A)}
+			count := int(instruction.B)
+			copy(list.Elements, m.stack[len(m.stack)-count:])
+			m.stack = m.stack[:len(m.stack)-count]
+			m.push(list)
+		case OpTuple:
+			m.push(Tuple(m.popValues(int(instruction.A))))
+		case OpStruct:
+			m.push(&Struct{Members: m.popValues(int(instruction.A))})
+		case OpIndex:
+			list, err := listOf(m.pop())
+			if err != nil {
+				return err
+			}
+			index := m.popInt()
+			if index < 0 || index >= int64(len(list.Elements)) {
+				return fmt.Errorf("index %d is out of range for a list of length %d", index, len(list.Elements))
+			}
+			m.push(list.Elements[index])
+		case OpSetIndex:
+			index := m.popInt()
+			value := m.pop()
+			list, err := listOf(m.pop())
+			if err != nil {
+				return err
+			}
+			if index < 0 || index >= int64(len(list.Elements)) {
+				return fmt.Errorf("index %d is out of range for a list of length %d", index, len(list.Elements))
+			}
+			list.Elements[index] = value
+		case OpLength:
+			list, err := listOf(m.pop())
+			if err != nil {
+				return err
+			}
+			m.push(int64(len(list.Elements)))
+		case OpField:
+			structure, err := structOf(m.pop(), instruction.A)
+			if err != nil {
+				return err
+			}
+			m.push(structure.Members[instruction.A])
+		case OpSetField:
+			value := m.pop()
+			structure, err := structOf(m.pop(), instruction.A)
+			if err != nil {
+				return err
+			}
+			structure.Members[instruction.A] = value
+		case OpElement:
+			tuple, ok := m.pop().(Tuple)
+			if !ok || instruction.A >= int64(len(tuple)) {
+				return fmt.Errorf("tuple has no element %d", instruction.A)
+			}
+			m.push(tuple[instruction.A])
+		case OpAdd:
+			right := m.popInt()
+			m.push(m.popInt() + right)
+		case OpSub:
+			right := m.popInt()
+			m.push(m.popInt() - right)
+		case OpMul:
+			right := m.popInt()
+			m.push(m.popInt() * right)
+		case OpDiv, OpMod:
+			right := uint64(m.popInt())
+			left := uint64(m.popInt())
+			if right == 0 {
+				return fmt.Errorf("division by zero")
+			}
+			if instruction.Op == OpDiv {
+				m.push(int64(left / right))
+			} else {
+				m.push(int64(left % right))
+			}
+		case OpNeg:
+			m.push(-m.popInt())
+		case OpLess:
+			right := m.popInt()
+			m.push(boolean(m.popInt() < right))
+		case OpGreater:
+			right := m.popInt()
+			m.push(boolean(m.popInt() > right))
+		case OpEqual:
+			right := m.popInt()
+			m.push(boolean(m.popInt() == right))
+		case OpNotEqual:
+			right := m.popInt()
+			m.push(boolean(m.popInt() != right))
+		case OpJump:
+			current.pc = int(instruction.A)
+		case OpJumpIfFalse:
+			if m.popInt() == 0 {
+				current.pc = int(instruction.A)
+			}
+		case OpJumpIfNone:
+			if m.pop() == nil {
+				current.pc = int(instruction.A)
+			}
+		case OpCall, OpTailCall:
+			arguments := int(instruction.A)
+			function, err := m.function(len(m.stack) - arguments - 1)
+			if err != nil {
+				return err
+			}
+			if instruction.Op == OpTailCall {
+				// The function and the arguments replace the current frame
+				start := current.base - 1
+				copy(m.stack[start:], m.stack[len(m.stack)-arguments-1:])
+				m.stack = m.stack[:start+arguments+1]
+				m.frames = m.frames[:len(m.frames)-1]
+			}
+			err = m.enter(function, arguments)
+			if err != nil {
+				return err
+			}
+		case OpReturn:
+			result := m.pop()
+			m.stack = m.stack[:current.base-1]
+			m.frames = m.frames[:len(m.frames)-1]
+			if len(m.frames) == 0 {
+				return nil
+			}
+			m.push(result)
+		case OpPrintInt:
+			fmt.Fprintf(m.out, "%d\n", int32(m.popInt()))
+		case OpPrintChar:
+			m.out.WriteByte(byte(m.popInt()))
+			m.out.WriteByte('\n')
+		case OpPrintString:
+			list, err := listOf(m.pop())
+			if err != nil {
+				return err
+			}
+			for _, element := range list.Elements {
+				char, _ := element.(int64)
+				m.out.WriteByte(byte(char))
+			}
+			m.out.WriteByte('\n')
+		default:
+			return fmt.Errorf("invalid opcode %d", instruction.Op)
+		}
+	}
+}
+
+// popValues pops the given number of values, and returns them in the order
+// they were pushed
+func (m *machine) popValues(count int) []Value {
+	values := make([]Value, count)
+	copy(values, m.stack[len(m.stack)-count:])
+	m.stack = m.stack[:len(m.stack)-count]
+	return values
+}
+
+// function is the function at the given position on the stack
+func (m *machine) function(position int) (*Function, error) {
+	index, ok := m.stack[position].(FunctionValue)
+	if !ok || int(index) >= len(m.program.Functions) {
+		return nil, fmt.Errorf("can not call a function that does not exist")
+	}
+	return m.program.Functions[index], nil
+}
+
+func listOf(value Value) (*List, error) {
+	list, ok := value.(*List)
+	if !ok {
+		return nil, fmt.Errorf("list does not exist")
+	}
+	return list, nil
+}
+
+func structOf(value Value, member int64) (*Struct, error) {
+	structure, ok := value.(*Struct)
+	if !ok || member >= int64(len(structure.Members)) {
+		return nil, fmt.Errorf("struct does not have member %d", member)
+	}
+	return structure, nil
+}
+
+func boolean(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
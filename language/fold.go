@@ -0,0 +1,467 @@
+package language
+
+import (
+	"callmemaybe/language/typesystem"
+	"fmt"
+)
+
+// Fold evaluates the parts of the program that only depend on literals at
+// compile time. Arithmetic, comparisons and lengths of literal lists are
+// replaced by their values, conditions that are always true or false are
+// removed, and division by zero or indexing outside of a literal list are
+// reported as errors, unless they are in a body that never runs.
+func Fold(stmt Stmt) (Stmt, error) {
+	return foldStmt(stmt)
+}
+
+func foldStmt(stmt Stmt) (Stmt, error) {
+	switch stmt := stmt.(type) {
+	case StmtSeq:
+		var statements []Stmt
+		for i, statement := range stmt.Statements {
+			folded, err := foldStmt(statement)
+			if err != nil {
+				return nil, stmt.errorAt(i, err)
+			}
+			statements = append(statements, folded)
+		}
+		stmt.Statements = statements
+		return stmt, nil
+	case StmtAssign:
+		expression, err := foldExp(stmt.Expression)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Expression = expression
+		return stmt, nil
+	case StmtPrintln:
+		expression, err := foldExp(stmt.Expression)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Expression = expression
+		return stmt, nil
+	case StmtReturn:
+		expression, err := foldExp(stmt.Expression)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Expression = expression
+		return stmt, nil
+	case StmtIf:
+		expression, err := foldExp(stmt.Expression)
+		if err != nil {
+			return nil, err
+		}
+		if condition, ok := expression.(ExpBool); ok && !condition.Value {
+			return foldUnreachable(stmt.Body), nil
+		}
+		body, err := foldStmt(stmt.Body)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := expression.(ExpBool); ok {
+			return StmtBlock{Body: body}, nil
+		}
+		stmt.Expression = expression
+		stmt.Body = body
+		return stmt, nil
+	case StmtLoop:
+		condition, err := foldExp(stmt.Condition)
+		if err != nil {
+			return nil, err
+		}
+		if condition, ok := condition.(ExpBool); ok && !condition.Value {
+			return foldUnreachable(stmt.Body), nil
+		}
+		body, err := foldStmt(stmt.Body)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Condition = condition
+		stmt.Body = body
+		return stmt, nil
+	case StmtIfSome:
+		expression, err := foldExp(stmt.Expression)
+		if err != nil {
+			return nil, err
+		}
+		body, err := foldStmt(stmt.Body)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Expression = expression
+		stmt.Body = body
+		return stmt, nil
+	case StmtLoopSome:
+		expression, err := foldExp(stmt.Expression)
+		if err != nil {
+			return nil, err
+		}
+		body, err := foldStmt(stmt.Body)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Expression = expression
+		stmt.Body = body
+		return stmt, nil
+	case StmtFunctionDeclaration:
+		function, err := foldExp(stmt.Function)
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", stmt.Name, err)
+		}
+		stmt.Function = function.(ExpFunction)
+		return stmt, nil
+	case StmtUpdateList:
+		list, err := foldExp(stmt.List)
+		if err != nil {
+			return nil, err
+		}
+		index, err := foldExp(stmt.Index)
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := foldExp(stmt.NewValue)
+		if err != nil {
+			return nil, err
+		}
+		err = checkConstantIndex(list, index)
+		if err != nil {
+			return nil, err
+		}
+		stmt.List = list
+		stmt.Index = index
+		stmt.NewValue = newValue
+		return stmt, nil
+	case StmtUpdateStruct:
+		structure, err := foldExp(stmt.Struct)
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := foldExp(stmt.NewValue)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Struct = structure
+		stmt.NewValue = newValue
+		return stmt, nil
+	}
+	return stmt, nil
+}
+
+// foldUnreachable folds a body that never runs. It is only type checked, so
+// the errors of values it would compute are not reported, and it is kept as
+// it is if folding it fails.
+func foldUnreachable(body Stmt) Stmt {
+	folded, err := foldStmt(body)
+	if err != nil {
+		return StmtUnreachable{Body: body}
+	}
+	return StmtUnreachable{Body: folded}
+}
+
+func foldExp(exp Exp) (Exp, error) {
+	switch exp := exp.(type) {
+	case ExpParentheses:
+		inside, err := foldExp(exp.Inside)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, ok := constant(inside); ok {
+			return inside, nil
+		}
+		exp.Inside = inside
+		return exp, nil
+	case ExpNegative:
+		inside, err := foldExp(exp.Inside)
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := inside.(ExpNum); ok {
+			return ExpNum{Value: -num.Value}, nil
+		}
+		exp.Inside = inside
+		return exp, nil
+	case ExpPlus:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantInts(left, right); ok {
+			return ExpNum{Value: l + r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpMinus:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantInts(left, right); ok {
+			return ExpNum{Value: l - r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpMultiply:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantInts(left, right); ok {
+			return ExpNum{Value: l * r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpDivide:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := right.(ExpNum); ok && num.Value == 0 {
+			return nil, errorAt(exp.Position, fmt.Errorf("division by zero"))
+		}
+		// Division is unsigned, like the div instruction
+		if l, r, ok := constantInts(left, right); ok {
+			return ExpNum{Value: int(uint64(l) / uint64(r))}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpModulo:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := right.(ExpNum); ok && num.Value == 0 {
+			return nil, errorAt(exp.Position, fmt.Errorf("modulo by zero"))
+		}
+		if l, r, ok := constantInts(left, right); ok {
+			return ExpNum{Value: int(uint64(l) % uint64(r))}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpLess:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantComparables(left, right); ok {
+			return ExpBool{Value: l < r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpGreater:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantComparables(left, right); ok {
+			return ExpBool{Value: l > r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpEquals:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantComparables(left, right); ok {
+			return ExpBool{Value: l == r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpNotEquals:
+		left, right, err := foldBop(exp)
+		if err != nil {
+			return nil, err
+		}
+		if l, r, ok := constantComparables(left, right); ok {
+			return ExpBool{Value: l != r}, nil
+		}
+		exp.Left = left
+		exp.Right = right
+		return exp, nil
+	case ExpSome:
+		inside, err := foldExp(exp.Inside)
+		if err != nil {
+			return nil, err
+		}
+		exp.Inside = inside
+		return exp, nil
+	case ExpList:
+		elements, err := foldExps(exp.Elements)
+		if err != nil {
+			return nil, err
+		}
+		exp.Elements = elements
+		return exp, nil
+	case ExpTuple:
+		elements, err := foldExps(exp.Elements)
+		if err != nil {
+			return nil, err
+		}
+		exp.Elements = elements
+		return exp, nil
+	case ExpGetFromList:
+		list, err := foldExp(exp.List)
+		if err != nil {
+			return nil, err
+		}
+		index, err := foldExp(exp.Index)
+		if err != nil {
+			return nil, err
+		}
+		err = checkConstantIndex(list, index)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := list.(ExpList); ok && isConstantList(list) {
+			if index, ok := index.(ExpNum); ok && index.Value < len(list.Elements) {
+				return list.Elements[index.Value], nil
+			}
+		}
+		exp.List = list
+		exp.Index = index
+		return exp, nil
+	case ExpReadFromStruct:
+		structure, err := foldExp(exp.Struct)
+		if err != nil {
+			return nil, err
+		}
+		exp.Struct = structure
+		return exp, nil
+	case ExpLength:
+		list, err := foldExp(exp.List)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := list.(ExpList); ok && isConstantList(list) {
+			return ExpNum{Value: list.Size}, nil
+		}
+		exp.List = list
+		return exp, nil
+	case ExpFunction:
+		body, err := foldStmt(exp.Body)
+		if err != nil {
+			return nil, err
+		}
+		exp.Body = body
+		return exp, nil
+	case FunctionCall:
+		function, err := foldExp(exp.Exp)
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := foldExps(exp.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		exp.Exp = function
+		exp.Arguments = arguments
+		return exp, nil
+	case StructExp:
+		var members []StructMember
+		for _, member := range exp.Members {
+			folded, err := foldExp(member.Exp)
+			if err != nil {
+				return nil, err
+			}
+			member.Exp = folded
+			members = append(members, member)
+		}
+		exp.Members = members
+		return exp, nil
+	}
+	return exp, nil
+}
+
+func foldExps(exps []Exp) ([]Exp, error) {
+	var folded []Exp
+	for _, exp := range exps {
+		result, err := foldExp(exp)
+		if err != nil {
+			return nil, err
+		}
+		folded = append(folded, result)
+	}
+	return folded, nil
+}
+
+func foldBop(exp ExpBop) (Exp, Exp, error) {
+	left, err := foldExp(exp.LeftExp())
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := foldExp(exp.RightExp())
+	if err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+// constant returns the value of a literal as it is represented at runtime
+func constant(exp Exp) (int, typesystem.RawType, bool) {
+	switch exp := exp.(type) {
+	case ExpNum:
+		return exp.Value, typesystem.Int, true
+	case ExpChar:
+		return int(exp.Value[0]), typesystem.Char, true
+	case ExpBool:
+		if exp.Value {
+			return 1, typesystem.Bool, true
+		}
+		return 0, typesystem.Bool, true
+	}
+	return 0, typesystem.Invalid, false
+}
+
+func constantInts(left Exp, right Exp) (int, int, bool) {
+	l, leftOk := left.(ExpNum)
+	r, rightOk := right.(ExpNum)
+	return l.Value, r.Value, leftOk && rightOk
+}
+
+// constantComparables only folds literals of the same type, so that mismatching
+// types are still reported when the expression is generated
+func constantComparables(left Exp, right Exp) (int, int, bool) {
+	l, leftType, leftOk := constant(left)
+	r, rightType, rightOk := constant(right)
+	return l, r, leftOk && rightOk && leftType == rightType
+}
+
+// isConstantList is true for list literals of only literals, that would also
+// pass the type checks done when the list is generated
+func isConstantList(list ExpList) bool {
+	if list.Size < len(list.Elements) || list.Type.ListElementType == nil {
+		return false
+	}
+	for _, element := range list.Elements {
+		_, kind, ok := constant(element)
+		if !ok || kind != list.Type.ListElementType.RawType {
+			return false
+		}
+	}
+	return true
+}
+
+func checkConstantIndex(list Exp, index Exp) error {
+	literal, ok := list.(ExpList)
+	if !ok {
+		return nil
+	}
+	num, ok := index.(ExpNum)
+	if !ok {
+		return nil
+	}
+	if num.Value < 0 || num.Value >= literal.Size {
+		return fmt.Errorf("index %d is out of range for a list of size %d", num.Value, literal.Size)
+	}
+	return nil
+}
@@ -0,0 +1,542 @@
+package language
+
+import (
+	"callmemaybe/language/typesystem"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const indentation = "    "
+
+// formatter prints a syntax tree as source code. The lines of the source
+// tell it where the blank lines between statements are, and which lists
+// were written as strings. comments are the comments that have not been
+// written yet, and lastLine is the line in the source of what was written
+// last.
+type formatter struct {
+	out      strings.Builder
+	indent   int
+	lines    [][]rune
+	comments []Comment
+	lastLine int
+}
+
+// Format prints the program in the canonical layout: one statement per line,
+// bodies indented by four spaces, and single spaces around operators and
+// after commas. Blank lines between statements are kept, but never more than
+// one in a row. Comments on their own lines stay before the statement that
+// follows them, comments after code stay at the end of the line of the
+// statement, and comments inside a statement stay before the expression,
+// operator or body that follows them. The formatted program parses to the
+// same syntax tree.
+func Format(source string) (string, error) {
+	parser := NewParser(strings.NewReader(source))
+	parser.Positions = true
+	ast, err := parser.Parse()
+	if err != nil {
+		return "", err
+	}
+	seq, ok := ast.(StmtSeq)
+	if !ok {
+		return "", fmt.Errorf("expected a sequence of statements")
+	}
+	formatter := &formatter{comments: parser.Comments()}
+	for _, line := range strings.Split(source, "\n") {
+		formatter.lines = append(formatter.lines, []rune(line))
+	}
+	formatter.seq(seq)
+	return formatter.out.String(), nil
+}
+
+func (f *formatter) write(text string) {
+	f.out.WriteString(text)
+}
+
+func (f *formatter) startLine(line int, first bool) {
+	if !first && f.blankBefore(line) {
+		f.write("\n")
+	}
+	f.write(strings.Repeat(indentation, f.indent))
+	f.lastLine = line
+}
+
+// seq writes the statements, each on its own line at the current indentation
+func (f *formatter) seq(seq StmtSeq) {
+	first := true
+	for i, stmt := range seq.Statements {
+		start := positionAt(seq.Positions, i)
+		first = f.leading(start, first)
+		f.startLine(start.Line, first)
+		f.stmt(stmt)
+		next := seq.End
+		if i+1 < len(seq.Statements) {
+			next = positionAt(seq.Positions, i+1)
+		}
+		f.trailing(next)
+		f.write("\n")
+		first = false
+	}
+	f.leading(seq.End, first)
+}
+
+// eachLine writes things that each start on their own line, like the members
+// of structs, with the comments that come before and after them
+func (f *formatter) eachLine(positions []Position, count int, write func(i int)) {
+	first := true
+	for i := 0; i < count; i++ {
+		start := positionAt(positions, i)
+		first = f.leading(start, first)
+		f.startLine(start.Line, first)
+		write(i)
+		if start.IsValid() {
+			f.trailing(Position{Line: start.Line + 1, Column: 1})
+		}
+		f.write("\n")
+		first = false
+	}
+}
+
+// leading writes the comments before the position on their own lines, and
+// returns whether nothing has been written in the block yet
+func (f *formatter) leading(position Position, first bool) bool {
+	for len(f.comments) > 0 && before(f.comments[0].Position, position) {
+		comment := f.comments[0]
+		f.comments = f.comments[1:]
+		f.startLine(comment.Position.Line, first)
+		f.write(comment.Text + "\n")
+		f.lastLine = comment.Position.Line + strings.Count(comment.Text, "\n")
+		first = false
+	}
+	return first
+}
+
+// trailing writes the next comments at the end of the line if they come
+// after code in the source and before the position
+func (f *formatter) trailing(position Position) {
+	for len(f.comments) > 0 && before(f.comments[0].Position, position) && f.afterCode(f.comments[0].Position) {
+		f.write(" " + f.comments[0].Text)
+		f.comments = f.comments[1:]
+	}
+}
+
+// inline writes the comments before the position in the middle of a
+// statement, so that they stay before the same part of it. Line comments end
+// the line, and the statement continues on the next line indented one level
+// more, which is also where comments on their own lines are written.
+func (f *formatter) inline(position Position) {
+	if !position.IsValid() {
+		return
+	}
+	for len(f.comments) > 0 && before(f.comments[0].Position, position) {
+		comment := f.comments[0]
+		f.comments = f.comments[1:]
+		if !f.afterCode(comment.Position) {
+			f.continueLine()
+		}
+		f.write(comment.Text)
+		if strings.HasPrefix(comment.Text, "//") {
+			f.continueLine()
+		} else {
+			f.write(" ")
+		}
+	}
+}
+
+// continueLine breaks a statement over two lines, without trailing spaces
+func (f *formatter) continueLine() {
+	written := strings.TrimRight(f.out.String(), " ")
+	if !strings.HasSuffix(written, "\n") {
+		written += "\n"
+	}
+	f.out.Reset()
+	f.write(written + strings.Repeat(indentation, f.indent+1))
+}
+
+// afterCode is true if there is more than whitespace before the position
+// on its line
+func (f *formatter) afterCode(position Position) bool {
+	if position.Line < 1 || position.Line > len(f.lines) {
+		return false
+	}
+	line := f.lines[position.Line-1]
+	return position.Column-1 <= len(line) && strings.TrimSpace(string(line[:position.Column-1])) != ""
+}
+
+// blankBefore is true if the line before this one is blank, and comes
+// after what was written last
+func (f *formatter) blankBefore(line int) bool {
+	return line-1 > f.lastLine && f.blank(line-1)
+}
+
+func (f *formatter) blank(line int) bool {
+	return line >= 1 && line <= len(f.lines) && strings.TrimSpace(string(f.lines[line-1])) == ""
+}
+
+// block writes a body in curly brackets, with its statements indented
+func (f *formatter) block(body Stmt) {
+	seq := body.(StmtSeq)
+	f.inline(seq.Start)
+	f.write("{")
+	if len(seq.Statements) > 0 {
+		f.trailing(positionAt(seq.Positions, 0))
+	} else {
+		f.trailing(seq.End)
+	}
+	f.write("\n")
+	f.indent++
+	f.seq(seq)
+	f.indent--
+	f.write(strings.Repeat(indentation, f.indent) + "}")
+}
+
+// members writes the members of a struct in curly brackets, one on each line
+func (f *formatter) members(positions []Position, count int, write func(i int)) {
+	f.write("{")
+	if count > 0 {
+		f.trailing(positionAt(positions, 0))
+	}
+	f.write("\n")
+	f.indent++
+	f.eachLine(positions, count, write)
+	f.indent--
+	f.write(strings.Repeat(indentation, f.indent) + "}")
+}
+
+func (f *formatter) stmt(stmt Stmt) {
+	switch stmt := stmt.(type) {
+	case StmtAssign:
+		if stmt.Constant {
+			f.write("const ")
+		}
+		if len(stmt.Identifiers) > 0 {
+			f.write(strings.Join(stmt.Identifiers, ", "))
+		} else {
+			f.write(stmt.Identifier)
+		}
+		if stmt.Type != nil {
+			f.write(": " + formatType(*stmt.Type))
+		}
+		f.write(" = ")
+		f.exp(stmt.Expression)
+	case StmtPrintln:
+		f.write("println ")
+		f.exp(stmt.Expression)
+	case StmtReturn:
+		f.write("return ")
+		// A returned tuple is written without its brackets
+		if tuple, ok := stmt.Expression.(ExpTuple); ok {
+			f.exps(tuple.Elements)
+		} else {
+			f.exp(stmt.Expression)
+		}
+	case StmtIf:
+		f.write("if ")
+		f.exp(stmt.Expression)
+		f.write(" ")
+		f.block(stmt.Body)
+	case StmtIfSome:
+		f.write("if some " + stmt.Identifier + " = ")
+		f.exp(stmt.Expression)
+		f.write(" ")
+		f.block(stmt.Body)
+	case StmtLoop:
+		f.write("loop ")
+		f.exp(stmt.Condition)
+		f.write(" ")
+		f.block(stmt.Body)
+	case StmtLoopSome:
+		f.write("loop some " + stmt.Identifier + " = ")
+		f.exp(stmt.Expression)
+		f.write(" ")
+		f.block(stmt.Body)
+	case StmtFunctionDeclaration:
+		if stmt.Exported {
+			f.write("export ")
+		}
+		f.write("fn " + stmt.Name + "(" + arguments(stmt.Function.Type) + ")")
+		f.returnType(stmt.Function.Type)
+		f.write(" ")
+		f.block(stmt.Function.Body)
+	case StmtExtern:
+		f.write("extern fn " + stmt.Name + "(" + arguments(stmt.Type) + ")")
+		f.returnType(stmt.Type)
+	case StmtStructDeclaration:
+		members := stmt.Type.StructMembers
+		f.write("struct " + stmt.Type.StructName + " ")
+		f.members(stmt.Positions, len(members), func(i int) {
+			f.write(members[i].Name + " " + formatType(members[i].Type))
+		})
+	case StmtUpdateList:
+		f.reference(ExpGetFromList{List: stmt.List, Index: stmt.Index})
+		f.write(" = ")
+		f.exp(stmt.NewValue)
+	case StmtUpdateStruct:
+		f.reference(ExpReadFromStruct{Struct: stmt.Struct, Field: stmt.Member})
+		f.write(" = ")
+		f.exp(stmt.NewValue)
+	default:
+		panic(fmt.Sprintf("can not format %T", stmt))
+	}
+}
+
+// returnType writes the return type of a function, which is left out if
+// it returns nothing
+func (f *formatter) returnType(kind typesystem.Type) {
+	if kind.FunctionReturnType != nil && kind.FunctionReturnType.RawType != typesystem.Void {
+		f.write(" " + formatType(*kind.FunctionReturnType))
+	}
+}
+
+func (f *formatter) exps(exps []Exp) {
+	for i, exp := range exps {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.exp(exp)
+	}
+}
+
+func (f *formatter) exp(exp Exp) {
+	f.inline(start(exp))
+	switch exp := exp.(type) {
+	case ExpPlus:
+		f.binary(exp, "+", exp.Position)
+	case ExpMinus:
+		f.binary(exp, "-", exp.Position)
+	case ExpMultiply:
+		f.binary(exp, "*", exp.Position)
+	case ExpDivide:
+		f.binary(exp, "/", exp.Position)
+	case ExpModulo:
+		f.binary(exp, "%", exp.Position)
+	case ExpLess:
+		f.binary(exp, "<", exp.Position)
+	case ExpGreater:
+		f.binary(exp, ">", exp.Position)
+	case ExpEquals:
+		f.binary(exp, "==", exp.Position)
+	case ExpNotEquals:
+		f.binary(exp, "!=", exp.Position)
+	case ExpNegative:
+		f.write("-")
+		f.exp(exp.Inside)
+	case ExpParentheses:
+		f.write("(")
+		f.exp(exp.Inside)
+		f.write(")")
+	case ExpNum:
+		f.write(strconv.Itoa(exp.Value))
+	case ExpChar:
+		f.write("'" + escape(exp.Value, '\'') + "'")
+	case ExpBool:
+		f.write(strconv.FormatBool(exp.Value))
+	case ExpNone:
+		f.write("none")
+	case ExpSome:
+		f.write("some(")
+		f.exp(exp.Inside)
+		f.write(")")
+	case ExpIdentifier:
+		f.write(exp.Name)
+	case ExpLength:
+		f.write("len(")
+		f.exp(exp.List)
+		f.write(")")
+	case ExpList:
+		f.list(exp)
+	case ExpTuple:
+		f.write("(")
+		f.exps(exp.Elements)
+		f.write(")")
+	case ExpGetFromList, ExpReadFromStruct:
+		f.reference(exp)
+	case FunctionCall:
+		f.write("#")
+		f.exp(exp.Exp)
+		if len(exp.Arguments) > 0 {
+			f.write("(")
+			f.exps(exp.Arguments)
+			f.write(")")
+		}
+	case ExpFunction:
+		f.write("|")
+		if exp.Recurse != "" {
+			f.write(exp.Recurse)
+			if len(exp.Type.FunctionArgumentTypes) > 0 {
+				f.write(", ")
+			}
+		}
+		f.write(arguments(exp.Type) + "|")
+		f.returnType(exp.Type)
+		f.write(" ")
+		f.block(exp.Body)
+	case StructExp:
+		f.write("@" + exp.Name)
+		if len(exp.Members) == 0 {
+			f.write("{}")
+			return
+		}
+		positions := make([]Position, len(exp.Members))
+		for i, member := range exp.Members {
+			positions[i] = member.Position
+		}
+		f.members(positions, len(exp.Members), func(i int) {
+			f.write(exp.Members[i].Name + ": ")
+			f.exp(exp.Members[i].Exp)
+		})
+	default:
+		panic(fmt.Sprintf("can not format %T", exp))
+	}
+}
+
+// binary writes an operator between its operands. The position is where
+// the operator is.
+func (f *formatter) binary(exp ExpBop, operator string, position Position) {
+	f.exp(exp.LeftExp())
+	f.write(" ")
+	f.inline(position)
+	f.write(operator + " ")
+	f.exp(exp.RightExp())
+}
+
+// start is where the expression starts in the source, or the zero Position
+// if it is not known
+func start(exp Exp) Position {
+	switch exp := exp.(type) {
+	case ExpBop:
+		return start(exp.LeftExp())
+	case ExpGetFromList:
+		return start(exp.List)
+	case ExpReadFromStruct:
+		return start(exp.Struct)
+	case ExpNegative:
+		return exp.Position
+	case ExpParentheses:
+		return exp.Position
+	case ExpNum:
+		return exp.Position
+	case ExpChar:
+		return exp.Position
+	case ExpBool:
+		return exp.Position
+	case ExpNone:
+		return exp.Position
+	case ExpSome:
+		return exp.Position
+	case ExpIdentifier:
+		return exp.Position
+	case ExpLength:
+		return exp.Position
+	case ExpList:
+		return exp.Position
+	case ExpTuple:
+		return exp.Position
+	case FunctionCall:
+		return exp.Position
+	case ExpFunction:
+		return exp.Position
+	case StructExp:
+		return exp.Position
+	}
+	return Position{}
+}
+
+// reference writes reads from lists and structs like ?a.b[0], where the ?
+// comes before the expression that the chain of reads starts with
+func (f *formatter) reference(exp Exp) {
+	var chain []Exp
+	for {
+		if get, ok := exp.(ExpGetFromList); ok {
+			chain = append(chain, get)
+			exp = get.List
+		} else if read, ok := exp.(ExpReadFromStruct); ok {
+			chain = append(chain, read)
+			exp = read.Struct
+		} else {
+			break
+		}
+	}
+	f.write("?")
+	f.exp(exp)
+	for i := len(chain) - 1; i >= 0; i-- {
+		switch read := chain[i].(type) {
+		case ExpGetFromList:
+			f.write("[")
+			f.exp(read.Index)
+			f.write("]")
+		case ExpReadFromStruct:
+			f.write("." + read.Field)
+		}
+	}
+}
+
+// list writes a list literal, or a string if it was written as one
+func (f *formatter) list(list ExpList) {
+	if f.isString(list) {
+		var value strings.Builder
+		for _, element := range list.Elements {
+			value.WriteString(element.(ExpChar).Value)
+		}
+		f.write("\"" + escape(value.String(), '"') + "\"")
+		return
+	}
+	f.write(fmt.Sprintf("<%s, %d>[", formatType(*list.Type.ListElementType), list.Size))
+	f.exps(list.Elements)
+	f.write("]")
+}
+
+func (f *formatter) isString(list ExpList) bool {
+	at := list.Position
+	if at.Line < 1 || at.Line > len(f.lines) || at.Column < 1 || at.Column > len(f.lines[at.Line-1]) {
+		return false
+	}
+	return f.lines[at.Line-1][at.Column-1] == '"'
+}
+
+// escape escapes the backslashes and the quotes that end the literal
+func escape(value string, quote rune) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	return strings.ReplaceAll(value, string(quote), "\\"+string(quote))
+}
+
+// arguments writes named arguments like "a int, b int"
+func arguments(kind typesystem.Type) string {
+	var written []string
+	for _, argument := range kind.FunctionArgumentTypes {
+		written = append(written, argument.Name+" "+formatType(argument.Type))
+	}
+	return strings.Join(written, ", ")
+}
+
+// formatType writes a type the way it is parsed. It is like Type.String,
+// except that a function without arguments that returns nothing is func.
+func formatType(kind typesystem.Type) string {
+	switch kind.RawType {
+	case typesystem.List:
+		if kind.ListElementType.RawType == typesystem.Char {
+			return "string"
+		}
+		return "list<" + formatType(*kind.ListElementType) + ">"
+	case typesystem.Option:
+		return "option<" + formatType(*kind.OptionElementType) + ">"
+	case typesystem.Tuple:
+		var elements []string
+		for _, element := range kind.TupleElementTypes {
+			elements = append(elements, formatType(element))
+		}
+		return "(" + strings.Join(elements, ", ") + ")"
+	case typesystem.Function:
+		returnsNothing := kind.FunctionReturnType == nil || kind.FunctionReturnType.RawType == typesystem.Void
+		if returnsNothing && len(kind.FunctionArgumentTypes) == 0 {
+			return "func"
+		}
+		var types []string
+		for _, argument := range kind.FunctionArgumentTypes {
+			types = append(types, formatType(argument.Type))
+		}
+		types = append(types, formatType(*kind.FunctionReturnType))
+		return "func<" + strings.Join(types, ", ") + ">"
+	}
+	return kind.String()
+}
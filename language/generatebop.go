@@ -16,10 +16,10 @@ func (exp ExpGreater) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymode
 		ao.Jg(greater)
 		ao.Jle(lessThanOrEqual)
 		ao.NewSection(greater)
-		ao.Mov(RAX, "1")
+		ao.Mov(RAX, assemblyoutput.Immediate(1))
 		ao.Jmp(done)
 		ao.NewSection(lessThanOrEqual)
-		ao.Mov(RAX, "0")
+		ao.Mov(RAX, assemblyoutput.Immediate(0))
 		ao.NewSection(done)
 	}
 	isValidKind := func(gr typesystem.Type) bool {
@@ -37,10 +37,10 @@ func (exp ExpLess) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.M
 		ao.Jl(less)
 		ao.Jge(greaterThanOrEqual)
 		ao.NewSection(less)
-		ao.Mov(RAX, "1")
+		ao.Mov(RAX, assemblyoutput.Immediate(1))
 		ao.Jmp(done)
 		ao.NewSection(greaterThanOrEqual)
-		ao.Mov(RAX, "0")
+		ao.Mov(RAX, assemblyoutput.Immediate(0))
 		ao.NewSection(done)
 	}
 	isValidKind := func(gr typesystem.Type) bool {
@@ -58,10 +58,10 @@ func (exp ExpEquals) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel
 		ao.Je(equal)
 		ao.Jne(notEqual)
 		ao.NewSection(equal)
-		ao.Mov(RAX, "1")
+		ao.Mov(RAX, assemblyoutput.Immediate(1))
 		ao.Jmp(done)
 		ao.NewSection(notEqual)
-		ao.Mov(RAX, "0")
+		ao.Mov(RAX, assemblyoutput.Immediate(0))
 		ao.NewSection(done)
 	}
 	isValidKind := func(gr typesystem.Type) bool {
@@ -79,10 +79,10 @@ func (exp ExpNotEquals) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymo
 		ao.Je(equal)
 		ao.Jne(notEqual)
 		ao.NewSection(equal)
-		ao.Mov(RAX, "0")
+		ao.Mov(RAX, assemblyoutput.Immediate(0))
 		ao.Jmp(done)
 		ao.NewSection(notEqual)
-		ao.Mov(RAX, "1")
+		ao.Mov(RAX, assemblyoutput.Immediate(1))
 		ao.NewSection(done)
 	}
 	isValidKind := func(gr typesystem.Type) bool {
@@ -124,7 +124,7 @@ func (exp ExpMinus) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.
 
 func (exp ExpDivide) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
 	operation := func(ao *assemblyoutput.AssemblyOutput) {
-		ao.Mov(RDX, "0")
+		ao.Mov(RDX, assemblyoutput.Immediate(0))
 		ao.Mov(RCX, RAX)
 		ao.Mov(RAX, RBX)
 		ao.Div(RCX)
@@ -137,7 +137,7 @@ func (exp ExpDivide) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel
 
 func (exp ExpModulo) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
 	operation := func(ao *assemblyoutput.AssemblyOutput) {
-		ao.Mov(RDX, "0")
+		ao.Mov(RDX, assemblyoutput.Immediate(0))
 		ao.Mov(RCX, RAX)
 		ao.Mov(RAX, RBX)
 		ao.Div(RCX)
@@ -158,16 +158,40 @@ func HelpGenerateStackBop(
 	kind typesystem.Type,
 	isValidKind func(kind typesystem.Type) bool,
 ) (typesystem.Type, error) {
-	kindLeft, err := exp.LeftExp().Generate(ao, mm)
-	if err != nil {
-		return typesystem.NewInvalid(), fmt.Errorf("failed to generate left expression of %s", name)
+	// A variable in a register can be read after the right side is generated,
+	// since expressions never assign to variables
+	var kindLeft typesystem.Type
+	leftVariable := registerVariable(mm, exp.LeftExp())
+	if leftVariable != nil {
+		kindLeft = leftVariable.Type
+	} else {
+		var err error
+		kindLeft, err = exp.LeftExp().Generate(ao, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), fmt.Errorf("failed to generate left expression of %s", name)
+		}
 	}
 	if !isValidKind(kindLeft) {
 		return typesystem.NewInvalid(), fmt.Errorf("invalidl type at left side of %s expression", name)
 	}
 
-	mm.CurrentStackSize++
-	ao.Push(RAX)
+	// Otherwise the left side is kept in rbx if the right side is a single
+	// move into rax, in a register if one is available, and on the stack if not
+	singleMove := mm.UseRegisters && isSingleMove(exp.RightExp())
+	var temporary string
+	var inRegister bool
+	switch {
+	case leftVariable != nil:
+	case singleMove:
+		ao.Mov(RBX, RAX)
+	default:
+		temporary, inRegister = mm.AllocateTemporary()
+		if inRegister {
+			ao.Mov(assemblyoutput.Register(temporary), RAX)
+		} else {
+			ao.Push(RAX)
+		}
+	}
 
 	kindRight, err := exp.RightExp().Generate(ao, mm)
 	if err != nil {
@@ -180,8 +204,38 @@ func HelpGenerateStackBop(
 	if !kindLeft.Equals(kindRight) {
 		return typesystem.NewInvalid(), fmt.Errorf("mismatching kinds in %s expression", name)
 	}
-	mm.CurrentStackSize--
-	ao.Pop(RBX)
+	switch {
+	case leftVariable != nil:
+		ao.Mov(RBX, assemblyoutput.Register(leftVariable.Register))
+	case singleMove:
+	case inRegister:
+		mm.FreeTemporary(temporary)
+		ao.Mov(RBX, assemblyoutput.Register(temporary))
+	default:
+		ao.Pop(RBX)
+	}
 	operation(ao)
 	return kind, nil
 }
+
+// registerVariable returns the variable that the expression reads, if it is stored in a register
+func registerVariable(mm *memorymodel.MemoryModel, exp Exp) *memorymodel.ContextElement {
+	identifier, ok := exp.(ExpIdentifier)
+	if !ok {
+		return nil
+	}
+	element := mm.GetStackElement(identifier.Name)
+	if element == nil || element.Register == "" {
+		return nil
+	}
+	return element
+}
+
+// isSingleMove is true for expressions that are generated as one mov into rax
+func isSingleMove(exp Exp) bool {
+	switch exp.(type) {
+	case ExpNum, ExpChar, ExpBool, ExpNone, ExpIdentifier:
+		return true
+	}
+	return false
+}
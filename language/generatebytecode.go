@@ -0,0 +1,501 @@
+package language
+
+import (
+	"callmemaybe/language/bytecode"
+	"callmemaybe/language/memorymodel"
+	"callmemaybe/language/typesystem"
+	"fmt"
+)
+
+// bytecodeGenerator compiles a program to bytecode. Variables get the same
+// slots as on the stack in the x86 backend, and globals and functions are
+// found by the label that the memory model has for them.
+type bytecodeGenerator struct {
+	program   *bytecode.Program
+	current   *bytecode.Function
+	main      *bytecode.Function
+	globals   map[string]int
+	functions map[string]int
+}
+
+// GenerateBytecode compiles the program to bytecode, where the first
+// function runs the top-level statements.
+func GenerateBytecode(stmt Stmt) (*bytecode.Program, error) {
+	g := &bytecodeGenerator{
+		program:   &bytecode.Program{},
+		globals:   make(map[string]int),
+		functions: make(map[string]int),
+	}
+	g.main = g.program.Functions[g.program.AddFunction("main")]
+	g.current = g.main
+
+	mm := memorymodel.NewMemoryModel()
+	err := g.stmt(stmt, mm)
+	if err != nil {
+		return nil, err
+	}
+	g.main.Locals = mm.FrameSize()
+	g.main.Emit(bytecode.OpNone)
+	g.main.Emit(bytecode.OpReturn)
+	return g.program, nil
+}
+
+func (g *bytecodeGenerator) stmt(stmt Stmt, mm *memorymodel.MemoryModel) error {
+	switch stmt := stmt.(type) {
+	case StmtSeq:
+		for _, statement := range stmt.declarations() {
+			switch declaration := statement.(type) {
+			case StmtFunctionDeclaration:
+				g.functions[declaration.procedureName()] = g.program.AddFunction(declaration.Name)
+				mm.AddProcedure(declaration.Name, declaration.Function.Type, declaration.procedureName())
+			case StmtExtern:
+				index := g.program.AddFunction(declaration.Name)
+				g.program.Functions[index].Extern = true
+				g.program.Functions[index].Arguments = len(declaration.Type.FunctionArgumentTypes)
+				g.functions[declaration.procedureName()] = index
+				mm.AddProcedure(declaration.Name, declaration.Type, declaration.procedureName())
+			}
+		}
+		for _, statement := range stmt.Statements {
+			err := g.stmt(statement, mm)
+			if err != nil {
+				return err
+			}
+		}
+	case StmtAssign:
+		kind, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		if len(stmt.Identifiers) > 0 {
+			for i, identifier := range stmt.Identifiers {
+				if identifier == "_" {
+					continue
+				}
+				g.current.Emit(bytecode.OpDup)
+				g.current.Emit(bytecode.OpElement, int64(i))
+				g.assign(mm, identifier, kind.TupleElementTypes[i], stmt.Constant)
+			}
+			g.current.Emit(bytecode.OpPop)
+			return nil
+		}
+		if stmt.Type != nil && kind.RawType == typesystem.Option && kind.OptionElementType == nil {
+			kind = *stmt.Type
+		}
+		g.assign(mm, stmt.Identifier, kind, stmt.Constant)
+	case StmtPrintln:
+		kind, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		switch kind.RawType {
+		case typesystem.Char:
+			g.current.Emit(bytecode.OpPrintChar)
+		case typesystem.Int, typesystem.Bool:
+			g.current.Emit(bytecode.OpPrintInt)
+		default:
+			g.current.Emit(bytecode.OpPrintString)
+		}
+	case StmtReturn:
+		if call, ok := stmt.Expression.(FunctionCall); ok {
+			_, err := g.call(call, mm)
+			if err != nil {
+				return err
+			}
+			g.current.Emit(bytecode.OpTailCall, int64(len(call.Arguments)))
+			return nil
+		}
+		_, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		g.current.Emit(bytecode.OpReturn)
+	case StmtIf:
+		mm.PushNewContext(true)
+		_, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		end := g.current.Emit(bytecode.OpJumpIfFalse)
+		err = g.stmt(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		g.current.Patch(end)
+		mm.PopCurrentContext()
+	case StmtLoop:
+		mm.PushNewContext(true)
+		start := len(g.current.Code)
+		_, err := g.exp(stmt.Condition, mm)
+		if err != nil {
+			return err
+		}
+		end := g.current.Emit(bytecode.OpJumpIfFalse)
+		err = g.stmt(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		g.current.Emit(bytecode.OpJump, int64(start))
+		g.current.Patch(end)
+		mm.PopCurrentContext()
+	case StmtBlock:
+		mm.PushNewContext(true)
+		err := g.stmt(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		mm.PopCurrentContext()
+	case StmtUnreachable:
+		return nil
+	case StmtIfSome:
+		mm.PushNewContext(true)
+		end, err := g.unwrap(mm, stmt.Identifier, stmt.Expression)
+		if err != nil {
+			return err
+		}
+		err = g.stmt(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		g.current.Patch(end)
+		mm.PopCurrentContext()
+	case StmtLoopSome:
+		mm.PushNewContext(true)
+		start := len(g.current.Code)
+		end, err := g.unwrap(mm, stmt.Identifier, stmt.Expression)
+		if err != nil {
+			return err
+		}
+		err = g.stmt(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		g.current.Emit(bytecode.OpJump, int64(start))
+		g.current.Patch(end)
+		mm.PopCurrentContext()
+	case StmtFunctionDeclaration:
+		err := g.function(mm, stmt.Function, g.functions[stmt.procedureName()])
+		if err != nil {
+			return fmt.Errorf("function %s: %w", stmt.Name, err)
+		}
+	case StmtExtern:
+		return nil
+	case StmtStructDeclaration:
+		mm.NewStructType(stmt.Type.StructName, stmt.Type)
+	case StmtUpdateList:
+		for _, exp := range []Exp{stmt.List, stmt.NewValue, stmt.Index} {
+			_, err := g.exp(exp, mm)
+			if err != nil {
+				return err
+			}
+		}
+		g.current.Emit(bytecode.OpSetIndex)
+	case StmtUpdateStruct:
+		kind, err := g.exp(stmt.Struct, mm)
+		if err != nil {
+			return err
+		}
+		_, err = g.exp(stmt.NewValue, mm)
+		if err != nil {
+			return err
+		}
+		member, err := memberIndex(mm, kind, stmt.Member)
+		if err != nil {
+			return err
+		}
+		g.current.Emit(bytecode.OpSetField, int64(member))
+	default:
+		return fmt.Errorf("the bytecode compiler does not support %T", stmt)
+	}
+	return nil
+}
+
+func (g *bytecodeGenerator) exp(exp Exp, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
+	switch exp := exp.(type) {
+	case ExpParentheses:
+		return g.exp(exp.Inside, mm)
+	case ExpNum:
+		g.current.Emit(bytecode.OpPush, int64(exp.Value))
+		return typesystem.NewInt(), nil
+	case ExpChar:
+		g.current.Emit(bytecode.OpPush, int64(exp.Value[0]))
+		return typesystem.NewChar(), nil
+	case ExpBool:
+		value := int64(0)
+		if exp.Value {
+			value = 1
+		}
+		g.current.Emit(bytecode.OpPush, value)
+		return typesystem.NewBool(), nil
+	case ExpNone:
+		g.current.Emit(bytecode.OpNone)
+		return typesystem.NewNone(), nil
+	case ExpSome:
+		kind, err := g.exp(exp.Inside, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpSome)
+		return typesystem.NewOption(kind), nil
+	case ExpIdentifier:
+		element := mm.GetStackElement(exp.Name)
+		if element == nil {
+			return typesystem.NewInvalid(), fmt.Errorf("missing from context: %s", exp.Name)
+		}
+		switch {
+		case element.Procedure != "":
+			g.current.Emit(bytecode.OpFunction, int64(g.functions[element.Procedure]))
+		case element.Global != "":
+			g.current.Emit(bytecode.OpLoadGlobal, int64(g.globals[element.Global]))
+		default:
+			g.current.Emit(bytecode.OpLoad, int64(slot(element)))
+		}
+		return element.Type, nil
+	case ExpFunction:
+		index := g.program.AddFunction("lambda")
+		err := g.function(mm, exp, index)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpFunction, int64(index))
+		return exp.Type, nil
+	case FunctionCall:
+		kind, err := g.call(exp, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpCall, int64(len(exp.Arguments)))
+		return *kind.FunctionReturnType, nil
+	case ExpList:
+		if characters, ok := stringLiteral(exp); ok {
+			g.current.Emit(bytecode.OpString, int64(g.program.AddString(characters)))
+			return exp.Type, nil
+		}
+		for _, element := range exp.Elements {
+			_, err := g.exp(element, mm)
+			if err != nil {
+				return typesystem.NewInvalid(), err
+			}
+		}
+		g.current.Emit(bytecode.OpList, int64(exp.Size), int64(len(exp.Elements)))
+		return exp.Type, nil
+	case ExpTuple:
+		var kinds []typesystem.Type
+		for _, element := range exp.Elements {
+			kind, err := g.exp(element, mm)
+			if err != nil {
+				return typesystem.NewInvalid(), err
+			}
+			kinds = append(kinds, kind)
+		}
+		g.current.Emit(bytecode.OpTuple, int64(len(exp.Elements)))
+		return typesystem.NewTuple(kinds), nil
+	case ExpGetFromList:
+		_, err := g.exp(exp.Index, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		kind, err := g.exp(exp.List, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpIndex)
+		return *kind.ListElementType, nil
+	case StructExp:
+		for _, member := range exp.Members {
+			_, err := g.exp(member.Exp, mm)
+			if err != nil {
+				return typesystem.NewInvalid(), err
+			}
+		}
+		g.current.Emit(bytecode.OpStruct, int64(len(exp.Members)))
+		return typesystem.Type{RawType: typesystem.Struct, StructName: exp.Name}, nil
+	case ExpReadFromStruct:
+		kind, err := g.exp(exp.Struct, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		if declared, ok := mm.GetStructType(kind.StructName); ok {
+			kind = declared
+		}
+		member, err := memberIndex(mm, kind, exp.Field)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpField, int64(member))
+		return kind.StructMembers[member].Type, nil
+	case ExpLength:
+		_, err := g.exp(exp.List, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpLength)
+		return typesystem.NewInt(), nil
+	case ExpNegative:
+		_, err := g.exp(exp.Inside, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+		g.current.Emit(bytecode.OpNeg)
+		return typesystem.NewInt(), nil
+	case ExpPlus:
+		return g.binary(mm, exp, bytecode.OpAdd, typesystem.NewInt())
+	case ExpMinus:
+		return g.binary(mm, exp, bytecode.OpSub, typesystem.NewInt())
+	case ExpMultiply:
+		return g.binary(mm, exp, bytecode.OpMul, typesystem.NewInt())
+	case ExpDivide:
+		return g.binary(mm, exp, bytecode.OpDiv, typesystem.NewInt())
+	case ExpModulo:
+		return g.binary(mm, exp, bytecode.OpMod, typesystem.NewInt())
+	case ExpLess:
+		return g.binary(mm, exp, bytecode.OpLess, typesystem.NewBool())
+	case ExpGreater:
+		return g.binary(mm, exp, bytecode.OpGreater, typesystem.NewBool())
+	case ExpEquals:
+		return g.binary(mm, exp, bytecode.OpEqual, typesystem.NewBool())
+	case ExpNotEquals:
+		return g.binary(mm, exp, bytecode.OpNotEqual, typesystem.NewBool())
+	}
+	return typesystem.NewInvalid(), fmt.Errorf("the bytecode compiler does not support %T", exp)
+}
+
+func (g *bytecodeGenerator) binary(mm *memorymodel.MemoryModel, exp ExpBop, op bytecode.Opcode, kind typesystem.Type) (typesystem.Type, error) {
+	_, err := g.exp(exp.LeftExp(), mm)
+	if err != nil {
+		return typesystem.NewInvalid(), err
+	}
+	_, err = g.exp(exp.RightExp(), mm)
+	if err != nil {
+		return typesystem.NewInvalid(), err
+	}
+	g.current.Emit(op)
+	return kind, nil
+}
+
+// call pushes the function and then the arguments of a call, and returns
+// the type of the function
+func (g *bytecodeGenerator) call(call FunctionCall, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
+	kind, err := g.exp(call.Exp, mm)
+	if err != nil {
+		return typesystem.NewInvalid(), err
+	}
+	for _, argument := range call.Arguments {
+		_, err := g.exp(argument, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), err
+		}
+	}
+	return kind, nil
+}
+
+// assign pops the value on top of the stack into the variable with the
+// given identifier, which is declared like in cGenerator.assign
+func (g *bytecodeGenerator) assign(mm *memorymodel.MemoryModel, identifier string, kind typesystem.Type, constant bool) {
+	if identifier == "_" {
+		g.current.Emit(bytecode.OpPop)
+		return
+	}
+	if mm.Contains(identifier) {
+		member := mm.GetStackElement(identifier)
+		if member.Type.RawType == typesystem.Option && member.Type.OptionElementType == nil {
+			member.Type = kind
+		}
+		g.current.Emit(bytecode.OpStore, int64(slot(member)))
+		return
+	}
+	if constant && g.current == g.main {
+		label := fmt.Sprintf("global%d", g.program.Globals)
+		g.globals[label] = g.program.Globals
+		g.program.Globals++
+		g.current.Emit(bytecode.OpStoreGlobal, int64(g.globals[label]))
+		mm.AddGlobalConstant(identifier, kind, label)
+		return
+	}
+	mm.AddLocal(identifier, kind, constant)
+	g.current.Emit(bytecode.OpStore, int64(slot(mm.GetStackElement(identifier))))
+}
+
+// unwrap evaluates an option, and declares a new variable with the value it
+// holds, even if the identifier is already declared. It returns the jump that has to be patched to where execution
+// continues when the option is none.
+func (g *bytecodeGenerator) unwrap(mm *memorymodel.MemoryModel, identifier string, exp Exp) (int, error) {
+	kind, err := g.exp(exp, mm)
+	if err != nil {
+		return 0, err
+	}
+	g.current.Emit(bytecode.OpDup)
+	end := g.current.Emit(bytecode.OpJumpIfNone)
+	g.current.Emit(bytecode.OpUnwrap)
+	mm.AddLocal(identifier, *kind.OptionElementType, false)
+	g.current.Emit(bytecode.OpStore, int64(slot(mm.GetStackElement(identifier))))
+	// The option is still on the stack when it is none
+	skip := g.current.Emit(bytecode.OpJump)
+	g.current.Patch(end)
+	g.current.Emit(bytecode.OpPop)
+	none := g.current.Emit(bytecode.OpJump)
+	g.current.Patch(skip)
+	return none, nil
+}
+
+// function compiles a function literal into the function with the given index
+func (g *bytecodeGenerator) function(mm *memorymodel.MemoryModel, exp ExpFunction, index int) error {
+	mm.PushNewContext(false)
+	outer := g.current
+	g.current = g.program.Functions[index]
+	label := fmt.Sprintf("function%d", index)
+	g.functions[label] = index
+
+	for _, argument := range exp.Type.FunctionArgumentTypes {
+		mm.AddLocal(argument.Name, argument.Type, false)
+	}
+	if exp.Recurse != "" {
+		mm.AddProcedure(exp.Recurse, exp.Type, label)
+	}
+	err := g.stmt(exp.Body, mm)
+	if err != nil {
+		return fmt.Errorf("function body: %w", err)
+	}
+	g.current.Arguments = len(exp.Type.FunctionArgumentTypes)
+	g.current.Locals = mm.FrameSize()
+	g.current.Emit(bytecode.OpNone)
+	g.current.Emit(bytecode.OpReturn)
+	mm.PopCurrentContext()
+
+	g.current = outer
+	return nil
+}
+
+// slot is the index of a local variable in its frame
+func slot(element *memorymodel.ContextElement) int {
+	return -element.Offset/8 - 1
+}
+
+// memberIndex is the position of a member in a struct
+func memberIndex(mm *memorymodel.MemoryModel, kind typesystem.Type, name string) (int, error) {
+	if declared, ok := mm.GetStructType(kind.StructName); ok {
+		kind = declared
+	}
+	for i, member := range kind.StructMembers {
+		if member.Name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid field %s", name)
+}
+
+// stringLiteral is the string of a list of char literals that fills the whole list
+func stringLiteral(list ExpList) (string, bool) {
+	if list.Type.ListElementType.RawType != typesystem.Char || len(list.Elements) != list.Size {
+		return "", false
+	}
+	characters := make([]byte, len(list.Elements))
+	for i, element := range list.Elements {
+		char, ok := element.(ExpChar)
+		if !ok {
+			return "", false
+		}
+		characters[i] = char.Value[0]
+	}
+	return string(characters), true
+}
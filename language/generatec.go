@@ -0,0 +1,959 @@
+package language
+
+import (
+	"callmemaybe/language/memorymodel"
+	"callmemaybe/language/typesystem"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// cRuntime is included in every C program. Allocations are zeroed,
+// division works on unsigned numbers like div does in the x86 backend, and
+// indexes outside of a list stop the program like they do there.
+const cRuntime = `#include <stdbool.h>
+
+int printf(const char *format, ...);
+void *calloc(__SIZE_TYPE__ count, __SIZE_TYPE__ size);
+void free(void *pointer);
+int fflush(void *stream);
+
+static void *cmm_allocate(long size) {
+    return calloc(1, size);
+}
+
+static long cmm_divide(long left, long right) {
+    if (right == 0) {
+        __builtin_trap();
+    }
+    return (long)((unsigned long)left / (unsigned long)right);
+}
+
+static long cmm_modulo(long left, long right) {
+    if (right == 0) {
+        __builtin_trap();
+    }
+    return (long)((unsigned long)left % (unsigned long)right);
+}
+
+static void cmm_check_index(long index, long length) {
+    if (index < 0 || index >= length) {
+        fflush(0);
+        __builtin_trap();
+    }
+}
+
+`
+
+// cStringRuntime converts between strings and null terminated C strings,
+// and prints strings. It comes after the definition of list_char.
+const cStringRuntime = `static list_char cmm_string(long length, const char *characters) {
+    list_char list = cmm_allocate(sizeof(struct list_char) + length);
+    list->length = length;
+    for (long i = 0; i < length; i++) {
+        list->elements[i] = characters[i];
+    }
+    return list;
+}
+
+static void cmm_print_string(list_char string) {
+    for (long i = 0; i < string->length; i++) {
+        printf("%c", string->elements[i]);
+    }
+    printf("\n");
+}
+
+static char *cmm_to_cstring(list_char list) {
+    char *string = cmm_allocate(list->length + 1);
+    for (long i = 0; i < list->length; i++) {
+        string[i] = list->elements[i];
+    }
+    return string;
+}
+
+static list_char cmm_from_cstring(const char *string) {
+    long length = 0;
+    while (string != 0 && string[length] != 0) {
+        length++;
+    }
+    return cmm_string(length, string);
+}
+
+`
+
+// cRuntimeFunctions are declared by the runtime, so externs can not use them
+var cRuntimeFunctions = []string{"printf", "calloc", "free"}
+
+// cGenerator translates a program to C. Types, function prototypes, globals
+// and functions are collected separately, since a function literal is
+// translated to a C function in the middle of the function that uses it.
+type cGenerator struct {
+	types      strings.Builder
+	prototypes strings.Builder
+	globals    strings.Builder
+	functions  strings.Builder
+	defined    map[string]bool
+	main       *cFunction
+	current    *cFunction
+	counter    int
+}
+
+// cFunction is the body of the C function that is being generated
+type cFunction struct {
+	name   string
+	body   strings.Builder
+	indent int
+	// restart is set when a tail call jumps back to the start of the function
+	restart bool
+}
+
+// GenerateC translates the program to C. Ints are longs, structs are
+// pointers to C structs, lists are pointers to a length followed by the
+// elements, tuples are C structs that are passed by value and functions are
+// C functions. Options of pointers use 0 as none, and other options point
+// to a copy of the value.
+func GenerateC(stmt Stmt) (string, error) {
+	g := &cGenerator{
+		defined: make(map[string]bool),
+		main:    &cFunction{name: "main", indent: 1},
+	}
+	g.current = g.main
+	g.typeName(typesystem.Type{RawType: typesystem.List, ListElementType: &typesystem.Type{RawType: typesystem.Char}})
+
+	err := g.stmt(stmt, memorymodel.NewMemoryModel())
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	builder.WriteString(cRuntime)
+	builder.WriteString(g.types.String())
+	builder.WriteString("\n")
+	builder.WriteString(cStringRuntime)
+	builder.WriteString(g.prototypes.String())
+	builder.WriteString("\n")
+	if g.globals.Len() > 0 {
+		builder.WriteString(g.globals.String())
+		builder.WriteString("\n")
+	}
+	builder.WriteString(g.functions.String())
+	builder.WriteString("int main(void) {\n")
+	builder.WriteString(g.main.body.String())
+	builder.WriteString("    return 0;\n}\n")
+	return builder.String(), nil
+}
+
+func (g *cGenerator) stmt(stmt Stmt, mm *memorymodel.MemoryModel) error {
+	switch stmt := stmt.(type) {
+	case StmtSeq:
+		for _, statement := range stmt.declarations() {
+			switch declaration := statement.(type) {
+			case StmtFunctionDeclaration:
+				mm.AddProcedure(declaration.Name, declaration.Function.Type, declaration.procedureName())
+			case StmtExtern:
+				mm.AddProcedure(declaration.Name, declaration.Type, declaration.procedureName())
+			}
+		}
+		for _, statement := range stmt.Statements {
+			err := g.stmt(statement, mm)
+			if err != nil {
+				return err
+			}
+		}
+	case StmtAssign:
+		value, kind, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		if len(stmt.Identifiers) > 0 {
+			tuple := g.temporary(kind, value)
+			for i, identifier := range stmt.Identifiers {
+				g.assign(mm, identifier, fmt.Sprintf("%s.e%d", tuple, i), kind.TupleElementTypes[i], stmt.Constant)
+			}
+			return nil
+		}
+		if stmt.Type != nil && kind.RawType == typesystem.Option && kind.OptionElementType == nil {
+			kind = *stmt.Type
+		}
+		g.assign(mm, stmt.Identifier, value, kind, stmt.Constant)
+	case StmtPrintln:
+		value, kind, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		switch kind.RawType {
+		case typesystem.Char:
+			g.line(`printf("%%c\n", %s);`, value)
+		case typesystem.Int, typesystem.Bool:
+			g.line(`printf("%%d\n", (int)%s);`, value)
+		default:
+			g.line("cmm_print_string(%s);", value)
+		}
+	case StmtReturn:
+		if call, ok := stmt.Expression.(FunctionCall); ok && g.isSelfCall(mm, call) {
+			return g.tailCall(mm, call)
+		}
+		value, _, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		g.line("return %s;", value)
+	case StmtIf:
+		mm.PushNewContext(true)
+		condition, _, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		g.line("if (%s) {", cCondition(condition))
+		err = g.block(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		mm.PopCurrentContext()
+	case StmtLoop:
+		mm.PushNewContext(true)
+		// The condition is evaluated inside the loop if it needs statements of its own
+		outer := g.current.body
+		g.current.body = strings.Builder{}
+		g.current.indent++
+		condition, _, err := g.exp(stmt.Condition, mm)
+		if err != nil {
+			return err
+		}
+		g.current.indent--
+		statements := g.current.body.String()
+		g.current.body = outer
+		if statements == "" {
+			g.line("while (%s) {", cCondition(condition))
+		} else {
+			g.line("while (true) {")
+			g.current.body.WriteString(statements)
+			g.breakUnless(condition)
+		}
+		err = g.block(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		mm.PopCurrentContext()
+	case StmtBlock:
+		mm.PushNewContext(true)
+		g.line("{")
+		err := g.block(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		mm.PopCurrentContext()
+	case StmtUnreachable:
+		return nil
+	case StmtIfSome:
+		mm.PushNewContext(true)
+		option, kind, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		g.line("if (%s) {", option)
+		g.current.indent++
+		g.declare(mm, stmt.Identifier, *kind.OptionElementType, g.unwrap(option, kind))
+		g.current.indent--
+		err = g.block(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		mm.PopCurrentContext()
+	case StmtLoopSome:
+		mm.PushNewContext(true)
+		g.line("while (true) {")
+		g.current.indent++
+		option, kind, err := g.exp(stmt.Expression, mm)
+		if err != nil {
+			return err
+		}
+		g.current.indent--
+		g.breakUnless(option)
+		g.current.indent++
+		g.declare(mm, stmt.Identifier, *kind.OptionElementType, g.unwrap(option, kind))
+		g.current.indent--
+		err = g.block(stmt.Body, mm)
+		if err != nil {
+			return err
+		}
+		mm.PopCurrentContext()
+	case StmtFunctionDeclaration:
+		err := g.function(mm, stmt.Function, stmt.procedureName())
+		if err != nil {
+			return fmt.Errorf("function %s: %w", stmt.Name, err)
+		}
+		if stmt.Exported {
+			return g.exportWrapper(stmt)
+		}
+	case StmtExtern:
+		return g.externWrapper(stmt)
+	case StmtStructDeclaration:
+		mm.NewStructType(stmt.Type.StructName, stmt.Type)
+		g.defineStruct(stmt.Type)
+	case StmtUpdateList:
+		list, _, err := g.exp(stmt.List, mm)
+		if err != nil {
+			return err
+		}
+		value, _, err := g.exp(stmt.NewValue, mm)
+		if err != nil {
+			return err
+		}
+		index, _, err := g.exp(stmt.Index, mm)
+		if err != nil {
+			return err
+		}
+		g.line("cmm_check_index(%s, %s->length);", index, list)
+		g.line("%s->elements[%s] = %s;", list, index, value)
+	case StmtUpdateStruct:
+		structure, _, err := g.exp(stmt.Struct, mm)
+		if err != nil {
+			return err
+		}
+		value, _, err := g.exp(stmt.NewValue, mm)
+		if err != nil {
+			return err
+		}
+		g.line("%s->m_%s = %s;", structure, stmt.Member, value)
+	default:
+		return fmt.Errorf("the C backend does not support %T", stmt)
+	}
+	return nil
+}
+
+// exp translates an expression to a C expression without side effects.
+// Calls, allocations and reads from memory are stored in temporaries first,
+// so they happen in the same order as in the x86 backend.
+func (g *cGenerator) exp(exp Exp, mm *memorymodel.MemoryModel) (string, typesystem.Type, error) {
+	switch exp := exp.(type) {
+	case ExpParentheses:
+		return g.exp(exp.Inside, mm)
+	case ExpNum:
+		return cNumber(exp.Value), typesystem.NewInt(), nil
+	case ExpChar:
+		return cChar(exp.Value[0]), typesystem.NewChar(), nil
+	case ExpBool:
+		if exp.Value {
+			return "true", typesystem.NewBool(), nil
+		}
+		return "false", typesystem.NewBool(), nil
+	case ExpNone:
+		return "0", typesystem.NewNone(), nil
+	case ExpSome:
+		value, kind, err := g.exp(exp.Inside, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		option := typesystem.NewOption(kind)
+		if kind.IsPointer() {
+			return value, option, nil
+		}
+		box := g.temporary(option, fmt.Sprintf("cmm_allocate(sizeof(%s))", g.typeName(kind)))
+		g.line("*%s = %s;", box, value)
+		return box, option, nil
+	case ExpIdentifier:
+		element := mm.GetStackElement(exp.Name)
+		if element == nil {
+			return "", typesystem.NewInvalid(), fmt.Errorf("missing from context: %s", exp.Name)
+		}
+		return cVariable(exp.Name, element), element.Type, nil
+	case ExpFunction:
+		name := g.unique("lambda")
+		err := g.function(mm, exp, name)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		return name, exp.Type, nil
+	case FunctionCall:
+		function, kind, err := g.exp(exp.Exp, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		var arguments []string
+		for _, argument := range exp.Arguments {
+			value, _, err := g.exp(argument, mm)
+			if err != nil {
+				return "", typesystem.NewInvalid(), err
+			}
+			arguments = append(arguments, value)
+		}
+		call := fmt.Sprintf("%s(%s)", function, strings.Join(arguments, ", "))
+		returnType := *kind.FunctionReturnType
+		if returnType.RawType == typesystem.Void {
+			g.line("%s;", call)
+			return "0", returnType, nil
+		}
+		return g.temporary(returnType, call), returnType, nil
+	case ExpList:
+		element := *exp.Type.ListElementType
+		if characters, ok := cCharacters(exp); ok {
+			return g.temporary(exp.Type, fmt.Sprintf("cmm_string(%d, %s)", exp.Size, characters)), exp.Type, nil
+		}
+		list := g.temporary(exp.Type, fmt.Sprintf("cmm_allocate(sizeof(struct %s) + %d * sizeof(%s))", g.typeName(exp.Type), exp.Size, g.typeName(element)))
+		g.line("%s->length = %d;", list, exp.Size)
+		for i, element := range exp.Elements {
+			value, _, err := g.exp(element, mm)
+			if err != nil {
+				return "", typesystem.NewInvalid(), err
+			}
+			g.line("%s->elements[%d] = %s;", list, i, value)
+		}
+		return list, exp.Type, nil
+	case ExpTuple:
+		var values []string
+		var kinds []typesystem.Type
+		for _, element := range exp.Elements {
+			value, kind, err := g.exp(element, mm)
+			if err != nil {
+				return "", typesystem.NewInvalid(), err
+			}
+			values = append(values, value)
+			kinds = append(kinds, kind)
+		}
+		kind := typesystem.NewTuple(kinds)
+		return fmt.Sprintf("((%s){%s})", g.typeName(kind), strings.Join(values, ", ")), kind, nil
+	case ExpGetFromList:
+		index, _, err := g.exp(exp.Index, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		list, kind, err := g.exp(exp.List, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		element := *kind.ListElementType
+		g.line("cmm_check_index(%s, %s->length);", index, list)
+		return g.temporary(element, fmt.Sprintf("%s->elements[%s]", list, index)), element, nil
+	case StructExp:
+		kind := typesystem.Type{RawType: typesystem.Struct, StructName: exp.Name}
+		structure := g.temporary(kind, fmt.Sprintf("cmm_allocate(sizeof(struct %s))", cStructTag(exp.Name)))
+		for _, member := range exp.Members {
+			value, _, err := g.exp(member.Exp, mm)
+			if err != nil {
+				return "", typesystem.NewInvalid(), err
+			}
+			g.line("%s->m_%s = %s;", structure, member.Name, value)
+		}
+		return structure, kind, nil
+	case ExpReadFromStruct:
+		structure, kind, err := g.exp(exp.Struct, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		if declared, ok := mm.GetStructType(kind.StructName); ok {
+			kind = declared
+		}
+		for _, member := range kind.StructMembers {
+			if member.Name == exp.Field {
+				return g.temporary(member.Type, fmt.Sprintf("%s->m_%s", structure, exp.Field)), member.Type, nil
+			}
+		}
+		return "", typesystem.NewInvalid(), fmt.Errorf("invalid field")
+	case ExpLength:
+		list, _, err := g.exp(exp.List, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		return g.temporary(typesystem.NewInt(), list+"->length"), typesystem.NewInt(), nil
+	case ExpNegative:
+		value, kind, err := g.exp(exp.Inside, mm)
+		if err != nil {
+			return "", typesystem.NewInvalid(), err
+		}
+		return fmt.Sprintf("(-%s)", value), kind, nil
+	case ExpPlus:
+		return g.binary(mm, exp, "(%s + %s)", typesystem.NewInt())
+	case ExpMinus:
+		return g.binary(mm, exp, "(%s - %s)", typesystem.NewInt())
+	case ExpMultiply:
+		return g.binary(mm, exp, "(%s * %s)", typesystem.NewInt())
+	case ExpDivide:
+		return g.binary(mm, exp, "cmm_divide(%s, %s)", typesystem.NewInt())
+	case ExpModulo:
+		return g.binary(mm, exp, "cmm_modulo(%s, %s)", typesystem.NewInt())
+	case ExpLess:
+		return g.binary(mm, exp, "(%s < %s)", typesystem.NewBool())
+	case ExpGreater:
+		return g.binary(mm, exp, "(%s > %s)", typesystem.NewBool())
+	case ExpEquals:
+		return g.binary(mm, exp, "(%s == %s)", typesystem.NewBool())
+	case ExpNotEquals:
+		return g.binary(mm, exp, "(%s != %s)", typesystem.NewBool())
+	}
+	return "", typesystem.NewInvalid(), fmt.Errorf("the C backend does not support %T", exp)
+}
+
+// binary formats an operation on the left and the right side, which are
+// evaluated in that order
+func (g *cGenerator) binary(mm *memorymodel.MemoryModel, exp ExpBop, format string, kind typesystem.Type) (string, typesystem.Type, error) {
+	left, _, err := g.exp(exp.LeftExp(), mm)
+	if err != nil {
+		return "", typesystem.NewInvalid(), err
+	}
+	right, _, err := g.exp(exp.RightExp(), mm)
+	if err != nil {
+		return "", typesystem.NewInvalid(), err
+	}
+	return fmt.Sprintf(format, left, right), kind, nil
+}
+
+// assign stores the value in the variable with the given identifier, and
+// declares it if it is not in the current context. Constants at the top
+// level are globals, so that functions can read them.
+func (g *cGenerator) assign(mm *memorymodel.MemoryModel, identifier string, value string, kind typesystem.Type, constant bool) {
+	if identifier == "_" {
+		return
+	}
+	if mm.Contains(identifier) {
+		member := mm.GetStackElement(identifier)
+		if member.Type.RawType == typesystem.Option && member.Type.OptionElementType == nil {
+			member.Type = kind
+		}
+		g.line("%s = %s;", cVariable(identifier, member), value)
+		return
+	}
+	if constant && g.current == g.main {
+		name := fmt.Sprintf("constant%d_%s", g.next(), identifier)
+		g.globals.WriteString(fmt.Sprintf("static %s;\n", cDeclaration(g.typeName(kind), name)))
+		g.line("%s = %s;", name, value)
+		mm.AddGlobalConstant(identifier, kind, name)
+		return
+	}
+	g.declare(mm, identifier, kind, value)
+	mm.GetStackElement(identifier).Constant = constant
+}
+
+// declare declares a new local variable in the current context
+func (g *cGenerator) declare(mm *memorymodel.MemoryModel, identifier string, kind typesystem.Type, value string) {
+	g.line("%s = %s;", cDeclaration(g.typeName(kind), "v_"+identifier), value)
+	mm.AddLocal(identifier, kind, false)
+}
+
+// unwrap is the value that an option holds
+func (g *cGenerator) unwrap(option string, kind typesystem.Type) string {
+	element := *kind.OptionElementType
+	if element.IsPointer() {
+		return fmt.Sprintf("(%s)%s", g.typeName(element), option)
+	}
+	return fmt.Sprintf("*(%s)%s", g.typeName(kind), option)
+}
+
+// block generates the body of an if or a loop, and closes it
+func (g *cGenerator) block(body Stmt, mm *memorymodel.MemoryModel) error {
+	g.current.indent++
+	err := g.stmt(body, mm)
+	if err != nil {
+		return err
+	}
+	g.current.indent--
+	g.line("}")
+	return nil
+}
+
+// breakUnless leaves the loop that is being generated if the condition is false
+func (g *cGenerator) breakUnless(condition string) {
+	g.current.indent++
+	g.line("if (!%s) {", condition)
+	g.current.indent++
+	g.line("break;")
+	g.current.indent--
+	g.line("}")
+	g.current.indent--
+}
+
+// function generates a C function with the given name for a function literal
+func (g *cGenerator) function(mm *memorymodel.MemoryModel, exp ExpFunction, name string) error {
+	mm.PushNewContext(false)
+	outer := g.current
+	g.current = &cFunction{name: name, indent: 1}
+
+	var parameters []string
+	for _, argument := range exp.Type.FunctionArgumentTypes {
+		parameters = append(parameters, cDeclaration(g.typeName(argument.Type), "v_"+argument.Name))
+		mm.AddLocal(argument.Name, argument.Type, false)
+	}
+	if exp.Recurse != "" {
+		mm.AddProcedure(exp.Recurse, exp.Type, name)
+	}
+	err := g.stmt(exp.Body, mm)
+	if err != nil {
+		return fmt.Errorf("function body: %w", err)
+	}
+	mm.PopCurrentContext()
+
+	g.finish(fmt.Sprintf("static %s", cSignature(g.typeName(*exp.Type.FunctionReturnType), name, parameters)))
+	g.current = outer
+	return nil
+}
+
+// finish adds the function that is being generated to the program
+func (g *cGenerator) finish(signature string) {
+	g.prototypes.WriteString(signature + ";\n")
+	g.functions.WriteString(signature + " {\n")
+	if g.current.restart {
+		g.functions.WriteString("restart:;\n")
+	}
+	g.functions.WriteString(g.current.body.String())
+	g.functions.WriteString("}\n\n")
+}
+
+// isSelfCall is true if the call is to the function that is currently being generated
+func (g *cGenerator) isSelfCall(mm *memorymodel.MemoryModel, call FunctionCall) bool {
+	identifier, ok := call.Exp.(ExpIdentifier)
+	if g.current == g.main || !ok {
+		return false
+	}
+	element := mm.GetStackElement(identifier.Name)
+	return element != nil && element.Procedure == g.current.name
+}
+
+// tailCall overwrites the arguments of the current function and jumps back
+// to its start, like the x86 backend does, so the stack does not grow
+// without relying on the C compiler
+func (g *cGenerator) tailCall(mm *memorymodel.MemoryModel, call FunctionCall) error {
+	kind := mm.GetStackElement(call.Exp.(ExpIdentifier).Name).Type
+	var values []string
+	for i, argument := range call.Arguments {
+		value, _, err := g.exp(argument, mm)
+		if err != nil {
+			return err
+		}
+		values = append(values, g.temporary(kind.FunctionArgumentTypes[i].Type, value))
+	}
+	for i, argument := range kind.FunctionArgumentTypes {
+		g.line("v_%s = %s;", argument.Name, values[i])
+	}
+	g.line("goto restart;")
+	g.current.restart = true
+	return nil
+}
+
+// externWrapper declares the C function, and generates a function that
+// converts strings to null terminated strings and back around the call
+func (g *cGenerator) externWrapper(stmt StmtExtern) error {
+	for _, name := range cRuntimeFunctions {
+		if stmt.Name == name {
+			return fmt.Errorf("extern %s is already declared by the runtime of the C backend", stmt.Name)
+		}
+	}
+	kind := stmt.Type
+	var cArguments, parameters, arguments, cStrings []string
+	for _, argument := range kind.FunctionArgumentTypes {
+		argumentType, err := cType(argument.Type, true)
+		if err != nil {
+			return fmt.Errorf("extern %s: %w", stmt.Name, err)
+		}
+		cArguments = append(cArguments, argumentType)
+		parameters = append(parameters, cDeclaration(g.typeName(argument.Type), "v_"+argument.Name))
+		arguments = append(arguments, "v_"+argument.Name)
+	}
+	returnType := *kind.FunctionReturnType
+	cReturnType := "void"
+	if returnType.RawType != typesystem.Void {
+		var err error
+		cReturnType, err = cType(returnType, false)
+		if err != nil {
+			return fmt.Errorf("extern %s: %w", stmt.Name, err)
+		}
+	}
+	g.prototypes.WriteString(cSignature(cReturnType, stmt.Name, cArguments) + ";\n")
+
+	outer := g.current
+	g.current = &cFunction{name: stmt.procedureName(), indent: 1}
+	for i, argument := range kind.FunctionArgumentTypes {
+		if argument.Type.RawType == typesystem.List {
+			arguments[i] = g.temporary(typesystem.Type{RawType: typesystem.Void}, "cmm_to_cstring(v_"+argument.Name+")")
+			cStrings = append(cStrings, arguments[i])
+		}
+	}
+	call := fmt.Sprintf("%s(%s)", stmt.Name, strings.Join(arguments, ", "))
+	result := ""
+	if returnType.RawType == typesystem.Void {
+		g.line("%s;", call)
+	} else {
+		result = g.unique("t")
+		g.line("%s = %s;", cDeclaration(cReturnType, result), call)
+	}
+	for _, cString := range cStrings {
+		g.line("free(%s);", cString)
+	}
+	if returnType.RawType == typesystem.List {
+		g.line("return cmm_from_cstring(%s);", result)
+	} else if result != "" {
+		g.line("return %s;", result)
+	}
+	g.finish(fmt.Sprintf("static %s", cSignature(g.typeName(returnType), stmt.procedureName(), parameters)))
+	g.current = outer
+	return nil
+}
+
+// exportWrapper generates a C function with the name of the exported
+// function, which takes and returns C types. Returned strings must be freed
+// by the caller.
+func (g *cGenerator) exportWrapper(stmt StmtFunctionDeclaration) error {
+	kind := stmt.Function.Type
+	var parameters, arguments []string
+	outer := g.current
+	g.current = &cFunction{name: stmt.Name, indent: 1}
+	for _, argument := range kind.FunctionArgumentTypes {
+		argumentType, err := cType(argument.Type, true)
+		if err != nil {
+			return fmt.Errorf("exported function %s: %w", stmt.Name, err)
+		}
+		parameters = append(parameters, cDeclaration(argumentType, "v_"+argument.Name))
+		if argument.Type.RawType == typesystem.List {
+			arguments = append(arguments, g.temporary(argument.Type, "cmm_from_cstring(v_"+argument.Name+")"))
+		} else {
+			arguments = append(arguments, "v_"+argument.Name)
+		}
+	}
+	call := fmt.Sprintf("%s(%s)", stmt.procedureName(), strings.Join(arguments, ", "))
+	returnType := *kind.FunctionReturnType
+	cReturnType := "void"
+	switch {
+	case returnType.RawType == typesystem.Void:
+		g.line("%s;", call)
+	case returnType.RawType == typesystem.List:
+		cReturnType = "char *"
+		g.line("return cmm_to_cstring(%s);", call)
+	default:
+		var err error
+		cReturnType, err = cType(returnType, false)
+		if err != nil {
+			return fmt.Errorf("exported function %s: %w", stmt.Name, err)
+		}
+		g.line("return %s;", call)
+	}
+	g.finish(cSignature(cReturnType, stmt.Name, parameters))
+	g.current = outer
+	return nil
+}
+
+// typeName is the C type of values of the given type. Lists, tuples and
+// functions get a typedef with a name that is derived from the type, which
+// is defined before it is first used.
+func (g *cGenerator) typeName(kind typesystem.Type) string {
+	switch kind.RawType {
+	case typesystem.Int:
+		return "long"
+	case typesystem.Char:
+		return "unsigned char"
+	case typesystem.Bool:
+		return "bool"
+	case typesystem.Struct:
+		tag := cStructTag(kind.StructName)
+		if !g.defined["struct "+tag] {
+			g.defined["struct "+tag] = true
+			g.types.WriteString(fmt.Sprintf("struct %s;\n", tag))
+		}
+		return fmt.Sprintf("struct %s *", tag)
+	case typesystem.Option:
+		if kind.OptionElementType == nil {
+			return "void *"
+		}
+		element := g.typeName(*kind.OptionElementType)
+		if kind.OptionElementType.IsPointer() {
+			return element
+		}
+		if strings.HasSuffix(element, "*") {
+			return element + "*"
+		}
+		return element + " *"
+	case typesystem.List, typesystem.Tuple, typesystem.Function:
+		name := cTypeName(kind)
+		if !g.defined[name] {
+			g.defineType(kind, name)
+			g.defined[name] = true
+		}
+		return name
+	}
+	return "void"
+}
+
+// defineType adds the typedef of a list, tuple or function type
+func (g *cGenerator) defineType(kind typesystem.Type, name string) {
+	switch kind.RawType {
+	case typesystem.List:
+		element := g.typeName(*kind.ListElementType)
+		g.types.WriteString(fmt.Sprintf("typedef struct %s {\n    long length;\n    %s;\n} *%s;\n", name, cDeclaration(element, "elements[]"), name))
+	case typesystem.Tuple:
+		var members []string
+		for i, element := range kind.TupleElementTypes {
+			members = append(members, fmt.Sprintf("    %s;\n", cDeclaration(g.typeName(element), fmt.Sprintf("e%d", i))))
+		}
+		g.types.WriteString(fmt.Sprintf("typedef struct %s {\n%s} %s;\n", name, strings.Join(members, ""), name))
+	case typesystem.Function:
+		var arguments []string
+		for _, argument := range kind.FunctionArgumentTypes {
+			arguments = append(arguments, g.typeName(argument.Type))
+		}
+		returnType := g.typeName(*kind.FunctionReturnType)
+		g.types.WriteString(fmt.Sprintf("typedef %s;\n", cSignature(returnType, "(*"+name+")", arguments)))
+	}
+}
+
+// defineStruct adds the definition of a declared struct
+func (g *cGenerator) defineStruct(kind typesystem.Type) {
+	tag := cStructTag(kind.StructName)
+	if g.defined["struct body "+tag] {
+		return
+	}
+	g.defined["struct body "+tag] = true
+	var members []string
+	for _, member := range kind.StructMembers {
+		members = append(members, fmt.Sprintf("    %s;\n", cDeclaration(g.typeName(member.Type), "m_"+member.Name)))
+	}
+	if len(members) == 0 {
+		members = append(members, "    char empty;\n")
+	}
+	g.types.WriteString(fmt.Sprintf("struct %s {\n%s};\n", tag, strings.Join(members, "")))
+}
+
+// temporary stores the value in a new variable, and returns its name
+func (g *cGenerator) temporary(kind typesystem.Type, value string) string {
+	name := g.unique("t")
+	typeName := "char *"
+	if kind.RawType != typesystem.Void {
+		typeName = g.typeName(kind)
+	}
+	g.line("%s = %s;", cDeclaration(typeName, name), value)
+	return name
+}
+
+func (g *cGenerator) unique(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, g.next())
+}
+
+func (g *cGenerator) next() int {
+	g.counter++
+	return g.counter
+}
+
+// line adds a line to the function that is being generated
+func (g *cGenerator) line(format string, arguments ...interface{}) {
+	g.current.body.WriteString(strings.Repeat("    ", g.current.indent))
+	g.current.body.WriteString(fmt.Sprintf(format, arguments...))
+	g.current.body.WriteString("\n")
+}
+
+// cVariable is the C name of a variable
+func cVariable(identifier string, element *memorymodel.ContextElement) string {
+	if element.Procedure != "" {
+		return element.Procedure
+	}
+	if element.Global != "" {
+		return element.Global
+	}
+	return "v_" + identifier
+}
+
+// cSignature formats a function declaration with the given parameters
+func cSignature(returnType string, name string, parameters []string) string {
+	if len(parameters) == 0 {
+		parameters = []string{"void"}
+	}
+	return cDeclaration(returnType, fmt.Sprintf("%s(%s)", name, strings.Join(parameters, ", ")))
+}
+
+// cTypeName derives a unique C identifier from a type. Tuples and functions
+// are prefixed with their number of elements and struct names with their
+// length, so different types never get the same name.
+func cTypeName(kind typesystem.Type) string {
+	switch kind.RawType {
+	case typesystem.Int:
+		return "int"
+	case typesystem.Char:
+		return "char"
+	case typesystem.Bool:
+		return "bool"
+	case typesystem.List:
+		return "list_" + cTypeName(*kind.ListElementType)
+	case typesystem.Struct:
+		return cStructTag(kind.StructName)
+	case typesystem.Option:
+		if kind.OptionElementType == nil {
+			return "none"
+		}
+		return "option_" + cTypeName(*kind.OptionElementType)
+	case typesystem.Tuple:
+		name := fmt.Sprintf("tuple%d", len(kind.TupleElementTypes))
+		for _, element := range kind.TupleElementTypes {
+			name += "_" + cTypeName(element)
+		}
+		return name
+	case typesystem.Function:
+		name := fmt.Sprintf("func%d", len(kind.FunctionArgumentTypes))
+		for _, argument := range kind.FunctionArgumentTypes {
+			name += "_" + cTypeName(argument.Type)
+		}
+		return name + "_" + cTypeName(*kind.FunctionReturnType)
+	}
+	return "void"
+}
+
+func cStructTag(name string) string {
+	return fmt.Sprintf("s%d_%s", len(name), name)
+}
+
+func cNumber(value int) string {
+	switch {
+	case value == math.MinInt64:
+		return "(-9223372036854775807 - 1)"
+	case value < 0:
+		return fmt.Sprintf("(%d)", value)
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+func cChar(value byte) string {
+	if value >= ' ' && value <= '~' && value != '\'' && value != '\\' {
+		return fmt.Sprintf("'%c'", value)
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// cCharacters formats a list of char literals that fills the whole list as
+// a C string literal
+func cCharacters(list ExpList) (string, bool) {
+	if list.Type.ListElementType.RawType != typesystem.Char || len(list.Elements) != list.Size {
+		return "", false
+	}
+	var builder strings.Builder
+	builder.WriteString("\"")
+	for _, element := range list.Elements {
+		char, ok := element.(ExpChar)
+		if !ok {
+			return "", false
+		}
+		value := char.Value[0]
+		if value >= ' ' && value <= '~' && value != '"' && value != '\\' && value != '?' {
+			builder.WriteByte(value)
+		} else {
+			builder.WriteString(fmt.Sprintf("\\%03o", value))
+		}
+	}
+	builder.WriteString("\"")
+	return builder.String(), true
+}
+
+// cCondition removes the parentheses around a condition, since if and while
+// have their own
+func cCondition(condition string) string {
+	if !strings.HasPrefix(condition, "(") || !strings.HasSuffix(condition, ")") {
+		return condition
+	}
+	depth := 0
+	for i, c := range condition {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && i < len(condition)-1 {
+			return condition
+		}
+	}
+	return condition[1 : len(condition)-1]
+}
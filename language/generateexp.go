@@ -5,7 +5,6 @@ import (
 	"callmemaybe/language/memorymodel"
 	"callmemaybe/language/typesystem"
 	"fmt"
-	"strconv"
 )
 
 func (exp ExpParentheses) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
@@ -13,82 +12,137 @@ func (exp ExpParentheses) Generate(ao *assemblyoutput.AssemblyOutput, mm *memory
 }
 
 func (exp ExpNum) Generate(ao *assemblyoutput.AssemblyOutput, _ *memorymodel.MemoryModel) (typesystem.Type, error) {
-	val := strconv.Itoa(exp.Value)
-	ao.Mov(RAX, val)
+	ao.Mov(RAX, assemblyoutput.Immediate(exp.Value))
 	return typesystem.NewInt(), nil
 }
 
 func (exp ExpChar) Generate(ao *assemblyoutput.AssemblyOutput, _ *memorymodel.MemoryModel) (typesystem.Type, error) {
-	rune := exp.Value[0]
-	ao.Mov(RAX, fmt.Sprintf("%d", rune))
+	ao.Mov(RAX, assemblyoutput.Immediate(exp.Value[0]))
 	return typesystem.NewChar(), nil
 }
 
+func (exp ExpNone) Generate(ao *assemblyoutput.AssemblyOutput, _ *memorymodel.MemoryModel) (typesystem.Type, error) {
+	ao.Mov(RAX, assemblyoutput.Immediate(0))
+	return typesystem.NewNone(), nil
+}
+
+func (exp ExpSome) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
+	kind, err := exp.Inside.Generate(ao, mm)
+	if err != nil {
+		return typesystem.NewInvalid(), fmt.Errorf("expression in some: %w", err)
+	}
+	if !kind.IsPassable() {
+		return typesystem.NewInvalid(), fmt.Errorf("expression in some must be passable")
+	}
+	if kind.IsPointer() {
+		return typesystem.NewOption(kind), nil
+	}
+	ao.Push(RAX)
+	ao.Mov(RDI, assemblyoutput.Immediate(8))
+	ao.CallMalloc()
+	ao.Pop(RBX)
+	ao.Mov(assemblyoutput.Memory{Size: "qword", Base: RAX}, RBX)
+	return typesystem.NewOption(kind), nil
+}
+
 func (exp ExpIdentifier) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
 	stackElement := mm.GetStackElement(exp.Name)
+	mm.Used(exp.Position, stackElement)
+	if stackElement != nil && stackElement.Procedure != "" {
+		ao.Mov(RAX, assemblyoutput.Label(stackElement.Procedure))
+		return stackElement.Type, nil
+	}
+	if stackElement != nil && stackElement.Global != "" {
+		ao.Mov(RAX, assemblyoutput.Memory{Label: assemblyoutput.Label(stackElement.Global)})
+		return stackElement.Type, nil
+	}
+	if stackElement != nil && stackElement.Register != "" {
+		ao.Mov(RAX, assemblyoutput.Register(stackElement.Register))
+		return stackElement.Type, nil
+	}
 	if stackElement != nil {
-		address := fmt.Sprintf("[rsp+%d]", (mm.CurrentStackSize-stackElement.StackSizeAfterPush)*8)
-		ao.Mov(RAX, address)
+		ao.Mov(RAX, assemblyoutput.FrameAddress(stackElement.Offset))
 		return stackElement.Type, nil
 	}
 	return typesystem.NewInvalid(), fmt.Errorf("missing from context: %s", exp.Name)
 }
 
 func (exp ExpFunction) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
+	name := ao.GenerateUniqueName()
+	err := generateProcedure(ao, mm, exp, name)
+	if err != nil {
+		return typesystem.NewInvalid(), err
+	}
+	ao.Mov(RAX, assemblyoutput.Label(name))
+	return exp.Type, nil
+}
+
+// generateProcedure generates the body of a function as a procedure with the given name
+func generateProcedure(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, exp ExpFunction, name string) error {
 	mm.PushNewContext(false)
-	name := ao.PushProcedure(mm.CurrentStackSize, len(exp.Type.FunctionArgumentTypes))
-	initialStackSize := mm.CurrentStackSize
+	ao.PushProcedure(name)
+	enterFrame(ao, mm)
+	mm.AllocateRegisters(functionIntervals(exp))
 
 	argNames := make(map[string]bool)
-	for _, arg := range exp.Type.FunctionArgumentTypes {
+	for i, arg := range exp.Type.FunctionArgumentTypes {
 		_, exists := argNames[arg.Name]
 		if exists {
-			return typesystem.NewInvalid(), fmt.Errorf("argument names should be unique")
+			return fmt.Errorf("argument names should be unique")
 		}
 		if arg.Type.RawType == typesystem.Struct {
 			var ok bool
 			arg.Type, ok = mm.GetStructType(arg.Type.StructName)
 			if !ok {
-				return typesystem.NewInvalid(), fmt.Errorf("type does not exist")
+				return fmt.Errorf("type does not exist")
 			}
 		}
-		mm.CurrentStackSize++
-		mm.AddNameToCurrentStackElement(arg.Name, arg.Type)
+		// The first arguments are passed in registers, and are moved to where
+		// variables are stored before the registers are used for anything else
+		if i < len(assemblyoutput.ArgumentRegisters) {
+			declare(ao, mm, arg.Name, arg.Type, false, assemblyoutput.ArgumentRegisters[i])
+		} else {
+			mm.AddArgument(arg.Name, arg.Type, assemblyoutput.StackArgumentOffset(i))
+		}
+		mm.Declared(positionAt(exp.Positions, i), arg.Name)
 		argNames[arg.Name] = true
 	}
 	if len(argNames) != len(exp.Type.FunctionArgumentTypes) {
-		return typesystem.NewInvalid(), fmt.Errorf("mismatching number of arguments")
+		return fmt.Errorf("mismatching number of arguments")
 	}
 
-	mm.CurrentStackSize++
-
-	ao.Mov(RAX, name)
-	mm.CurrentStackSize++
-	ao.Push(RAX)
-	mm.AddNameToCurrentStackElement(exp.Recurse, exp.Type)
+	if exp.Recurse != "" {
+		mm.AddProcedure(exp.Recurse, exp.Type, name)
+	}
+	mm.SetReturnType(*exp.Type.FunctionReturnType)
 
 	err := exp.Body.Generate(ao, mm)
 	if err != nil {
-		return typesystem.NewInvalid(), fmt.Errorf("function body: %w", err)
+		return fmt.Errorf("function body: %w", err)
 	}
 
-	mm.CurrentStackSize--
-
-	for i := 0; i < mm.CurrentStackSize-initialStackSize-len(exp.Type.FunctionArgumentTypes); i++ {
-		ao.Pop(RBX)
-	}
-	mm.CurrentStackSize = initialStackSize
+	ao.SetFrameSize(mm.FrameSize())
+	ao.LeaveFrame()
 	ao.Ret()
 	mm.PopCurrentContext()
 	ao.PopProcedure()
-	ao.Mov(RAX, name)
-	return exp.Type, nil
+	return nil
+}
+
+// enterFrame sets up the frame of the current function, and saves the
+// registers it has to preserve for its caller in the first slots
+func enterFrame(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) {
+	var saved []assemblyoutput.Register
+	for _, register := range mm.CalleeSavedRegisters() {
+		saved = append(saved, assemblyoutput.Register(register))
+	}
+	mm.ReserveSlots(len(saved))
+	ao.EnterFrame(saved)
 }
 
 func (stmt FunctionCall) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
 	kind, err := stmt.Exp.Generate(ao, mm)
 
-	mm.CurrentStackSize++
 	ao.Push(RAX)
 
 	if err != nil {
@@ -111,7 +165,6 @@ func (stmt FunctionCall) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorym
 			return typesystem.NewInvalid(), fmt.Errorf("argument type must be passable")
 		}
 
-		mm.CurrentStackSize++
 		ao.Push(RAX)
 		argKind := kind.FunctionArgumentTypes[i].Type
 		if !argKind.Equals(_kind) {
@@ -119,18 +172,12 @@ func (stmt FunctionCall) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorym
 		}
 	}
 
-	ao.Call(fmt.Sprintf("[rsp+%d]", len(stmt.Arguments)*8))
-	mm.CurrentStackSize--
-	ao.Pop(RBX)
-
-	for i := 0; i < len(stmt.Arguments); i++ {
-		mm.CurrentStackSize--
-		ao.Pop(RBX)
-	}
+	ao.CallFunction(len(stmt.Arguments))
 
 	if kind.FunctionReturnType == nil {
 		return typesystem.NewInvalid(), fmt.Errorf("functionreturntype is nil")
 	}
+	mm.Typed(stmt.Position, *kind.FunctionReturnType)
 
 	if kind.FunctionReturnType.RawType == typesystem.Struct {
 		val, ok := mm.GetStructType(kind.FunctionReturnType.StructName)
@@ -144,11 +191,11 @@ func (stmt FunctionCall) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorym
 }
 
 func (expr ExpBool) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
-	var val string
+	var val assemblyoutput.Immediate
 	if expr.Value {
-		val = "1"
+		val = 1
 	} else {
-		val = "0"
+		val = 0
 	}
 	ao.Mov(RAX, val)
 	return typesystem.NewBool(), nil
@@ -163,7 +210,7 @@ func (expr ExpNegative) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymo
 		return typesystem.NewInvalid(), fmt.Errorf("negative expressions only support algebraic kinds")
 	}
 	ao.Mov(RBX, RAX)
-	ao.Mov(RAX, "0")
+	ao.Mov(RAX, assemblyoutput.Immediate(0))
 	ao.Sub(RAX, RBX)
 	return typesystem.NewInt(), nil
 }
@@ -172,17 +219,16 @@ func (expr ExpList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.
 	if expr.Size < 0 {
 		return typesystem.NewInvalid(), fmt.Errorf("the size of a list must be a positive number")
 	}
-	ao.Mov(RDI, fmt.Sprintf("%d", 8*(expr.Size+1)))
-	ao.Call("malloc")
+	ao.Mov(RDI, assemblyoutput.Immediate(8*(expr.Size+1)))
+	ao.CallMalloc()
 	ao.Mov(RDX, RAX)
 
 	if expr.Size < len(expr.Elements) {
 		return typesystem.NewInvalid(), fmt.Errorf("too many elements in list")
 	}
 
-	ao.Mov(fmt.Sprintf("qword [%s]", RDX), fmt.Sprintf("%d", expr.Size))
+	ao.Mov(assemblyoutput.Memory{Size: "qword", Base: RDX}, assemblyoutput.Immediate(expr.Size))
 	for i, element := range expr.Elements {
-		mm.CurrentStackSize++
 		ao.Push(RDX)
 		kind, err := element.Generate(ao, mm)
 		if err != nil {
@@ -191,9 +237,8 @@ func (expr ExpList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.
 		if !kind.Equals(*expr.Type.ListElementType) {
 			return typesystem.NewInvalid(), fmt.Errorf("list element has the wrong type")
 		}
-		mm.CurrentStackSize--
 		ao.Pop(RDX)
-		ao.Mov(fmt.Sprintf("qword [%s+%d]", RDX, (i+1)*8), RAX)
+		ao.Mov(assemblyoutput.Memory{Size: "qword", Base: RDX, Displacement: (i + 1) * 8}, RAX)
 	}
 
 	ao.Mov(RAX, RDX)
@@ -201,6 +246,31 @@ func (expr ExpList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.
 	return expr.Type, nil
 }
 
+func (expr ExpTuple) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
+	ao.Mov(RDI, assemblyoutput.Immediate(8*len(expr.Elements)))
+	ao.CallMalloc()
+	ao.Mov(RDX, RAX)
+
+	var elementTypes []typesystem.Type
+	for i, element := range expr.Elements {
+		ao.Push(RDX)
+		kind, err := element.Generate(ao, mm)
+		if err != nil {
+			return typesystem.NewInvalid(), fmt.Errorf("failed to generate element in tuple: %w", err)
+		}
+		if !kind.IsPassable() {
+			return typesystem.NewInvalid(), fmt.Errorf("tuple elements must be passable")
+		}
+		ao.Pop(RDX)
+		ao.Mov(assemblyoutput.Memory{Size: "qword", Base: RDX, Displacement: i * 8}, RAX)
+		elementTypes = append(elementTypes, kind)
+	}
+
+	ao.Mov(RAX, RDX)
+
+	return typesystem.NewTuple(elementTypes), nil
+}
+
 func (expr ExpGetFromList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) (typesystem.Type, error) {
 	kind, err := expr.Index.Generate(ao, mm)
 	if err != nil {
@@ -209,7 +279,6 @@ func (expr ExpGetFromList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memor
 	if kind.RawType != typesystem.Int {
 		return typesystem.NewInvalid(), fmt.Errorf("only integers are valid indexes")
 	}
-	mm.CurrentStackSize++
 	ao.Push(RAX)
 	kind, err = expr.List.Generate(ao, mm)
 	if err != nil {
@@ -218,14 +287,15 @@ func (expr ExpGetFromList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memor
 	if kind.RawType != typesystem.List {
 		return typesystem.NewInvalid(), fmt.Errorf("can only get from lists by index")
 	}
-	mm.CurrentStackSize--
 	ao.Pop(RCX)
 	ao.Mov(RDX, RAX)
-	ao.Mov(RAX, fmt.Sprintf("[rdx+8*%s+8]", RCX))
+	ao.CheckIndex(RCX, RDX)
+	ao.Mov(RAX, assemblyoutput.Memory{Base: RDX, Index: RCX, Scale: 8, Displacement: 8})
 
 	if kind.ListElementType == nil {
 		return typesystem.Type{}, fmt.Errorf("listelementtype is nil")
 	}
+	mm.Typed(expr.Position, *kind.ListElementType)
 
 	return *kind.ListElementType, nil
 }
@@ -236,22 +306,24 @@ func (expr StructExp) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymode
 		return typesystem.NewInvalid(), fmt.Errorf("struct type does not exist")
 	}
 	_type := typesystem.Type{
-		RawType:               typesystem.Struct,
-		StructName:            expr.Name,
+		RawType:    typesystem.Struct,
+		StructName: expr.Name,
 	}
-	ao.Mov(RDI, fmt.Sprintf("%d", 8*len(expr.Members)))
-	ao.Call("malloc")
+	ao.Mov(RDI, assemblyoutput.Immediate(8*len(expr.Members)))
+	ao.CallMalloc()
 	ao.Mov(RDX, RAX)
 	i := 0
 	for _, member := range expr.Members {
+		if i >= len(typeFromMemoryModel.StructMembers) {
+			return typesystem.NewInvalid(), fmt.Errorf("mismatching number of arguments in field declaration")
+		}
 		actual := typeFromMemoryModel.StructMembers[i]
 		if actual.Name != member.Name {
 			return typesystem.NewInvalid(), fmt.Errorf("invalid struct field name")
 		}
-		mm.CurrentStackSize++
+		mm.Member(member.Position, expr.Name, member.Name, false)
 		ao.Push(RDX)
 		kind, err := member.Exp.Generate(ao, mm)
-		mm.CurrentStackSize--
 		ao.Pop(RDX)
 		if !kind.Equals(actual.Type) {
 			return typesystem.NewInvalid(), fmt.Errorf("invalid field type")
@@ -259,7 +331,7 @@ func (expr StructExp) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymode
 		if err != nil {
 			return typesystem.NewInvalid(), fmt.Errorf("expression in struct init: %w", err)
 		}
-		ao.Mov(fmt.Sprintf("qword [%s+%d]", RDX, i*8), RAX)
+		ao.Mov(assemblyoutput.Memory{Size: "qword", Base: RDX, Displacement: i * 8}, RAX)
 		_type.StructMembers = append(_type.StructMembers, typesystem.NamedType{
 			Name: member.Name,
 			Type: kind,
@@ -285,11 +357,16 @@ func (expr ExpReadFromStruct) Generate(ao *assemblyoutput.AssemblyOutput, mm *me
 	if kind.RawType != typesystem.Struct {
 		return typesystem.NewInvalid(), fmt.Errorf("can only read from structs")
 	}
+	if declared, ok := mm.GetStructType(kind.StructName); ok {
+		kind = declared
+	}
 
 	i := 0
 	for _, member := range kind.StructMembers {
 		if member.Name == expr.Field {
-			ao.Mov(RAX, fmt.Sprintf("[%s+%d]", RDX, i*8))
+			mm.Typed(expr.Position, member.Type)
+			mm.Member(expr.Position, kind.StructName, member.Name, false)
+			ao.Mov(RAX, assemblyoutput.Memory{Base: RDX, Displacement: i * 8})
 			return member.Type, nil
 		}
 		i++
@@ -306,6 +383,6 @@ func (expr ExpLength) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymode
 	if kind.RawType != typesystem.List {
 		return typesystem.Type{}, fmt.Errorf("can only get len of lists")
 	}
-	ao.Mov(RAX, fmt.Sprintf("[%s]", RAX))
+	ao.Mov(RAX, assemblyoutput.Memory{Base: RAX})
 	return typesystem.NewInt(), nil
 }
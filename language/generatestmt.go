@@ -8,20 +8,79 @@ import (
 )
 
 const (
-	RAX                = assemblyoutput.RAX
-	RBX                = assemblyoutput.RBX
-	RDI                = assemblyoutput.RDI
-	RSI                = assemblyoutput.RSI
-	RDX                = assemblyoutput.RDX
-	RCX                = assemblyoutput.RCX
+	RAX = assemblyoutput.RAX
+	RBX = assemblyoutput.RBX
+	RDI = assemblyoutput.RDI
+	RSI = assemblyoutput.RSI
+	RDX = assemblyoutput.RDX
+	RCX = assemblyoutput.RCX
 )
 
+// GenerateProgram generates main with the program as its body. main has a
+// frame like any other function, since it is called from C. It also checks
+// the types of the program, so the other backends take programs that it has
+// accepted, and only track types without checking them again.
+func GenerateProgram(stmt Stmt, ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	ao.Start()
+	enterFrame(ao, mm)
+	mm.AllocateRegisters(programIntervals(stmt))
+	err := stmt.Generate(ao, mm)
+	ao.End(mm.FrameSize())
+	return err
+}
+
+// Check type checks the program, and returns the type that the expression
+// would have at the end of it, or void if there is no expression. The
+// generated code is thrown away.
+func Check(program Stmt, exp Exp) (typesystem.Type, error) {
+	ao := assemblyoutput.NewAssemblyOutput()
+	mm := memorymodel.NewMemoryModel()
+	ao.Start()
+	enterFrame(ao, mm)
+	err := program.Generate(ao, mm)
+	if err != nil || exp == nil {
+		return typesystem.Type{RawType: typesystem.Void}, err
+	}
+	return exp.Generate(ao, mm)
+}
+
+// declarations are the functions and externs declared in the sequence. They
+// are declared before the other statements of the sequence, so that functions
+// can call each other and the functions declared after them.
+func (stmt StmtSeq) declarations() []Stmt {
+	var declarations []Stmt
+	for _, statement := range stmt.Statements {
+		switch statement.(type) {
+		case StmtFunctionDeclaration, StmtExtern:
+			declarations = append(declarations, statement)
+		}
+	}
+	return declarations
+}
+
 func (stmt StmtSeq) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	for _, statement := range stmt.declarations() {
+		switch declaration := statement.(type) {
+		case StmtFunctionDeclaration:
+			err := declaration.declare(mm)
+			if err != nil {
+				return fmt.Errorf("function declaration: %w", err)
+			}
+		case StmtExtern:
+			err := declaration.declare(mm)
+			if err != nil {
+				return fmt.Errorf("extern declaration: %w", err)
+			}
+		}
+	}
 	for i := range stmt.Statements {
+		mm.Scope(positionAt(stmt.Positions, i), stmt.End)
 		err := stmt.Statements[i].Generate(ao, mm)
 		if err != nil {
-			return fmt.Errorf("statement in sequence: %w", err)
+			return fmt.Errorf("statement in sequence: %w", stmt.errorAt(i, err))
 		}
+		// What the statement declared is visible after it
+		mm.Scope(positionAt(stmt.Positions, i), stmt.End)
 	}
 	return nil
 }
@@ -31,22 +90,100 @@ func (stmt StmtAssign) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymod
 	if err != nil {
 		return fmt.Errorf("expression in assign: %w", err)
 	}
-	if stmt.Identifier == "_" {
+	if len(stmt.Identifiers) > 0 {
+		return destructure(ao, mm, stmt.Identifiers, stmt.Positions, kind, stmt.Constant)
+	}
+	if stmt.Type != nil {
+		if !stmt.Type.Equals(kind) {
+			return fmt.Errorf("%s is annotated as %s, but the expression has type %s", stmt.Identifier, stmt.Type, kind)
+		}
+		// The annotation is more specific than the type of none
+		if kind.RawType == typesystem.Option && kind.OptionElementType == nil {
+			kind = *stmt.Type
+		}
+	}
+	return assign(ao, mm, stmt.Identifier, positionAt(stmt.Positions, 0), kind, stmt.Constant)
+}
+
+// assign stores rax in the variable with the given identifier, declaring a
+// new variable if the identifier is not in the current context. Constants
+// declared outside of functions are stored as globals instead, so that
+// function bodies can read them. The position is where the identifier is.
+func assign(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, identifier string, position Position, kind typesystem.Type, constant bool) error {
+	if identifier == "_" {
 		return nil
 	}
-	if kind.IsStorableOnStack() {
-		if mm.Contains(stmt.Identifier) {
-			member := mm.GetStackElement(stmt.Identifier)
+	if !kind.IsStorableOnStack() {
+		return fmt.Errorf("expression in assign not storable on stack")
+	}
+	if mm.Contains(identifier) {
+		member := mm.GetStackElement(identifier)
+		mm.Used(position, member)
+		if member.Constant {
+			return fmt.Errorf("can not assign to constant %s", identifier)
+		}
+		if constant {
+			return fmt.Errorf("can not redeclare %s as a constant", identifier)
+		}
+		if !member.Type.Equals(kind) {
+			return fmt.Errorf("can not assign %s to %s of type %s", kind, identifier, member.Type)
+		}
+		if member.Type.RawType == typesystem.Option && member.Type.OptionElementType == nil {
 			member.Type = kind
-			ao.Mov(fmt.Sprintf("[rsp+%d]", (mm.CurrentStackSize-member.StackSizeAfterPush)*8), RAX)
-		} else {
-			mm.CurrentStackSize++
-			ao.Push(RAX)
-			mm.AddNameToCurrentStackElement(stmt.Identifier, kind)
 		}
+		if member.Register != "" {
+			ao.Mov(assemblyoutput.Register(member.Register), RAX)
+			return nil
+		}
+		ao.Mov(assemblyoutput.FrameAddress(member.Offset), RAX)
+		return nil
+	}
+	if constant && ao.CurrentProcedure() == nil {
+		label := ao.GenerateUniqueName()
+		ao.NewGlobal(label)
+		ao.Mov(assemblyoutput.Memory{Label: assemblyoutput.Label(label)}, RAX)
+		mm.AddGlobalConstant(identifier, kind, label)
+		mm.Declared(position, identifier)
 		return nil
 	}
-	return fmt.Errorf("expression in assign not storable on stack")
+	declare(ao, mm, identifier, kind, constant, RAX)
+	mm.Declared(position, identifier)
+	return nil
+}
+
+// declare stores the value of a register in a new variable in the current
+// context, which is kept in the register that was allocated for it, or in the
+// frame if it was spilled
+func declare(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, identifier string, kind typesystem.Type, constant bool, value assemblyoutput.Register) {
+	if register, ok := mm.AllocateRegister(identifier); ok {
+		ao.Mov(assemblyoutput.Register(register), value)
+		mm.AddRegisterToCurrentContext(identifier, kind, register, constant)
+		return
+	}
+	offset := mm.AddLocal(identifier, kind, constant)
+	ao.Mov(assemblyoutput.FrameAddress(offset), value)
+}
+
+// destructure assigns each element of the tuple in rax to the identifiers.
+// The tuple is kept on top of the stack while the elements are assigned.
+func destructure(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, identifiers []string, positions []Position, kind typesystem.Type, constant bool) error {
+	if kind.RawType != typesystem.Tuple {
+		return fmt.Errorf("can only destructure tuples")
+	}
+	if len(kind.TupleElementTypes) != len(identifiers) {
+		return fmt.Errorf("expected %d identifiers when destructuring tuple, got %d", len(kind.TupleElementTypes), len(identifiers))
+	}
+	ao.Push(RAX)
+	for i, identifier := range identifiers {
+		ao.Mov(RBX, assemblyoutput.StackAddress(0))
+		ao.Mov(RAX, assemblyoutput.Memory{Base: RBX, Displacement: i * 8})
+		err := assign(ao, mm, identifier, positionAt(positions, i), kind.TupleElementTypes[i], constant)
+		if err != nil {
+			return fmt.Errorf("destructure %s: %w", identifier, err)
+		}
+	}
+	ao.Pop(RBX)
+	return nil
 }
 
 func (stmt StmtPrintln) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
@@ -67,7 +204,7 @@ func (stmt StmtPrintln) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymo
 	if kind.RawType == typesystem.List && kind.ListElementType.RawType == typesystem.Char {
 		ao.Mov(RBX, assemblyoutput.CharFormat)
 		ao.Call(assemblyoutput.PrintListWithFormat)
-		ao.Mov(RAX, "10")
+		ao.Mov(RAX, assemblyoutput.Immediate(10))
 		ao.Mov(RBX, assemblyoutput.CharFormat)
 		ao.Call(assemblyoutput.PrintRegisterWithFormat)
 		return nil
@@ -76,6 +213,9 @@ func (stmt StmtPrintln) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymo
 }
 
 func (stmt StmtReturn) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	if call, ok := stmt.Expression.(FunctionCall); ok && isSelfCall(ao, mm, call) {
+		return generateTailCall(ao, mm, call)
+	}
 	kind, err := stmt.Expression.Generate(ao, mm)
 	if err != nil {
 		return fmt.Errorf("return expression: %w", err)
@@ -84,9 +224,10 @@ func (stmt StmtReturn) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymod
 	if procedure == nil {
 		return fmt.Errorf("returns are only allowed inside functions")
 	}
-	for i := 0; i < mm.CurrentStackSize-procedure.StackSizeBeforeFunctionGeneration-1-procedure.NumberOfArgs; i++ {
-		ao.Pop(RBX)
+	if returnType, ok := mm.GetReturnType(); ok && !returnType.Equals(kind) {
+		return fmt.Errorf("returned value does not match the return type of the function")
 	}
+	ao.LeaveFrame()
 	ao.Ret()
 	if !kind.IsPassable() {
 		return fmt.Errorf("return type is not passable")
@@ -94,9 +235,62 @@ func (stmt StmtReturn) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymod
 	return nil
 }
 
+// isSelfCall is true if the call is to the procedure that is currently being generated
+func isSelfCall(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, call FunctionCall) bool {
+	procedure := ao.CurrentProcedure()
+	identifier, ok := call.Exp.(ExpIdentifier)
+	if procedure == nil || !ok {
+		return false
+	}
+	element := mm.GetStackElement(identifier.Name)
+	return element != nil && element.Procedure == procedure.Name
+}
+
+// generateTailCall overwrites the arguments of the current procedure with the
+// arguments of the call, and jumps back to the start of the procedure instead
+// of calling it, so that the stack does not grow
+func generateTailCall(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, call FunctionCall) error {
+	procedure := ao.CurrentProcedure()
+	kind := mm.GetStackElement(call.Exp.(ExpIdentifier).Name).Type
+	if len(kind.FunctionArgumentTypes) != len(call.Arguments) {
+		return fmt.Errorf("mismathcing number of arguments in call")
+	}
+	if returnType, ok := mm.GetReturnType(); ok && !returnType.Equals(*kind.FunctionReturnType) {
+		return fmt.Errorf("returned value does not match the return type of the function")
+	}
+
+	// All arguments are evaluated before any are overwritten, since they may
+	// depend on the current arguments
+	for i, argument := range call.Arguments {
+		argKind, err := argument.Generate(ao, mm)
+		if err != nil {
+			return fmt.Errorf("argument in call: %w", err)
+		}
+		if !argKind.IsPassable() {
+			return fmt.Errorf("argument type must be passable")
+		}
+		if !kind.FunctionArgumentTypes[i].Type.Equals(argKind) {
+			return fmt.Errorf("mismatching argument types in call")
+		}
+		ao.Push(RAX)
+	}
+	// The arguments in registers are stored again when the procedure starts over
+	for i := len(call.Arguments) - 1; i >= 0; i-- {
+		if i < len(assemblyoutput.ArgumentRegisters) {
+			ao.Pop(assemblyoutput.ArgumentRegisters[i])
+			continue
+		}
+		ao.Pop(RAX)
+		ao.Mov(assemblyoutput.FrameAddress(assemblyoutput.StackArgumentOffset(i)), RAX)
+	}
+
+	ao.LeaveFrame()
+	ao.Jmp(procedure.Name)
+	return nil
+}
+
 func (stmt StmtIf) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
 	mm.PushNewContext(true)
-	initStacksize := mm.CurrentStackSize
 	kind, err := stmt.Expression.Generate(ao, mm)
 	if err != nil {
 		return fmt.Errorf("if condition: %w", err)
@@ -106,7 +300,7 @@ func (stmt StmtIf) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.M
 	}
 	bodyStart := ao.GenerateUniqueName()
 	bodyEnd := ao.GenerateUniqueName()
-	ao.Cmp(RAX, "1")
+	ao.Cmp(RAX, assemblyoutput.Immediate(1))
 	ao.Je(bodyStart)
 	ao.Jne(bodyEnd)
 	ao.NewSection(bodyStart)
@@ -114,10 +308,6 @@ func (stmt StmtIf) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.M
 	if err != nil {
 		return fmt.Errorf("if body: %w", err)
 	}
-	for i := 0; i < mm.CurrentStackSize-initStacksize; i++ {
-		mm.CurrentStackSize--
-		ao.Pop(RBX)
-	}
 	ao.NewSection(bodyEnd)
 	mm.PopCurrentContext()
 	return nil
@@ -125,7 +315,6 @@ func (stmt StmtIf) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.M
 
 func (stmt StmtLoop) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
 	mm.PushNewContext(true)
-	initStackSize := mm.CurrentStackSize
 	bodyStart := ao.GenerateUniqueName()
 	conditionStart := ao.GenerateUniqueName()
 	ao.Jmp(conditionStart)
@@ -134,10 +323,6 @@ func (stmt StmtLoop) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel
 	if err != nil {
 		return fmt.Errorf("loop body: %w", err)
 	}
-	for i := 0; i < mm.CurrentStackSize-initStackSize; i++ {
-		ao.Pop(RBX)
-	}
-	mm.CurrentStackSize = initStackSize
 	ao.NewSection(conditionStart)
 	kind, err := stmt.Condition.Generate(ao, mm)
 	if err != nil {
@@ -146,14 +331,293 @@ func (stmt StmtLoop) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel
 	if kind.RawType != typesystem.Bool {
 		return fmt.Errorf("loop condition is not bool")
 	}
-	ao.Cmp(RAX, "1")
+	ao.Cmp(RAX, assemblyoutput.Immediate(1))
 	ao.Je(bodyStart)
 	mm.PopCurrentContext()
 	return nil
 }
 
+func (stmt StmtBlock) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	mm.PushNewContext(true)
+	err := stmt.Body.Generate(ao, mm)
+	if err != nil {
+		return fmt.Errorf("block: %w", err)
+	}
+	mm.PopCurrentContext()
+	return nil
+}
+
+func (stmt StmtUnreachable) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	snapshot := ao.Snapshot()
+	mm.PushNewContext(true)
+	err := stmt.Body.Generate(ao, mm)
+	if err != nil {
+		return fmt.Errorf("unreachable body: %w", err)
+	}
+	mm.PopCurrentContext()
+	ao.Restore(snapshot)
+	return nil
+}
+
+// unknownOption is the error for unwrapping an option that has only been
+// assigned none, so the type of its value is not known
+func unknownOption(exp Exp) error {
+	if identifier, ok := exp.(ExpIdentifier); ok {
+		return fmt.Errorf("the type of %s is not known, since it has only been assigned none. Declare it with a type, like %s: option<int> = none", identifier.Name, identifier.Name)
+	}
+	return fmt.Errorf("the type of none is not known. Assign it to a variable with a type, like x: option<int> = none")
+}
+
+func (stmt StmtIfSome) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	mm.PushNewContext(true)
+	kind, err := stmt.Expression.Generate(ao, mm)
+	if err != nil {
+		return fmt.Errorf("if some expression: %w", err)
+	}
+	if kind.RawType != typesystem.Option {
+		return fmt.Errorf("if some expression is not an option")
+	}
+	if kind.OptionElementType == nil {
+		return unknownOption(stmt.Expression)
+	}
+	bodyEnd := ao.GenerateUniqueName()
+	ao.Cmp(RAX, assemblyoutput.Immediate(0))
+	ao.Je(bodyEnd)
+	unwrap(ao, *kind.OptionElementType)
+	declare(ao, mm, stmt.Identifier, *kind.OptionElementType, false, RAX)
+	mm.Declared(stmt.Position, stmt.Identifier)
+	err = stmt.Body.Generate(ao, mm)
+	if err != nil {
+		return fmt.Errorf("if some body: %w", err)
+	}
+	ao.NewSection(bodyEnd)
+	mm.PopCurrentContext()
+	return nil
+}
+
+func (stmt StmtLoopSome) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	mm.PushNewContext(true)
+	conditionStart := ao.GenerateUniqueName()
+	loopEnd := ao.GenerateUniqueName()
+	ao.NewSection(conditionStart)
+	kind, err := stmt.Expression.Generate(ao, mm)
+	if err != nil {
+		return fmt.Errorf("loop some expression: %w", err)
+	}
+	if kind.RawType != typesystem.Option {
+		return fmt.Errorf("loop some expression is not an option")
+	}
+	if kind.OptionElementType == nil {
+		return unknownOption(stmt.Expression)
+	}
+	ao.Cmp(RAX, assemblyoutput.Immediate(0))
+	ao.Je(loopEnd)
+	unwrap(ao, *kind.OptionElementType)
+	declare(ao, mm, stmt.Identifier, *kind.OptionElementType, false, RAX)
+	mm.Declared(stmt.Position, stmt.Identifier)
+	err = stmt.Body.Generate(ao, mm)
+	if err != nil {
+		return fmt.Errorf("loop some body: %w", err)
+	}
+	ao.Jmp(conditionStart)
+	ao.NewSection(loopEnd)
+	mm.PopCurrentContext()
+	return nil
+}
+
+// unwrap replaces the option in rax with the value it holds. Pointer types are
+// stored as is, while other types are boxed on the heap.
+func unwrap(ao *assemblyoutput.AssemblyOutput, element typesystem.Type) {
+	if !element.IsPointer() {
+		ao.Mov(RAX, assemblyoutput.Memory{Base: RAX})
+	}
+}
+
+func (stmt StmtFunctionDeclaration) declare(mm *memorymodel.MemoryModel) error {
+	if !mm.IsTopLevel() {
+		return fmt.Errorf("function %s must be declared at the top level", stmt.Name)
+	}
+	if mm.Contains(stmt.Name) {
+		return fmt.Errorf("%s is already declared", stmt.Name)
+	}
+	mm.AddProcedure(stmt.Name, stmt.Function.Type, stmt.procedureName())
+	mm.Declared(stmt.Position, stmt.Name)
+	return nil
+}
+
+func (stmt StmtFunctionDeclaration) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	if !mm.IsTopLevel() {
+		return fmt.Errorf("function %s must be declared at the top level", stmt.Name)
+	}
+	if stmt.Exported && assemblyoutput.AssemblerKeyword(stmt.Name) {
+		return fmt.Errorf("exported function %s clashes with a keyword of the assembler", stmt.Name)
+	}
+	if stmt.Exported && assemblyoutput.ReservedSymbol(stmt.Name) {
+		return fmt.Errorf("exported function %s clashes with a symbol of the runtime", stmt.Name)
+	}
+	err := generateProcedure(ao, mm, stmt.Function, stmt.procedureName())
+	if err != nil {
+		return fmt.Errorf("function %s: %w", stmt.Name, err)
+	}
+	if stmt.Exported {
+		err = checkCFunction(stmt.Function.Type)
+		if err != nil {
+			return fmt.Errorf("exported function %s: %w", stmt.Name, err)
+		}
+		ao.AddExport(stmt.Name)
+		generateWrapper(ao, mm, stmt.Name, assemblyoutput.Label(stmt.procedureName()), stmt.Function.Type, false)
+	}
+	return nil
+}
+
+// procedureName is prefixed so that function names never collide with
+// registers, instructions or the procedures of the runtime. Exported functions
+// also get a wrapper with their own name, which is checked against the
+// runtime separately.
+func (stmt StmtFunctionDeclaration) procedureName() string {
+	return "fn_" + stmt.Name
+}
+
+func (stmt StmtExtern) declare(mm *memorymodel.MemoryModel) error {
+	if !mm.IsTopLevel() {
+		return fmt.Errorf("extern %s must be declared at the top level", stmt.Name)
+	}
+	if mm.Contains(stmt.Name) {
+		return fmt.Errorf("%s is already declared", stmt.Name)
+	}
+	err := checkCFunction(stmt.Type)
+	if err != nil {
+		return fmt.Errorf("extern %s: %w", stmt.Name, err)
+	}
+	mm.AddProcedure(stmt.Name, stmt.Type, stmt.procedureName())
+	mm.Declared(stmt.Position, stmt.Name)
+	return nil
+}
+
+// Generate generates a procedure that calls the C function with the calling
+// convention of the language, so that it can be used like any other function
+func (stmt StmtExtern) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	if !mm.IsTopLevel() {
+		return fmt.Errorf("extern %s must be declared at the top level", stmt.Name)
+	}
+	ao.AddExtern(stmt.Name)
+	generateWrapper(ao, mm, stmt.procedureName(), assemblyoutput.Label(stmt.Name), stmt.Type, true)
+	return nil
+}
+
+// procedureName is the procedure that calls the C function
+func (stmt StmtExtern) procedureName() string {
+	return "extern_" + stmt.Name
+}
+
+// generateWrapper generates a procedure that converts its arguments, calls
+// the target with them and converts the returned value. Strings are copied
+// between lists and null terminated strings, and chars and bools only use
+// the lowest byte of a register in C. Calls into C free the copied strings
+// afterwards, while strings returned to C must be freed by the caller.
+func generateWrapper(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel, name string, target assemblyoutput.Label, kind typesystem.Type, intoC bool) {
+	mm.PushNewContext(false)
+	ao.PushProcedure(name)
+	enterFrame(ao, mm)
+
+	arguments := kind.FunctionArgumentTypes
+	offsets := make([]int, len(arguments))
+	for i, argument := range arguments {
+		offsets[i] = mm.AddLocal(argument.Name, argument.Type, false)
+		if i < len(assemblyoutput.ArgumentRegisters) {
+			ao.Mov(assemblyoutput.FrameAddress(offsets[i]), assemblyoutput.ArgumentRegisters[i])
+		} else {
+			ao.Mov(RAX, assemblyoutput.FrameAddress(assemblyoutput.StackArgumentOffset(i)))
+			ao.Mov(assemblyoutput.FrameAddress(offsets[i]), RAX)
+		}
+	}
+	for i, argument := range arguments {
+		ao.Mov(RAX, assemblyoutput.FrameAddress(offsets[i]))
+		convert(ao, argument.Type, intoC)
+		ao.Mov(assemblyoutput.FrameAddress(offsets[i]), RAX)
+	}
+
+	ao.Mov(RAX, target)
+	ao.Push(RAX)
+	for i := range arguments {
+		ao.Push(assemblyoutput.Memory{Size: "qword", Base: assemblyoutput.RBP, Displacement: offsets[i]})
+	}
+	ao.CallFunction(len(arguments))
+
+	result := mm.AddLocal("", *kind.FunctionReturnType, false)
+	ao.Mov(assemblyoutput.FrameAddress(result), RAX)
+	if intoC {
+		for i, argument := range arguments {
+			if argument.Type.RawType == typesystem.List {
+				ao.Mov(RDI, assemblyoutput.FrameAddress(offsets[i]))
+				ao.CallFree()
+			}
+		}
+	}
+	ao.Mov(RAX, assemblyoutput.FrameAddress(result))
+	convert(ao, *kind.FunctionReturnType, !intoC)
+
+	ao.SetFrameSize(mm.FrameSize())
+	ao.LeaveFrame()
+	ao.Ret()
+	mm.PopCurrentContext()
+	ao.PopProcedure()
+}
+
+// convert converts the value in rax to C, or from C
+func convert(ao *assemblyoutput.AssemblyOutput, kind typesystem.Type, intoC bool) {
+	switch kind.RawType {
+	case typesystem.Char, typesystem.Bool:
+		if !intoC {
+			ao.And(RAX, assemblyoutput.Immediate(255))
+		}
+	case typesystem.List:
+		if intoC {
+			ao.Call(assemblyoutput.ListToCString)
+		} else {
+			ao.Call(assemblyoutput.CStringToList)
+		}
+	}
+}
+
+// checkCFunction checks that all arguments and the return value of a
+// function can be passed between C and the language
+func checkCFunction(kind typesystem.Type) error {
+	for _, argument := range kind.FunctionArgumentTypes {
+		if !isCType(argument.Type) {
+			return fmt.Errorf("argument %s can not be passed to or from C", argument.Name)
+		}
+	}
+	returnType := *kind.FunctionReturnType
+	if returnType.RawType != typesystem.Void && !isCType(returnType) {
+		return fmt.Errorf("the return type can not be passed to or from C")
+	}
+	return nil
+}
+
+// isCType is true for the types that have a C equivalent. Ints are longs,
+// chars and bools are a single byte and strings are null terminated.
+func isCType(kind typesystem.Type) bool {
+	switch kind.RawType {
+	case typesystem.Int, typesystem.Char, typesystem.Bool:
+		return true
+	case typesystem.List:
+		return kind.ListElementType.RawType == typesystem.Char
+	}
+	return false
+}
+
 func (stmt StmtStructDeclaration) Generate(ao *assemblyoutput.AssemblyOutput, mm *memorymodel.MemoryModel) error {
+	for _, member := range stmt.Type.StructMembers {
+		if member.Type.RawType == typesystem.Struct && member.Type.StructName == stmt.Type.StructName {
+			return fmt.Errorf("struct %s can not contain itself, use option<@%s>", stmt.Type.StructName, stmt.Type.StructName)
+		}
+	}
 	mm.NewStructType(stmt.Type.StructName, stmt.Type)
+	for i, member := range stmt.Type.StructMembers {
+		mm.Member(positionAt(stmt.Positions, i), stmt.Type.StructName, member.Name, true)
+		mm.Typed(positionAt(stmt.Positions, i), member.Type)
+	}
 	return nil
 }
 
@@ -165,7 +629,6 @@ func (stmt StmtUpdateList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memor
 	if listKind.RawType != typesystem.List {
 		return fmt.Errorf("expected list kind")
 	}
-	mm.CurrentStackSize++
 	ao.Push(RAX)
 
 	newValueKind, err := stmt.NewValue.Generate(ao, mm)
@@ -175,7 +638,6 @@ func (stmt StmtUpdateList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memor
 	if !newValueKind.Equals(*listKind.ListElementType) {
 		return fmt.Errorf("new value type does not match list element type")
 	}
-	mm.CurrentStackSize++
 	ao.Push(RAX)
 
 	indexKind, err := stmt.Index.Generate(ao, mm)
@@ -185,15 +647,14 @@ func (stmt StmtUpdateList) Generate(ao *assemblyoutput.AssemblyOutput, mm *memor
 	if indexKind.RawType != typesystem.Int {
 		return fmt.Errorf("index in update stmt was not int")
 	}
-	mm.CurrentStackSize++
 	ao.Push(RAX)
 
-	mm.CurrentStackSize -= 3
 	ao.Pop(RAX)
 	ao.Pop(RBX)
 	ao.Pop(RCX)
 
-	ao.Mov(fmt.Sprintf("qword [%s*8+%s+8]", RAX, RCX), RBX)
+	ao.CheckIndex(RAX, RCX)
+	ao.Mov(assemblyoutput.Memory{Size: "qword", Base: RCX, Index: RAX, Scale: 8, Displacement: 8}, RBX)
 	return nil
 }
 
@@ -205,20 +666,23 @@ func (stmt StmtUpdateStruct) Generate(ao *assemblyoutput.AssemblyOutput, mm *mem
 	if structKind.RawType != typesystem.Struct {
 		return fmt.Errorf("expected struct kind")
 	}
-	mm.CurrentStackSize++
+	if declared, ok := mm.GetStructType(structKind.StructName); ok {
+		structKind = declared
+	}
 	ao.Push(RAX)
 
 	newValueKind, err := stmt.NewValue.Generate(ao, mm)
 	if err != nil {
 		return fmt.Errorf("failed to generate new value in update stmt")
 	}
-	mm.CurrentStackSize++
 	ao.Push(RAX)
 
 	i := 0
 	found := false
 	for _, field := range structKind.StructMembers {
 		if field.Name == stmt.Member {
+			mm.Typed(stmt.Position, field.Type)
+			mm.Member(stmt.Position, structKind.StructName, field.Name, false)
 			if !newValueKind.Equals(field.Type) {
 				return fmt.Errorf("wrong type in update struct stmt")
 			}
@@ -232,12 +696,10 @@ func (stmt StmtUpdateStruct) Generate(ao *assemblyoutput.AssemblyOutput, mm *mem
 		return fmt.Errorf("%s is not a member of this struct", stmt.Member)
 	}
 
-	mm.CurrentStackSize -= 2
 	ao.Pop(RAX)
 	ao.Pop(RBX)
 
-	ao.Mov(fmt.Sprintf("[%s+%d]", RBX, i*8), RAX)
+	ao.Mov(assemblyoutput.Memory{Base: RBX, Displacement: i * 8}, RAX)
 
 	return nil
 }
-
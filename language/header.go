@@ -0,0 +1,74 @@
+package language
+
+import (
+	"callmemaybe/language/typesystem"
+	"fmt"
+	"strings"
+)
+
+// Header generates a C header that declares the exported functions of the
+// program. Ints are longs, and strings returned to C must be freed by the
+// caller.
+func Header(stmt Stmt, name string) (string, error) {
+	guard := strings.ToUpper(name) + "_H"
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("#ifndef %s\n#define %s\n\n#include <stdbool.h>\n\n", guard, guard))
+	seq, _ := stmt.(StmtSeq)
+	for _, statement := range seq.Statements {
+		declaration, ok := statement.(StmtFunctionDeclaration)
+		if !ok || !declaration.Exported {
+			continue
+		}
+		kind := declaration.Function.Type
+		var arguments []string
+		for _, argument := range kind.FunctionArgumentTypes {
+			argumentType, err := cType(argument.Type, true)
+			if err != nil {
+				return "", fmt.Errorf("exported function %s: %w", declaration.Name, err)
+			}
+			arguments = append(arguments, cDeclaration(argumentType, argument.Name))
+		}
+		if len(arguments) == 0 {
+			arguments = []string{"void"}
+		}
+		returnType := "void"
+		if kind.FunctionReturnType.RawType != typesystem.Void {
+			var err error
+			returnType, err = cType(*kind.FunctionReturnType, false)
+			if err != nil {
+				return "", fmt.Errorf("exported function %s: %w", declaration.Name, err)
+			}
+		}
+		builder.WriteString(fmt.Sprintf("%s(%s);\n", cDeclaration(returnType, declaration.Name), strings.Join(arguments, ", ")))
+	}
+	builder.WriteString("\n#endif\n")
+	return builder.String(), nil
+}
+
+// cDeclaration formats a C declaration, with pointer stars next to the name
+func cDeclaration(cType string, name string) string {
+	if strings.HasSuffix(cType, "*") {
+		return cType + name
+	}
+	return cType + " " + name
+}
+
+// cType is the C type that a value is passed as. Strings are only read when
+// they are arguments.
+func cType(kind typesystem.Type, argument bool) (string, error) {
+	if !isCType(kind) {
+		return "", fmt.Errorf("%s can not be passed to or from C", kind)
+	}
+	switch kind.RawType {
+	case typesystem.Int:
+		return "long", nil
+	case typesystem.Char:
+		return "char", nil
+	case typesystem.Bool:
+		return "bool", nil
+	}
+	if argument {
+		return "const char *", nil
+	}
+	return "char *", nil
+}
@@ -0,0 +1,616 @@
+package language
+
+import (
+	"callmemaybe/language/typesystem"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// value is the value of an expression in the interpreter. Ints are int64,
+// chars are bytes and bools are bools. Lists, structs and functions are
+// pointers, so they are shared like the heap values of the x86 backend.
+// Tuples are slices that are never changed. None is nil, and some is a
+// someValue.
+type value interface{}
+
+type listValue struct {
+	elements []value
+}
+
+// structValue keeps the names of its members in the order they are declared
+type structValue struct {
+	name    string
+	members []string
+	fields  map[string]value
+}
+
+type someValue struct {
+	value value
+}
+
+// functionValue is a function literal, with the scope it was declared in.
+// Externs have no body, and can not be called by the interpreter.
+type functionValue struct {
+	name     string
+	function ExpFunction
+	scope    *scope
+	extern   bool
+}
+
+// variable is the value of a name in a scope. Globals are the constants
+// declared outside of functions, and functions, which are the only
+// variables that function bodies can see from the scopes around them.
+type variable struct {
+	value  value
+	global bool
+}
+
+// scope holds the variables declared in a body. The body of an if or a loop
+// shares the variables of the scopes around it, and the body of a function
+// only shares their globals.
+type scope struct {
+	variables  map[string]*variable
+	parent     *scope
+	function   bool
+	inFunction bool
+}
+
+func newScope(parent *scope, function bool) *scope {
+	s := &scope{variables: make(map[string]*variable), parent: parent, function: function}
+	s.inFunction = function || parent != nil && parent.inFunction
+	return s
+}
+
+// lookup finds the variable with the given name that is visible in the scope
+func (s *scope) lookup(name string) *variable {
+	crossedFunction := false
+	for current := s; current != nil; current = current.parent {
+		if v, ok := current.variables[name]; ok && (!crossedFunction || v.global) {
+			return v
+		}
+		if current.function {
+			crossedFunction = true
+		}
+	}
+	return nil
+}
+
+// returned is the result of a return statement. A call in a return is
+// returned without being made, so the caller can make it without growing
+// the Go stack, like tail calls in the x86 backend.
+type returned struct {
+	value     value
+	tail      *functionValue
+	arguments []value
+}
+
+type interpreter struct {
+	out io.Writer
+}
+
+// Interpret runs the program, and writes what it prints to out. Operations
+// that would crash the compiled program, like dividing by zero, return an
+// error instead.
+func Interpret(stmt Stmt, out io.Writer) error {
+	in := &interpreter{out: out}
+	_, err := in.stmt(stmt, newScope(nil, false))
+	return err
+}
+
+// Session runs statements one at a time, and keeps the variables that they
+// declare between them. Every statement must have been checked together
+// with the statements that came before it.
+type Session struct {
+	in    *interpreter
+	scope *scope
+}
+
+func NewSession(out io.Writer) *Session {
+	return &Session{in: &interpreter{out: out}, scope: newScope(nil, false)}
+}
+
+// Run runs the statement in the scope of the session
+func (session *Session) Run(stmt Stmt) error {
+	_, err := session.in.stmt(stmt, session.scope)
+	return err
+}
+
+// Evaluate evaluates the expression in the scope of the session, and
+// formats its value like it would be written in a program
+func (session *Session) Evaluate(exp Exp, kind typesystem.Type) (string, error) {
+	v, err := session.in.exp(exp, session.scope)
+	if err != nil {
+		return "", err
+	}
+	return formatValue(v, kind), nil
+}
+
+func (in *interpreter) stmt(stmt Stmt, s *scope) (*returned, error) {
+	switch stmt := stmt.(type) {
+	case StmtSeq:
+		for _, statement := range stmt.declarations() {
+			switch declaration := statement.(type) {
+			case StmtFunctionDeclaration:
+				function := &functionValue{name: declaration.Name, function: declaration.Function, scope: s}
+				s.variables[declaration.Name] = &variable{value: function, global: true}
+			case StmtExtern:
+				function := &functionValue{name: declaration.Name, extern: true}
+				s.variables[declaration.Name] = &variable{value: function, global: true}
+			}
+		}
+		for _, statement := range stmt.Statements {
+			result, err := in.stmt(statement, s)
+			if result != nil || err != nil {
+				return result, err
+			}
+		}
+	case StmtAssign:
+		v, err := in.exp(stmt.Expression, s)
+		if err != nil {
+			return nil, err
+		}
+		if len(stmt.Identifiers) > 0 {
+			for i, identifier := range stmt.Identifiers {
+				in.assign(s, identifier, v.([]value)[i], stmt.Constant)
+			}
+			return nil, nil
+		}
+		in.assign(s, stmt.Identifier, v, stmt.Constant)
+	case StmtPrintln:
+		v, err := in.exp(stmt.Expression, s)
+		if err != nil {
+			return nil, err
+		}
+		return nil, in.println(v)
+	case StmtReturn:
+		if call, ok := stmt.Expression.(FunctionCall); ok {
+			function, arguments, err := in.callee(call, s)
+			if err != nil {
+				return nil, err
+			}
+			return &returned{tail: function, arguments: arguments}, nil
+		}
+		v, err := in.exp(stmt.Expression, s)
+		if err != nil {
+			return nil, err
+		}
+		return &returned{value: v}, nil
+	case StmtIf:
+		body := newScope(s, false)
+		condition, err := in.exp(stmt.Expression, body)
+		if err != nil || !condition.(bool) {
+			return nil, err
+		}
+		return in.stmt(stmt.Body, body)
+	case StmtLoop:
+		body := newScope(s, false)
+		for {
+			condition, err := in.exp(stmt.Condition, body)
+			if err != nil || !condition.(bool) {
+				return nil, err
+			}
+			result, err := in.stmt(stmt.Body, body)
+			if result != nil || err != nil {
+				return result, err
+			}
+		}
+	case StmtBlock:
+		return in.stmt(stmt.Body, newScope(s, false))
+	case StmtUnreachable:
+		return nil, nil
+	case StmtIfSome:
+		body := newScope(s, false)
+		option, err := in.exp(stmt.Expression, body)
+		if err != nil || option == nil {
+			return nil, err
+		}
+		body.variables[stmt.Identifier] = &variable{value: option.(someValue).value}
+		return in.stmt(stmt.Body, body)
+	case StmtLoopSome:
+		body := newScope(s, false)
+		for {
+			option, err := in.exp(stmt.Expression, body)
+			if err != nil || option == nil {
+				return nil, err
+			}
+			body.variables[stmt.Identifier] = &variable{value: option.(someValue).value}
+			result, err := in.stmt(stmt.Body, body)
+			if result != nil || err != nil {
+				return result, err
+			}
+		}
+	case StmtFunctionDeclaration, StmtExtern, StmtStructDeclaration:
+		return nil, nil
+	case StmtUpdateList:
+		list, err := in.exp(stmt.List, s)
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := in.exp(stmt.NewValue, s)
+		if err != nil {
+			return nil, err
+		}
+		index, err := in.exp(stmt.Index, s)
+		if err != nil {
+			return nil, err
+		}
+		elements, err := listElements(list, index.(int64))
+		if err != nil {
+			return nil, err
+		}
+		elements[index.(int64)] = newValue
+	case StmtUpdateStruct:
+		structure, err := in.exp(stmt.Struct, s)
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := in.exp(stmt.NewValue, s)
+		if err != nil {
+			return nil, err
+		}
+		if structure == nil {
+			return nil, fmt.Errorf("can not update %s of a struct that does not exist", stmt.Member)
+		}
+		structure.(*structValue).fields[stmt.Member] = newValue
+	default:
+		return nil, fmt.Errorf("the interpreter does not support %T", stmt)
+	}
+	return nil, nil
+}
+
+func (in *interpreter) exp(exp Exp, s *scope) (value, error) {
+	switch exp := exp.(type) {
+	case ExpParentheses:
+		return in.exp(exp.Inside, s)
+	case ExpNum:
+		return int64(exp.Value), nil
+	case ExpChar:
+		return exp.Value[0], nil
+	case ExpBool:
+		return exp.Value, nil
+	case ExpNone:
+		return nil, nil
+	case ExpSome:
+		inside, err := in.exp(exp.Inside, s)
+		if err != nil {
+			return nil, err
+		}
+		return someValue{value: inside}, nil
+	case ExpIdentifier:
+		v := s.lookup(exp.Name)
+		if v == nil {
+			return nil, fmt.Errorf("missing from context: %s", exp.Name)
+		}
+		return v.value, nil
+	case ExpFunction:
+		return &functionValue{name: "lambda", function: exp, scope: s}, nil
+	case FunctionCall:
+		function, arguments, err := in.callee(exp, s)
+		if err != nil {
+			return nil, err
+		}
+		return in.call(function, arguments)
+	case ExpList:
+		list := &listValue{elements: make([]value, exp.Size)}
+		for i := range list.elements {
+			list.elements[i] = zeroValue(*exp.Type.ListElementType)
+		}
+		for i, element := range exp.Elements {
+			v, err := in.exp(element, s)
+			if err != nil {
+				return nil, err
+			}
+			list.elements[i] = v
+		}
+		return list, nil
+	case ExpTuple:
+		var elements []value
+		for _, element := range exp.Elements {
+			v, err := in.exp(element, s)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, v)
+		}
+		return elements, nil
+	case ExpGetFromList:
+		index, err := in.exp(exp.Index, s)
+		if err != nil {
+			return nil, err
+		}
+		list, err := in.exp(exp.List, s)
+		if err != nil {
+			return nil, err
+		}
+		elements, err := listElements(list, index.(int64))
+		if err != nil {
+			return nil, err
+		}
+		return elements[index.(int64)], nil
+	case StructExp:
+		structure := &structValue{name: exp.Name, fields: make(map[string]value)}
+		for _, member := range exp.Members {
+			v, err := in.exp(member.Exp, s)
+			if err != nil {
+				return nil, err
+			}
+			structure.members = append(structure.members, member.Name)
+			structure.fields[member.Name] = v
+		}
+		return structure, nil
+	case ExpReadFromStruct:
+		structure, err := in.exp(exp.Struct, s)
+		if err != nil {
+			return nil, err
+		}
+		if structure == nil {
+			return nil, fmt.Errorf("can not read %s from a struct that does not exist", exp.Field)
+		}
+		return structure.(*structValue).fields[exp.Field], nil
+	case ExpLength:
+		list, err := in.exp(exp.List, s)
+		if err != nil {
+			return nil, err
+		}
+		if list == nil {
+			return nil, fmt.Errorf("can not get the length of a list that does not exist")
+		}
+		return int64(len(list.(*listValue).elements)), nil
+	case ExpNegative:
+		inside, err := in.exp(exp.Inside, s)
+		if err != nil {
+			return nil, err
+		}
+		return -inside.(int64), nil
+	case ExpPlus:
+		return in.arithmetic(exp, s, func(left, right int64) int64 { return left + right })
+	case ExpMinus:
+		return in.arithmetic(exp, s, func(left, right int64) int64 { return left - right })
+	case ExpMultiply:
+		return in.arithmetic(exp, s, func(left, right int64) int64 { return left * right })
+	case ExpDivide:
+		return in.division(exp, s, func(left, right uint64) uint64 { return left / right })
+	case ExpModulo:
+		return in.division(exp, s, func(left, right uint64) uint64 { return left % right })
+	case ExpLess:
+		return in.comparison(exp, s, func(left, right int64) bool { return left < right })
+	case ExpGreater:
+		return in.comparison(exp, s, func(left, right int64) bool { return left > right })
+	case ExpEquals:
+		return in.comparison(exp, s, func(left, right int64) bool { return left == right })
+	case ExpNotEquals:
+		return in.comparison(exp, s, func(left, right int64) bool { return left != right })
+	}
+	return nil, fmt.Errorf("the interpreter does not support %T", exp)
+}
+
+// operands evaluates the left and the right side of an operation, in that order
+func (in *interpreter) operands(exp ExpBop, s *scope) (value, value, error) {
+	left, err := in.exp(exp.LeftExp(), s)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := in.exp(exp.RightExp(), s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+func (in *interpreter) arithmetic(exp ExpBop, s *scope, operation func(int64, int64) int64) (value, error) {
+	left, right, err := in.operands(exp, s)
+	if err != nil {
+		return nil, err
+	}
+	return operation(left.(int64), right.(int64)), nil
+}
+
+// division divides unsigned numbers, like div does in the x86 backend
+func (in *interpreter) division(exp ExpBop, s *scope, operation func(uint64, uint64) uint64) (value, error) {
+	left, right, err := in.operands(exp, s)
+	if err != nil {
+		return nil, err
+	}
+	if right.(int64) == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return int64(operation(uint64(left.(int64)), uint64(right.(int64)))), nil
+}
+
+func (in *interpreter) comparison(exp ExpBop, s *scope, operation func(int64, int64) bool) (value, error) {
+	left, right, err := in.operands(exp, s)
+	if err != nil {
+		return nil, err
+	}
+	return operation(comparable(left), comparable(right)), nil
+}
+
+// callee evaluates the function and then the arguments of a call
+func (in *interpreter) callee(call FunctionCall, s *scope) (*functionValue, []value, error) {
+	function, err := in.exp(call.Exp, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	var arguments []value
+	for _, argument := range call.Arguments {
+		v, err := in.exp(argument, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		arguments = append(arguments, v)
+	}
+	if function == nil {
+		return nil, nil, fmt.Errorf("can not call a function that does not exist")
+	}
+	return function.(*functionValue), arguments, nil
+}
+
+// call runs the body of the function, and then the functions that it
+// returns calls to
+func (in *interpreter) call(function *functionValue, arguments []value) (value, error) {
+	for {
+		if function.extern {
+			return nil, fmt.Errorf("the interpreter can not call the C function %s", function.name)
+		}
+		body := newScope(function.scope, true)
+		for i, argument := range function.function.Type.FunctionArgumentTypes {
+			body.variables[argument.Name] = &variable{value: arguments[i]}
+		}
+		if function.function.Recurse != "" {
+			body.variables[function.function.Recurse] = &variable{value: function, global: true}
+		}
+		result, err := in.stmt(function.function.Body, body)
+		if err != nil || result == nil {
+			return nil, err
+		}
+		if result.tail == nil {
+			return result.value, nil
+		}
+		function, arguments = result.tail, result.arguments
+	}
+}
+
+// assign stores the value in the variable with the given identifier, and
+// declares it in the current scope if no visible variable has the name
+func (in *interpreter) assign(s *scope, identifier string, v value, constant bool) {
+	if identifier == "_" {
+		return
+	}
+	if existing := s.lookup(identifier); existing != nil {
+		existing.value = v
+		return
+	}
+	s.variables[identifier] = &variable{value: v, global: constant && !s.inFunction}
+}
+
+// println prints like printf does in the x86 backend, so ints and bools are
+// printed as their low 32 bits
+func (in *interpreter) println(v value) error {
+	var err error
+	switch v := v.(type) {
+	case int64:
+		_, err = fmt.Fprintf(in.out, "%d\n", int32(v))
+	case bool:
+		_, err = fmt.Fprintf(in.out, "%d\n", comparable(v))
+	case byte:
+		_, err = in.out.Write([]byte{v, '\n'})
+	case *listValue:
+		characters := make([]byte, 0, len(v.elements)+1)
+		for _, element := range v.elements {
+			characters = append(characters, element.(byte))
+		}
+		_, err = in.out.Write(append(characters, '\n'))
+	default:
+		return fmt.Errorf("can not print a list that does not exist")
+	}
+	return err
+}
+
+// listElements returns the elements of a list if the index is inside it
+func listElements(list value, index int64) ([]value, error) {
+	if list == nil {
+		return nil, fmt.Errorf("can not index a list that does not exist")
+	}
+	elements := list.(*listValue).elements
+	if index < 0 || index >= int64(len(elements)) {
+		return nil, fmt.Errorf("index %d is out of range for a list of length %d", index, len(elements))
+	}
+	return elements, nil
+}
+
+// comparable is the number that an int, a char or a bool is stored as
+func comparable(v value) int64 {
+	switch v := v.(type) {
+	case byte:
+		return int64(v)
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	return v.(int64)
+}
+
+// zeroValue is the value of list elements that are not initialized
+func zeroValue(kind typesystem.Type) value {
+	switch kind.RawType {
+	case typesystem.Int:
+		return int64(0)
+	case typesystem.Char:
+		return byte(0)
+	case typesystem.Bool:
+		return false
+	}
+	return nil
+}
+
+// formatValue formats a value of the given type. Struct members have no
+// type, so their values are formatted by what they hold.
+func formatValue(v value, kind typesystem.Type) string {
+	switch v := v.(type) {
+	case int64:
+		return fmt.Sprintf("%d", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case byte:
+		return fmt.Sprintf("%q", v)
+	case someValue:
+		element := typesystem.NewInvalid()
+		if kind.RawType == typesystem.Option && kind.OptionElementType != nil {
+			element = *kind.OptionElementType
+		}
+		return fmt.Sprintf("some(%s)", formatValue(v.value, element))
+	case []value:
+		var elements []string
+		for i, e := range v {
+			element := typesystem.NewInvalid()
+			if kind.RawType == typesystem.Tuple {
+				element = kind.TupleElementTypes[i]
+			}
+			elements = append(elements, formatValue(e, element))
+		}
+		return fmt.Sprintf("(%s)", strings.Join(elements, ", "))
+	case *listValue:
+		element := typesystem.NewInvalid()
+		if kind.RawType == typesystem.List {
+			element = *kind.ListElementType
+		}
+		if isString(v, element) {
+			characters := make([]byte, len(v.elements))
+			for i, e := range v.elements {
+				characters[i] = e.(byte)
+			}
+			return fmt.Sprintf("%q", characters)
+		}
+		var elements []string
+		for _, e := range v.elements {
+			elements = append(elements, formatValue(e, element))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
+	case *structValue:
+		var members []string
+		for _, member := range v.members {
+			members = append(members, fmt.Sprintf("%s: %s", member, formatValue(v.fields[member], typesystem.NewInvalid())))
+		}
+		return fmt.Sprintf("@%s{%s}", v.name, strings.Join(members, ", "))
+	case *functionValue:
+		return fmt.Sprintf("fn %s", v.name)
+	}
+	return "none"
+}
+
+// isString is true for lists of chars. Without a type, a list is a string
+// if it has elements that are all chars.
+func isString(list *listValue, element typesystem.Type) bool {
+	if element.RawType != typesystem.Invalid {
+		return element.RawType == typesystem.Char
+	}
+	for _, e := range list.elements {
+		if _, ok := e.(byte); !ok {
+			return false
+		}
+	}
+	return len(list.elements) > 0
+}
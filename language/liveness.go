@@ -0,0 +1,236 @@
+package language
+
+import (
+	"callmemaybe/language/assemblyoutput"
+	"callmemaybe/language/memorymodel"
+)
+
+// liveness finds the interval of every variable of a function, in the order
+// the variables are declared when the function is generated. Points are
+// numbered in the same order as the code is generated. A variable that is
+// declared outside of a loop and used in it lives until the end of the loop,
+// since the loop may use it again in its next iteration.
+type liveness struct {
+	point     int
+	intervals []memorymodel.Interval
+	// scopes map the names that are visible to their interval, or to -1 for
+	// names that never get a register, like global constants
+	scopes []map[string]int
+	loops  []*loop
+	// main is true for the top level of the program, whose constants are globals
+	main bool
+}
+
+// loop is a loop that is being walked, and the variables declared before it
+// that are used in it
+type loop struct {
+	start int
+	used  map[int]bool
+}
+
+// functionIntervals returns the intervals of the arguments that are passed
+// in registers, and of the variables in the body of the function
+func functionIntervals(exp ExpFunction) []memorymodel.Interval {
+	l := &liveness{}
+	l.push()
+	for i, argument := range exp.Type.FunctionArgumentTypes {
+		if i < len(assemblyoutput.ArgumentRegisters) {
+			l.declare(argument.Name)
+		} else {
+			l.scopes[0][argument.Name] = -1
+		}
+	}
+	l.stmt(exp.Body)
+	return l.intervals
+}
+
+// programIntervals returns the intervals of the variables at the top level
+// of the program
+func programIntervals(stmt Stmt) []memorymodel.Interval {
+	l := &liveness{main: true}
+	l.push()
+	l.stmt(stmt)
+	return l.intervals
+}
+
+func (l *liveness) push() {
+	l.scopes = append(l.scopes, make(map[string]int))
+}
+
+func (l *liveness) pop() {
+	l.scopes = l.scopes[:len(l.scopes)-1]
+}
+
+func (l *liveness) next() int {
+	l.point++
+	return l.point
+}
+
+func (l *liveness) lookup(name string) (int, bool) {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if index, ok := l.scopes[i][name]; ok {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+func (l *liveness) declare(name string) {
+	point := l.next()
+	l.scopes[len(l.scopes)-1][name] = len(l.intervals)
+	l.intervals = append(l.intervals, memorymodel.Interval{Name: name, Start: point, End: point})
+}
+
+// use extends the interval of the variable to the current point, and marks
+// it as used in the loops that started after it was declared
+func (l *liveness) use(name string) {
+	index, ok := l.lookup(name)
+	if !ok || index < 0 {
+		return
+	}
+	interval := &l.intervals[index]
+	interval.End = l.next()
+	for _, loop := range l.loops {
+		if interval.Start < loop.start {
+			loop.used[index] = true
+		}
+	}
+}
+
+// assign declares the identifier, unless it is already visible and the value
+// is stored in the existing variable
+func (l *liveness) assign(name string, constant bool) {
+	if name == "_" {
+		return
+	}
+	if _, ok := l.lookup(name); ok {
+		l.use(name)
+		return
+	}
+	if constant && l.main {
+		l.scopes[len(l.scopes)-1][name] = -1
+		return
+	}
+	l.declare(name)
+}
+
+func (l *liveness) enterLoop() {
+	l.loops = append(l.loops, &loop{start: l.next(), used: make(map[int]bool)})
+}
+
+func (l *liveness) leaveLoop() {
+	end := l.next()
+	loop := l.loops[len(l.loops)-1]
+	l.loops = l.loops[:len(l.loops)-1]
+	for index := range loop.used {
+		if l.intervals[index].End < end {
+			l.intervals[index].End = end
+		}
+	}
+}
+
+func (l *liveness) stmt(stmt Stmt) {
+	switch stmt := stmt.(type) {
+	case StmtSeq:
+		for _, statement := range stmt.Statements {
+			l.stmt(statement)
+		}
+	case StmtAssign:
+		l.exp(stmt.Expression)
+		if len(stmt.Identifiers) > 0 {
+			for _, identifier := range stmt.Identifiers {
+				l.assign(identifier, stmt.Constant)
+			}
+			return
+		}
+		l.assign(stmt.Identifier, stmt.Constant)
+	case StmtPrintln:
+		l.exp(stmt.Expression)
+	case StmtReturn:
+		l.exp(stmt.Expression)
+	case StmtIf:
+		l.push()
+		l.exp(stmt.Expression)
+		l.stmt(stmt.Body)
+		l.pop()
+	case StmtLoop:
+		l.push()
+		l.enterLoop()
+		l.stmt(stmt.Body)
+		l.exp(stmt.Condition)
+		l.leaveLoop()
+		l.pop()
+	case StmtIfSome:
+		l.push()
+		l.exp(stmt.Expression)
+		l.declare(stmt.Identifier)
+		l.stmt(stmt.Body)
+		l.pop()
+	case StmtLoopSome:
+		l.push()
+		l.enterLoop()
+		l.exp(stmt.Expression)
+		l.declare(stmt.Identifier)
+		l.stmt(stmt.Body)
+		l.leaveLoop()
+		l.pop()
+	case StmtBlock:
+		l.push()
+		l.stmt(stmt.Body)
+		l.pop()
+	case StmtUnreachable:
+		l.push()
+		l.stmt(stmt.Body)
+		l.pop()
+	case StmtUpdateList:
+		l.exp(stmt.List)
+		l.exp(stmt.NewValue)
+		l.exp(stmt.Index)
+	case StmtUpdateStruct:
+		l.exp(stmt.Struct)
+		l.exp(stmt.NewValue)
+	}
+}
+
+// exp marks the variables that the expression reads as used. Function
+// expressions are skipped, since they have their own frame and can not read
+// the variables of the function they are declared in.
+func (l *liveness) exp(exp Exp) {
+	switch exp := exp.(type) {
+	case ExpIdentifier:
+		l.use(exp.Name)
+	case ExpBop:
+		l.exp(exp.LeftExp())
+		l.exp(exp.RightExp())
+	case ExpParentheses:
+		l.exp(exp.Inside)
+	case ExpNegative:
+		l.exp(exp.Inside)
+	case ExpSome:
+		l.exp(exp.Inside)
+	case ExpList:
+		l.exps(exp.Elements)
+	case ExpTuple:
+		l.exps(exp.Elements)
+	case ExpGetFromList:
+		l.exp(exp.List)
+		l.exp(exp.Index)
+	case ExpReadFromStruct:
+		l.exp(exp.Struct)
+	case ExpLength:
+		l.exp(exp.List)
+	case FunctionCall:
+		l.exp(exp.Exp)
+		l.exps(exp.Arguments)
+	case StructExp:
+		for _, member := range exp.Members {
+			l.exp(member.Exp)
+		}
+	}
+}
+
+func (l *liveness) exps(exps []Exp) {
+	for _, exp := range exps {
+		l.exp(exp)
+	}
+}
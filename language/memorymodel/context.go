@@ -5,31 +5,60 @@ import (
 )
 
 type Context struct {
-	members map[string]*ContextElement
+	members     map[string]*ContextElement
 	structTypes map[string]typesystem.Type
+	returnType  *typesystem.Type
+	temporaries map[string]bool
+	frame       *Frame
+}
+
+// Frame is the stack frame of a function, or of main. Contexts of the same
+// function share the frame.
+type Frame struct {
+	// Slots is the number of quad words reserved below rbp for variables
+	Slots int
+	// Reserved is the number of slots right below rbp that are not used for variables
+	Reserved int
+	// allocation is where the variables of the function are stored, in the
+	// order they are declared, and declared is how many have been declared
+	allocation []allocation
+	declared   int
+}
+
+// allocation is the register of a variable, or an empty string if it is
+// kept on the stack
+type allocation struct {
+	name     string
+	register string
 }
 
 type ContextElement struct {
-	Type                 typesystem.Type
-	Name                 string
-	StackSizeAfterPush   int
+	Type      typesystem.Type
+	Name      string
+	Offset    int
+	Constant  bool
+	Global    string
+	Procedure string
+	Register  string
 }
 
 func EmptyContext() *Context {
 	return &Context{
-		members: make(map[string]*ContextElement),
+		members:     make(map[string]*ContextElement),
 		structTypes: make(map[string]typesystem.Type),
+		temporaries: make(map[string]bool),
+		frame:       &Frame{},
 	}
 }
 
 func NewContextElement(
 	_type typesystem.Type,
-	stackSizeAfterPush int,
+	offset int,
 	name string,
 ) *ContextElement {
 	return &ContextElement{
-		Type: _type,
-		StackSizeAfterPush:   stackSizeAfterPush,
-		Name:                 name,
+		Type:   _type,
+		Offset: offset,
+		Name:   name,
 	}
 }
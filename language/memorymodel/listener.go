@@ -0,0 +1,91 @@
+package memorymodel
+
+import (
+	"callmemaybe/language/typesystem"
+	"sort"
+)
+
+// Position is a place in the source, where lines and columns start at 1
+type Position struct {
+	Line   int
+	Column int
+}
+
+// IsValid is false for the zero Position, which nodes that were parsed
+// without positions have
+func (position Position) IsValid() bool {
+	return position.Line > 0
+}
+
+// Listener is told how identifiers are resolved while a program is checked,
+// which is what editors need to show types and find declarations. Elements
+// are shared by every context they are visible in, so a declaration and its
+// uses are told about the same element.
+type Listener interface {
+	// Declared is called when a variable, constant, argument or function is declared
+	Declared(position Position, element *ContextElement)
+	// Used is called when an identifier refers to an element
+	Used(position Position, element *ContextElement)
+	// Member is called when the name of a member of a struct is declared or used
+	Member(position Position, structName string, member string, declaration bool)
+	// Typed is called with the type of the expression at the position
+	Typed(position Position, kind typesystem.Type)
+	// Scope is called with the elements and structs that are visible from
+	// start until end, where the most recent scope that starts closest to a
+	// position is the one it is in
+	Scope(start Position, end Position, elements []*ContextElement, structs []typesystem.Type)
+}
+
+// Declared tells the listener about the element that was just declared with the name
+func (mm *MemoryModel) Declared(position Position, name string) {
+	element := mm.GetStackElement(name)
+	if mm.Listener != nil && position.IsValid() && element != nil {
+		mm.Listener.Declared(position, element)
+	}
+}
+
+// Used tells the listener that the identifier at the position refers to the element
+func (mm *MemoryModel) Used(position Position, element *ContextElement) {
+	if mm.Listener != nil && position.IsValid() && element != nil {
+		mm.Listener.Used(position, element)
+	}
+}
+
+// Scope tells the listener what is visible in the current context from start until end
+func (mm *MemoryModel) Scope(start Position, end Position) {
+	if mm.Listener == nil || !start.IsValid() {
+		return
+	}
+	context := mm.ContextStack.Peek()
+	var elements []*ContextElement
+	for name, element := range context.members {
+		if name != "" {
+			elements = append(elements, element)
+		}
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].Name < elements[j].Name
+	})
+	var structs []typesystem.Type
+	for _, kind := range context.structTypes {
+		structs = append(structs, kind)
+	}
+	sort.Slice(structs, func(i, j int) bool {
+		return structs[i].StructName < structs[j].StructName
+	})
+	mm.Listener.Scope(start, end, elements, structs)
+}
+
+// Member tells the listener that the name of a member of the struct is declared or used at the position
+func (mm *MemoryModel) Member(position Position, structName string, member string, declaration bool) {
+	if mm.Listener != nil && position.IsValid() {
+		mm.Listener.Member(position, structName, member, declaration)
+	}
+}
+
+// Typed tells the listener the type of the expression at the position
+func (mm *MemoryModel) Typed(position Position, kind typesystem.Type) {
+	if mm.Listener != nil && position.IsValid() {
+		mm.Listener.Typed(position, kind)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"callmemaybe/language/typesystem"
 )
 
+// MemoryModel keeps track of where every variable is stored. Variables live
+// in the stack frame of their function at a fixed offset from rbp, so pushes
+// and pops in the generated code never change how they are addressed.
 type MemoryModel struct {
-	CurrentStackSize int
-	ContextStack     *ContextStack
+	ContextStack *ContextStack
+	UseRegisters bool
+	// Listener is nil unless an editor wants to know how identifiers are resolved
+	Listener Listener
 }
 
 func NewMemoryModel() *MemoryModel {
 	return &MemoryModel{
-		CurrentStackSize: 0,
-		ContextStack:     NewContextStack(),
+		ContextStack: NewContextStack(),
 	}
 }
 
@@ -23,6 +27,15 @@ func (mm *MemoryModel) PushNewContext(copyCurrentContext bool) {
 		for k, v := range current.members {
 			newContext.members[k] = v
 		}
+		newContext.returnType = current.returnType
+		newContext.frame = current.frame
+	} else {
+		// Globals and procedures do not live on the stack, so they are visible in function bodies
+		for k, v := range current.members {
+			if v.Global != "" || v.Procedure != "" {
+				newContext.members[k] = v
+			}
+		}
 	}
 	for k, v := range current.structTypes {
 		newContext.structTypes[k] = v
@@ -34,15 +47,86 @@ func (mm *MemoryModel) PopCurrentContext() {
 	mm.ContextStack.Pop()
 }
 
-func (mm *MemoryModel) AddNameToCurrentStackElement(name string, _type typesystem.Type) {
+// AddLocal adds a variable in a free slot of the current frame, and returns its offset from rbp.
+// Slots are reused once the context of the variable that had it is popped.
+func (mm *MemoryModel) AddLocal(name string, _type typesystem.Type, constant bool) int {
 	currentContext := mm.ContextStack.Peek()
-	currentContext.members[name] = NewContextElement(_type, mm.CurrentStackSize, name)
+	used := make(map[int]bool)
+	for _, member := range mm.frameMembers() {
+		if member.Offset < 0 {
+			used[member.Offset] = true
+		}
+	}
+	slot := currentContext.frame.Reserved + 1
+	for used[-8*slot] {
+		slot++
+	}
+	if slot > currentContext.frame.Slots {
+		currentContext.frame.Slots = slot
+	}
+	element := NewContextElement(_type, -8*slot, name)
+	element.Constant = constant
+	currentContext.members[name] = element
+	return element.Offset
+}
+
+// ReserveSlots keeps the first slots of the current frame from being used
+// for variables, so the function can save registers there
+func (mm *MemoryModel) ReserveSlots(slots int) {
+	frame := mm.ContextStack.Peek().frame
+	frame.Reserved = slots
+	if slots > frame.Slots {
+		frame.Slots = slots
+	}
+}
+
+// AddArgument adds an argument that the caller pushed at the given offset from rbp
+func (mm *MemoryModel) AddArgument(name string, _type typesystem.Type, offset int) {
+	mm.ContextStack.Peek().members[name] = NewContextElement(_type, offset, name)
+}
+
+// frameMembers returns the members of all contexts in the current frame,
+// including those that are shadowed in the current context
+func (mm *MemoryModel) frameMembers() []*ContextElement {
+	frame := mm.ContextStack.Peek().frame
+	var members []*ContextElement
+	for i := mm.ContextStack.Size() - 1; i >= 0 && mm.ContextStack.stack[i].frame == frame; i-- {
+		for _, member := range mm.ContextStack.stack[i].members {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// FrameSize is the number of slots the current frame needs for its variables
+func (mm *MemoryModel) FrameSize() int {
+	return mm.ContextStack.Peek().frame.Slots
+}
+
+// AddGlobalConstant adds a constant that is stored at the given label instead of on the stack
+func (mm *MemoryModel) AddGlobalConstant(name string, _type typesystem.Type, label string) {
+	element := NewContextElement(_type, 0, name)
+	element.Constant = true
+	element.Global = label
+	mm.ContextStack.Peek().members[name] = element
+}
+
+// AddProcedure adds a constant whose value is the address of the given procedure
+func (mm *MemoryModel) AddProcedure(name string, _type typesystem.Type, procedure string) {
+	element := NewContextElement(_type, 0, name)
+	element.Constant = true
+	element.Procedure = procedure
+	mm.ContextStack.Peek().members[name] = element
+}
+
+func (mm *MemoryModel) IsTopLevel() bool {
+	return mm.ContextStack.Size() == 1
 }
 
 func (mm *MemoryModel) Update(name string, _type typesystem.Type) {
 	currentContext := mm.ContextStack.Peek()
 	member, _ := currentContext.members[name]
-	currentContext.members[name] = NewContextElement(_type, member.StackSizeAfterPush, name)
+	currentContext.members[name] = NewContextElement(_type, member.Offset, name)
 }
 
 func (mm *MemoryModel) Contains(name string) bool {
@@ -69,3 +153,15 @@ func (mm *MemoryModel) GetStructType(name string) (typesystem.Type, bool) {
 	value, ok := currentContext.structTypes[name]
 	return value, ok
 }
+
+func (mm *MemoryModel) SetReturnType(_type typesystem.Type) {
+	mm.ContextStack.Peek().returnType = &_type
+}
+
+func (mm *MemoryModel) GetReturnType() (typesystem.Type, bool) {
+	returnType := mm.ContextStack.Peek().returnType
+	if returnType == nil {
+		return typesystem.NewInvalid(), false
+	}
+	return *returnType, true
+}
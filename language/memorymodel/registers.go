@@ -0,0 +1,173 @@
+package memorymodel
+
+import (
+	"callmemaybe/language/typesystem"
+	"sort"
+)
+
+// AllocatableRegisters are preserved across calls, and are not used by any
+// other generated code. Every function saves the ones it uses, like C
+// functions do.
+var AllocatableRegisters = []string{"r12", "r13", "r14", "r15"}
+
+// CalleeSavedRegisters are the registers a function must restore before it
+// returns. rbx is used by every call, and r12 to r15 only hold variables
+// when registers are used.
+func (mm *MemoryModel) CalleeSavedRegisters() []string {
+	if mm.UseRegisters {
+		return append([]string{"rbx"}, AllocatableRegisters...)
+	}
+	return []string{"rbx"}
+}
+
+// Interval is the part of a function where a variable holds a value, from
+// the point where it is declared to the point where it is used last. Points
+// are numbered in the order the code is generated.
+type Interval struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// LinearScan assigns registers to intervals that are sorted by their start,
+// and returns the register of each interval, or an empty string for those
+// that are kept on the stack. The register of an interval is free again
+// after its end. When all registers are taken, the interval that ends last
+// is spilled, since it would keep a register from the most other intervals.
+func LinearScan(intervals []Interval) []string {
+	registers := make([]string, len(intervals))
+	taken := make(map[string]bool)
+	// active are the intervals in registers that have not ended, by their end
+	var active []int
+	for i, interval := range intervals {
+		for len(active) > 0 && intervals[active[0]].End < interval.Start {
+			delete(taken, registers[active[0]])
+			active = active[1:]
+		}
+		register := ""
+		for _, candidate := range AllocatableRegisters {
+			if !taken[candidate] {
+				register = candidate
+				break
+			}
+		}
+		if register == "" {
+			last := active[len(active)-1]
+			if intervals[last].End <= interval.End {
+				continue
+			}
+			register = registers[last]
+			registers[last] = ""
+			active = active[:len(active)-1]
+		}
+		registers[i] = register
+		taken[register] = true
+		position := len(active)
+		for position > 0 && intervals[active[position-1]].End > interval.End {
+			position--
+		}
+		active = append(active[:position], append([]int{i}, active[position:]...)...)
+	}
+	return registers
+}
+
+// AllocateRegisters decides where the variables of the current function are
+// stored before its body is generated, from their intervals in the order they
+// are declared. Nothing is kept in registers if they are not enabled.
+func (mm *MemoryModel) AllocateRegisters(intervals []Interval) {
+	frame := mm.ContextStack.Peek().frame
+	frame.allocation = nil
+	frame.declared = 0
+	if !mm.UseRegisters {
+		return
+	}
+	sorted := make([]int, len(intervals))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return intervals[sorted[a]].Start < intervals[sorted[b]].Start
+	})
+	ordered := make([]Interval, len(intervals))
+	for i, index := range sorted {
+		ordered[i] = intervals[index]
+	}
+	registers := LinearScan(ordered)
+	frame.allocation = make([]allocation, len(intervals))
+	for i, index := range sorted {
+		frame.allocation[index] = allocation{name: intervals[index].Name, register: registers[i]}
+	}
+}
+
+// AllocateRegister returns the register that was allocated for the next
+// variable that is declared in the current function, if it is not kept on
+// the stack. A variable that was not expected means that the intervals did
+// not match the code, so the rest of the variables are kept on the stack.
+func (mm *MemoryModel) AllocateRegister(name string) (string, bool) {
+	frame := mm.ContextStack.Peek().frame
+	if frame.declared >= len(frame.allocation) || frame.allocation[frame.declared].name != name {
+		frame.allocation = nil
+		return "", false
+	}
+	register := frame.allocation[frame.declared].register
+	frame.declared++
+	if register == "" || mm.ContextStack.Peek().temporaries[register] {
+		return "", false
+	}
+	return register, true
+}
+
+// AllocateTemporary allocates a register for an intermediate value in an
+// expression, which must be freed with FreeTemporary when it is no longer
+// used. Temporaries only live while an expression is generated, so they take
+// the first register that is not used by a variable of the current function
+// or another temporary.
+func (mm *MemoryModel) AllocateTemporary() (string, bool) {
+	if !mm.UseRegisters {
+		return "", false
+	}
+	inUse := make(map[string]bool)
+	for _, register := range mm.LiveRegisters() {
+		inUse[register] = true
+	}
+	for _, register := range AllocatableRegisters {
+		if !inUse[register] {
+			mm.ContextStack.Peek().temporaries[register] = true
+			return register, true
+		}
+	}
+	return "", false
+}
+
+func (mm *MemoryModel) FreeTemporary(register string) {
+	delete(mm.ContextStack.Peek().temporaries, register)
+}
+
+// AddRegisterToCurrentContext adds a variable that is stored in a register instead of on the stack
+func (mm *MemoryModel) AddRegisterToCurrentContext(name string, _type typesystem.Type, register string, constant bool) {
+	element := NewContextElement(_type, 0, name)
+	element.Constant = constant
+	element.Register = register
+	mm.ContextStack.Peek().members[name] = element
+}
+
+// LiveRegisters returns the registers that hold variables or temporaries of
+// the current function, in the order they are allocated
+func (mm *MemoryModel) LiveRegisters() []string {
+	inUse := make(map[string]bool)
+	for _, member := range mm.frameMembers() {
+		if member.Register != "" {
+			inUse[member.Register] = true
+		}
+	}
+	for register := range mm.ContextStack.Peek().temporaries {
+		inUse[register] = true
+	}
+	var live []string
+	for _, register := range AllocatableRegisters {
+		if inUse[register] {
+			live = append(live, register)
+		}
+	}
+	return live
+}
@@ -10,10 +10,16 @@ import (
 type Parser struct {
 	tokenizer *Tokenizer
 	buffer    struct {
-		kind  Token
-		token string
-		full  bool
-	}
+		kind     Token
+		token    string
+		full     bool
+		position Position
+	}
+	// Positions makes the parser record where identifiers, declarations and
+	// statements are in the source, and where parse errors happen. Nodes
+	// have zero positions otherwise.
+	Positions bool
+	comments  []Comment
 }
 
 func NewParser(reader io.Reader) *Parser {
@@ -24,11 +30,24 @@ func NewParser(reader io.Reader) *Parser {
 
 func (parser *Parser) Parse() (Stmt, error) {
 	stmt, err := parser.parseSeq()
+	if err != nil {
+		return nil, errorAt(parser.position(), err)
+	}
+	nextKind, nextStr := parser.readIgnoreWhiteSpace()
+	if nextKind != EOF {
+		return nil, errorAt(parser.position(), fmt.Errorf("failed to parse the entire program: %s", nextStr))
+	}
+	return stmt, nil
+}
+
+// ParseExpression parses input that is a single expression
+func (parser *Parser) ParseExpression() (Exp, error) {
+	exp, err := parser.ParseExp()
 	nextKind, nextStr := parser.readIgnoreWhiteSpace()
 	if err == nil && nextKind != EOF {
-		return nil, fmt.Errorf("failed to parse the entire program: %s", nextStr)
+		return nil, fmt.Errorf("failed to parse the entire expression: %s", nextStr)
 	}
-	return stmt, err
+	return exp, err
 }
 
 func (parser *Parser) read() (Token, string) {
@@ -36,12 +55,38 @@ func (parser *Parser) read() (Token, string) {
 		parser.buffer.full = false
 		return parser.buffer.kind, parser.buffer.token
 	}
+	parser.buffer.position = parser.tokenizer.Position()
 	kind, token := parser.tokenizer.NextToken()
+	parser.comments = append(parser.comments, parser.tokenizer.Trivia()...)
 	parser.buffer.kind = kind
 	parser.buffer.token = token
 	return kind, token
 }
 
+// Comments returns the comments before the tokens that have been parsed, in
+// the order they are in the source
+func (parser *Parser) Comments() []Comment {
+	return parser.comments
+}
+
+// position is where the last token that was read starts, or the zero
+// Position if positions are not recorded
+func (parser *Parser) position() Position {
+	if !parser.Positions {
+		return Position{}
+	}
+	return parser.buffer.position
+}
+
+// appendPosition appends the position if positions are recorded, so that
+// the slices of positions in the nodes are nil otherwise
+func (parser *Parser) appendPosition(positions []Position, position Position) []Position {
+	if !parser.Positions {
+		return positions
+	}
+	return append(positions, position)
+}
+
 func (parser *Parser) unread() {
 	parser.buffer.full = true
 }
@@ -67,7 +112,8 @@ func (parser *Parser) parseString() (Exp, error) {
 				RawType: typesystem.Char,
 			},
 		},
-		Size: len(value),
+		Size:     len(value),
+		Position: parser.position(),
 	}
 
 	for i := range value {
@@ -87,14 +133,16 @@ func (parser *Parser) ParseExp() (Exp, error) {
 	}
 	for {
 		nextKind, _ := parser.readIgnoreWhiteSpace()
+		position := parser.position()
 		if nextKind == Plus {
 			right, err := parser.parseVal()
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse right side of plus exp: %w", err)
 			}
 			left = ExpPlus{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -104,8 +152,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of multiply exp: %w", err)
 			}
 			left = ExpMultiply{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -115,8 +164,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of divide exp: %w", err)
 			}
 			left = ExpDivide{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -126,8 +176,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of modulo exp: %w", err)
 			}
 			left = ExpModulo{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -137,8 +188,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of minus exp: %w", err)
 			}
 			left = ExpMinus{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -148,8 +200,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of less expression")
 			}
 			left = ExpLess{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -159,8 +212,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of greater expression")
 			}
 			left = ExpGreater{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -170,8 +224,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of equals expression")
 			}
 			left = ExpEquals{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -181,8 +236,9 @@ func (parser *Parser) ParseExp() (Exp, error) {
 				return nil, fmt.Errorf("failed to parse right side of not equals expression")
 			}
 			left = ExpNotEquals{
-				Left:  left,
-				Right: right,
+				Left:     left,
+				Right:    right,
+				Position: position,
 			}
 			continue
 		}
@@ -194,38 +250,54 @@ func (parser *Parser) ParseExp() (Exp, error) {
 
 func (parser *Parser) parseVal() (Exp, error) {
 	nextKind, nextToken := parser.readIgnoreWhiteSpace()
+	position := parser.position()
 	if nextKind == Number {
 		value, _ := strconv.Atoi(nextToken)
 		return ExpNum{
-			Value: value,
+			Value:    value,
+			Position: position,
 		}, nil
 	}
 	if nextKind == True {
 		return ExpBool{
-			Value: true,
+			Value:    true,
+			Position: position,
 		}, nil
 	}
 	if nextKind == False {
 		return ExpBool{
-			Value: false,
+			Value:    false,
+			Position: position,
 		}, nil
 	}
+	if nextKind == None {
+		return ExpNone{Position: position}, nil
+	}
+	if nextKind == Some {
+		parser.unread()
+		return parser.parseSome()
+	}
 	if nextKind == RoundBracketStart {
 		inside, err := parser.ParseExp()
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse exp in parentheses: %w", err)
 		}
 		nextKind, _ = parser.readIgnoreWhiteSpace()
+		if nextKind == Comma {
+			return parser.parseTuple(inside, position)
+		}
 		if nextKind != RoundBracketEnd {
 			return nil, fmt.Errorf("missing closing parentheses")
 		}
 		return ExpParentheses{
-			Inside: inside,
+			Inside:   inside,
+			Position: position,
 		}, nil
 	}
 	if nextKind == Identifier {
 		return ExpIdentifier{
-			Name: nextToken,
+			Name:     nextToken,
+			Position: position,
 		}, nil
 	}
 	if nextKind == Minus {
@@ -234,12 +306,14 @@ func (parser *Parser) parseVal() (Exp, error) {
 			return nil, fmt.Errorf("failed to parse exp in negative expression: %w", err)
 		}
 		return ExpNegative{
-			Inside: inside,
+			Inside:   inside,
+			Position: position,
 		}, nil
 	}
 	if nextKind == Character {
 		return ExpChar{
-			Value: nextToken,
+			Value:    nextToken,
+			Position: position,
 		}, nil
 	}
 	if nextKind == Question {
@@ -273,11 +347,37 @@ func (parser *Parser) parseVal() (Exp, error) {
 	return nil, fmt.Errorf("unexpected token while parsing val")
 }
 
+// parseTuple parses the rest of a tuple literal after the first element and
+// comma, where the ( is at the position
+func (parser *Parser) parseTuple(first Exp, position Position) (Exp, error) {
+	tuple := ExpTuple{
+		Elements: []Exp{first},
+		Position: position,
+	}
+	for {
+		exp, err := parser.ParseExp()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse element in tuple: %w", err)
+		}
+		tuple.Elements = append(tuple.Elements, exp)
+		kind, _ := parser.readIgnoreWhiteSpace()
+		if kind == Comma {
+			continue
+		}
+		if kind == RoundBracketEnd {
+			break
+		}
+		return nil, fmt.Errorf("expected comma or ) in tuple")
+	}
+	return tuple, nil
+}
+
 func (parser *Parser) parseLength() (Exp, error) {
 	kind, _ := parser.readIgnoreWhiteSpace()
 	if kind != Length {
 		return nil, fmt.Errorf("expected length keyword")
 	}
+	position := parser.position()
 	kind, _ = parser.readIgnoreWhiteSpace()
 	if kind != RoundBracketStart {
 		return nil, fmt.Errorf("expected (")
@@ -291,13 +391,76 @@ func (parser *Parser) parseLength() (Exp, error) {
 	if kind != RoundBracketEnd {
 		return nil, fmt.Errorf("expected )")
 	}
-	return ExpLength{List: exp}, nil
+	return ExpLength{List: exp, Position: position}, nil
 }
 
-func (parser *Parser) parseAssign() (Stmt, error) {
+func (parser *Parser) parseSome() (Exp, error) {
+	kind, _ := parser.readIgnoreWhiteSpace()
+	if kind != Some {
+		return nil, fmt.Errorf("expected some keyword")
+	}
+	position := parser.position()
+	kind, _ = parser.readIgnoreWhiteSpace()
+	if kind != RoundBracketStart {
+		return nil, fmt.Errorf("expected (")
+	}
+	exp, err := parser.ParseExp()
+	if err != nil {
+		return nil, fmt.Errorf("exp in some: %w", err)
+	}
+	kind, _ = parser.readIgnoreWhiteSpace()
+	if kind != RoundBracketEnd {
+		return nil, fmt.Errorf("expected )")
+	}
+	return ExpSome{Inside: exp, Position: position}, nil
+}
+
+// parseSomeBinding parses the "some <identifier> = <exp>" condition that
+// unwraps an option in if and loop statements, after the some keyword
+func (parser *Parser) parseSomeBinding() (string, Position, Exp, error) {
 	kind, identifier := parser.readIgnoreWhiteSpace()
-	if kind != Identifier && kind != Placeholder {
-		return nil, fmt.Errorf("failed to parse identifier at start of assign statement")
+	if kind != Identifier {
+		return "", Position{}, nil, fmt.Errorf("expected identifier after some")
+	}
+	position := parser.position()
+	kind, _ = parser.readIgnoreWhiteSpace()
+	if kind != Assign {
+		return "", Position{}, nil, fmt.Errorf("expected = after some %s", identifier)
+	}
+	exp, err := parser.ParseExp()
+	if err != nil {
+		return "", Position{}, nil, fmt.Errorf("option expression: %w", err)
+	}
+	return identifier, position, exp, nil
+}
+
+func (parser *Parser) parseAssign() (Stmt, error) {
+	var identifiers []string
+	var positions []Position
+	var annotation *typesystem.Type
+	for {
+		kind, identifier := parser.readIgnoreWhiteSpace()
+		if kind != Identifier && kind != Placeholder {
+			return nil, fmt.Errorf("failed to parse identifier at start of assign statement")
+		}
+		identifiers = append(identifiers, identifier)
+		positions = parser.appendPosition(positions, parser.position())
+		kind, _ = parser.readIgnoreWhiteSpace()
+		if kind == Colon {
+			_type, err := parser.parseType()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse type annotation of %s: %w", identifier, err)
+			}
+			annotation = &_type
+			kind, _ = parser.readIgnoreWhiteSpace()
+		}
+		if kind != Comma {
+			parser.unread()
+			break
+		}
+	}
+	if annotation != nil && len(identifiers) > 1 {
+		return nil, fmt.Errorf("type annotations are not supported when destructuring")
 	}
 	kind, token := parser.readIgnoreWhiteSpace()
 	if kind != Assign {
@@ -307,7 +470,10 @@ func (parser *Parser) parseAssign() (Stmt, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse expression in assign stmt: %w", err)
 	}
-	return StmtAssign{Identifier: identifier, Expression: expr}, nil
+	if len(identifiers) > 1 {
+		return StmtAssign{Identifiers: identifiers, Expression: expr, Positions: positions}, nil
+	}
+	return StmtAssign{Identifier: identifiers[0], Expression: expr, Type: annotation, Positions: positions}, nil
 }
 
 func (parser *Parser) parsePrintln() (Stmt, error) {
@@ -324,8 +490,11 @@ func (parser *Parser) parsePrintln() (Stmt, error) {
 
 func (parser *Parser) parseSeq() (Stmt, error) {
 	var statements []Stmt
+	var positions []Position
 	for {
 		nextKind, _ := parser.readIgnoreWhiteSpace()
+		// The position of the token that ends the sequence is removed below
+		positions = parser.appendPosition(positions, parser.position())
 		if nextKind == Identifier || nextKind == Placeholder {
 			parser.unread()
 			statement, err := parser.parseAssign()
@@ -335,6 +504,16 @@ func (parser *Parser) parseSeq() (Stmt, error) {
 			statements = append(statements, statement)
 			continue
 		}
+		if nextKind == Const {
+			statement, err := parser.parseAssign()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse const assign expression: %w", err)
+			}
+			assign := statement.(StmtAssign)
+			assign.Constant = true
+			statements = append(statements, assign)
+			continue
+		}
 		if nextKind == PrintLn {
 			parser.unread()
 			statement, err := parser.parsePrintln()
@@ -349,6 +528,15 @@ func (parser *Parser) parseSeq() (Stmt, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse expression after return: %w", err)
 			}
+			nextKind, _ = parser.readIgnoreWhiteSpace()
+			if nextKind == Comma {
+				expr, err = parser.parseReturnTuple(expr)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse tuple after return: %w", err)
+				}
+			} else {
+				parser.unread()
+			}
 			statement := StmtReturn{Expression: expr}
 			statements = append(statements, statement)
 			continue
@@ -371,6 +559,34 @@ func (parser *Parser) parseSeq() (Stmt, error) {
 			statements = append(statements, statement)
 			continue
 		}
+		if nextKind == Fn {
+			parser.unread()
+			statement, err := parser.parseFunctionDeclaration()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse function declaration: %w", err)
+			}
+			statements = append(statements, statement)
+			continue
+		}
+		if nextKind == Export {
+			statement, err := parser.parseFunctionDeclaration()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse exported function declaration: %w", err)
+			}
+			declaration := statement.(StmtFunctionDeclaration)
+			declaration.Exported = true
+			statements = append(statements, declaration)
+			continue
+		}
+		if nextKind == Extern {
+			parser.unread()
+			statement, err := parser.parseExtern()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse extern declaration: %w", err)
+			}
+			statements = append(statements, statement)
+			continue
+		}
 		if nextKind == Struct {
 			parser.unread()
 			statement, err := parser.parseStructDeclaration()
@@ -392,7 +608,44 @@ func (parser *Parser) parseSeq() (Stmt, error) {
 		parser.unread()
 		break
 	}
-	return StmtSeq{Statements: statements}, nil
+	var end Position
+	if len(positions) > len(statements) {
+		end = positions[len(statements)]
+		positions = positions[:len(statements)]
+	}
+	return StmtSeq{Statements: statements, Positions: positions, End: end}, nil
+}
+
+// parseBody parses the statements of a body, right after its {
+func (parser *Parser) parseBody() (Stmt, error) {
+	start := parser.position()
+	body, err := parser.parseSeq()
+	if err != nil {
+		return nil, err
+	}
+	seq := body.(StmtSeq)
+	seq.Start = start
+	return seq, nil
+}
+
+// parseReturnTuple parses the rest of "return a, b, ..." after the first comma
+func (parser *Parser) parseReturnTuple(first Exp) (Exp, error) {
+	tuple := ExpTuple{
+		Elements: []Exp{first},
+	}
+	for {
+		exp, err := parser.ParseExp()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse returned value: %w", err)
+		}
+		tuple.Elements = append(tuple.Elements, exp)
+		kind, _ := parser.readIgnoreWhiteSpace()
+		if kind != Comma {
+			parser.unread()
+			break
+		}
+	}
+	return tuple, nil
 }
 
 func (parser *Parser) parseStructInit() (Exp, error) {
@@ -400,6 +653,7 @@ func (parser *Parser) parseStructInit() (Exp, error) {
 	if kind != At {
 		return nil, fmt.Errorf("expected @")
 	}
+	position := parser.position()
 	kind, name := parser.readIgnoreWhiteSpace()
 	if kind != Identifier {
 		return nil, fmt.Errorf("expected identifier")
@@ -409,7 +663,8 @@ func (parser *Parser) parseStructInit() (Exp, error) {
 		return nil, fmt.Errorf("expected {")
 	}
 	structExp := StructExp{
-		Name:    name,
+		Name:     name,
+		Position: position,
 	}
 	for {
 		kind, memberName := parser.readIgnoreWhiteSpace()
@@ -419,6 +674,7 @@ func (parser *Parser) parseStructInit() (Exp, error) {
 		if kind != Identifier {
 			return nil, fmt.Errorf("expected identifier")
 		}
+		position := parser.position()
 		kind, _ = parser.readIgnoreWhiteSpace()
 		if kind != Colon {
 			return nil, fmt.Errorf("expected colon")
@@ -428,8 +684,9 @@ func (parser *Parser) parseStructInit() (Exp, error) {
 			return nil, fmt.Errorf("failed to parse expression in struct member")
 		}
 		structExp.Members = append(structExp.Members, StructMember{
-			Name: memberName,
-			Exp:  exp,
+			Name:     memberName,
+			Exp:      exp,
+			Position: position,
 		})
 	}
 	return structExp, nil
@@ -441,7 +698,17 @@ func (parser *Parser) parseLoop() (Stmt, error) {
 		return nil, fmt.Errorf("expected loop keyword")
 	}
 
-	exp, err := parser.ParseExp()
+	identifier := ""
+	var position Position
+	var exp Exp
+	var err error
+	kind, _ = parser.readIgnoreWhiteSpace()
+	if kind == Some {
+		identifier, position, exp, err = parser.parseSomeBinding()
+	} else {
+		parser.unread()
+		exp, err = parser.ParseExp()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("loop condition: %w", err)
 	}
@@ -451,7 +718,7 @@ func (parser *Parser) parseLoop() (Stmt, error) {
 		return nil, fmt.Errorf("expected { in loop")
 	}
 
-	body, err := parser.parseSeq()
+	body, err := parser.parseBody()
 	if err != nil {
 		return nil, fmt.Errorf("loop body: %w", err)
 	}
@@ -461,6 +728,15 @@ func (parser *Parser) parseLoop() (Stmt, error) {
 		return nil, fmt.Errorf("expected } in loop")
 	}
 
+	if identifier != "" {
+		return StmtLoopSome{
+			Identifier: identifier,
+			Expression: exp,
+			Body:       body,
+			Position:   position,
+		}, nil
+	}
+
 	return StmtLoop{
 		Condition: exp,
 		Body:      body,
@@ -473,7 +749,17 @@ func (parser *Parser) parseIf() (Stmt, error) {
 		return nil, fmt.Errorf("expected if keyword at start of if statement")
 	}
 
-	expr, err := parser.ParseExp()
+	identifier := ""
+	var position Position
+	var expr Exp
+	var err error
+	kind, _ = parser.readIgnoreWhiteSpace()
+	if kind == Some {
+		identifier, position, expr, err = parser.parseSomeBinding()
+	} else {
+		parser.unread()
+		expr, err = parser.ParseExp()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse condition expression in if statement: %w", err)
 	}
@@ -483,7 +769,7 @@ func (parser *Parser) parseIf() (Stmt, error) {
 		return nil, fmt.Errorf("expected { when parsing if statement, but got: %s", text)
 	}
 
-	seq, err := parser.parseSeq()
+	seq, err := parser.parseBody()
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse sequence in if statement: %w", err)
 	}
@@ -493,6 +779,15 @@ func (parser *Parser) parseIf() (Stmt, error) {
 		return nil, fmt.Errorf("expected } when parsing if statement, but got: %s", text)
 	}
 
+	if identifier != "" {
+		return StmtIfSome{
+			Identifier: identifier,
+			Expression: expr,
+			Body:       seq,
+			Position:   position,
+		}, nil
+	}
+
 	return StmtIf{
 		Expression: expr,
 		Body:       seq,
@@ -504,13 +799,12 @@ func (parser *Parser) parseCall() (Exp, error) {
 	if kind != Hash {
 		return nil, fmt.Errorf("expected # in call")
 	}
+	call := FunctionCall{Position: parser.position()}
 
 	expr, err := parser.ParseExp()
 	if err != nil {
 		return nil, fmt.Errorf("expression in call: %w", err)
 	}
-
-	call := FunctionCall{}
 	call.Exp = expr
 
 	kind, _ = parser.readIgnoreWhiteSpace()
@@ -549,6 +843,7 @@ func (parser *Parser) parseFunction() (Exp, error) {
 	if kind != Pipe {
 		return nil, fmt.Errorf("expected |")
 	}
+	function.Position = parser.position()
 	first := true
 	for {
 		kind, identifier := parser.readIgnoreWhiteSpace()
@@ -558,6 +853,7 @@ func (parser *Parser) parseFunction() (Exp, error) {
 		if kind != Identifier {
 			return nil, fmt.Errorf("expected identifier")
 		}
+		position := parser.position()
 		kind, _ = parser.readIgnoreWhiteSpace()
 		if kind == Comma && first {
 			function.Recurse = identifier
@@ -581,6 +877,7 @@ func (parser *Parser) parseFunction() (Exp, error) {
 			Name: identifier,
 			Type: argType,
 		})
+		function.Positions = parser.appendPosition(function.Positions, position)
 		kind, _ = parser.readIgnoreWhiteSpace()
 		if kind == Pipe {
 			break
@@ -591,7 +888,131 @@ func (parser *Parser) parseFunction() (Exp, error) {
 		return nil, fmt.Errorf("expected comma or end of argument list")
 	}
 
+	err := parser.parseFunctionBody(&function)
+	if err != nil {
+		return nil, err
+	}
+	return function, nil
+}
+
+func (parser *Parser) parseFunctionDeclaration() (Stmt, error) {
+	kind, _ := parser.readIgnoreWhiteSpace()
+	if kind != Fn {
+		return nil, fmt.Errorf("expected fn keyword")
+	}
+	kind, name := parser.readIgnoreWhiteSpace()
+	if kind != Identifier {
+		return nil, fmt.Errorf("expected function name")
+	}
+	position := parser.position()
+	function := ExpFunction{
+		Type: typesystem.Type{
+			RawType: typesystem.Function,
+		},
+	}
+	var err error
+	function.Positions, err = parser.parseArguments(&function.Type)
+	if err != nil {
+		return nil, err
+	}
+	err = parser.parseFunctionBody(&function)
+	if err != nil {
+		return nil, err
+	}
+	return StmtFunctionDeclaration{
+		Name:     name,
+		Function: function,
+		Position: position,
+	}, nil
+}
+
+// parseArguments parses a parenthesized list of named arguments into a
+// function type, and returns the positions of their names
+func (parser *Parser) parseArguments(function *typesystem.Type) ([]Position, error) {
+	var positions []Position
+	kind, _ := parser.readIgnoreWhiteSpace()
+	if kind != RoundBracketStart {
+		return nil, fmt.Errorf("expected ( after function name")
+	}
+	for {
+		kind, identifier := parser.readIgnoreWhiteSpace()
+		if kind == RoundBracketEnd && len(function.FunctionArgumentTypes) == 0 {
+			break
+		}
+		if kind != Identifier {
+			return nil, fmt.Errorf("expected identifier")
+		}
+		positions = parser.appendPosition(positions, parser.position())
+		argType, err := parser.parseType()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse type: %w", err)
+		}
+		if !argType.IsPassable() {
+			return nil, fmt.Errorf("expected passable type when parsing function arguments")
+		}
+		function.FunctionArgumentTypes = append(function.FunctionArgumentTypes, typesystem.NamedType{
+			Name: identifier,
+			Type: argType,
+		})
+		kind, _ = parser.readIgnoreWhiteSpace()
+		if kind == RoundBracketEnd {
+			break
+		}
+		if kind != Comma {
+			return nil, fmt.Errorf("expected comma or end of argument list")
+		}
+	}
+	return positions, nil
+}
+
+// parseExtern parses the signature of a C function. It has no body, so the
+// return type is only parsed if the next token is one of the types that can
+// be passed to C.
+func (parser *Parser) parseExtern() (Stmt, error) {
+	kind, _ := parser.readIgnoreWhiteSpace()
+	if kind != Extern {
+		return nil, fmt.Errorf("expected extern keyword")
+	}
 	kind, _ = parser.readIgnoreWhiteSpace()
+	if kind != Fn {
+		return nil, fmt.Errorf("expected fn after extern")
+	}
+	kind, name := parser.readIgnoreWhiteSpace()
+	if kind != Identifier {
+		return nil, fmt.Errorf("expected function name")
+	}
+	position := parser.position()
+	function := typesystem.Type{
+		RawType: typesystem.Function,
+	}
+	_, err := parser.parseArguments(&function)
+	if err != nil {
+		return nil, err
+	}
+	kind, _ = parser.readIgnoreWhiteSpace()
+	parser.unread()
+	if kind == TypeInt || kind == TypeChar || kind == TypeBool || kind == TypeString {
+		returnType, err := parser.parseType()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse extern return type: %w", err)
+		}
+		function.FunctionReturnType = &returnType
+	} else {
+		function.FunctionReturnType = &typesystem.Type{
+			RawType: typesystem.Void,
+		}
+	}
+	return StmtExtern{
+		Name:     name,
+		Type:     function,
+		Position: position,
+	}, nil
+}
+
+// parseFunctionBody parses the optional return type and the body that follow
+// the argument list of both function literals and declarations
+func (parser *Parser) parseFunctionBody(function *ExpFunction) error {
+	kind, _ := parser.readIgnoreWhiteSpace()
 	if kind == CurlyBracketStart {
 		function.Type.FunctionReturnType = &typesystem.Type{
 			RawType: typesystem.Void,
@@ -600,27 +1021,27 @@ func (parser *Parser) parseFunction() (Exp, error) {
 		parser.unread()
 		returnType, err := parser.parseType()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse function return type")
+			return fmt.Errorf("failed to parse function return type")
 		}
 		if !returnType.IsPassable() {
-			return nil, fmt.Errorf("expected passable type when parsing function return type")
+			return fmt.Errorf("expected passable type when parsing function return type")
 		}
 		function.Type.FunctionReturnType = &returnType
 		kind, _ = parser.readIgnoreWhiteSpace()
 	}
 	if kind != CurlyBracketStart {
-		return nil, fmt.Errorf("expected opening curly bracket when parsing function")
+		return fmt.Errorf("expected opening curly bracket when parsing function")
 	}
-	seq, err := parser.parseSeq()
+	seq, err := parser.parseBody()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse statements in function: %w", err)
+		return fmt.Errorf("failed to parse statements in function: %w", err)
 	}
 	function.Body = seq
 	kind, _ = parser.readIgnoreWhiteSpace()
 	if kind != CurlyBracketEnd {
-		return nil, fmt.Errorf("expected closing curly bracker when parsing function")
+		return fmt.Errorf("expected closing curly bracker when parsing function")
 	}
-	return function, nil
+	return nil
 }
 
 func (parser *Parser) parseList() (Exp, error) {
@@ -628,6 +1049,7 @@ func (parser *Parser) parseList() (Exp, error) {
 	if kind != AngleBracketStart {
 		return nil, fmt.Errorf("expected angle bracket when parsing list")
 	}
+	position := parser.position()
 	_type, err := parser.parseType()
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse list type: %w", err)
@@ -650,7 +1072,7 @@ func (parser *Parser) parseList() (Exp, error) {
 		return nil, fmt.Errorf("expected box bracket in list declaration")
 	}
 
-	list := ExpList{}
+	list := ExpList{Position: position}
 	first := true
 
 	for {
@@ -703,9 +1125,10 @@ func (parser *Parser) parseStructDeclaration() (Stmt, error) {
 		return nil, fmt.Errorf("expected curly bracket")
 	}
 	structType := typesystem.Type{
-		RawType:               typesystem.Struct,
-		StructName:            name,
+		RawType:    typesystem.Struct,
+		StructName: name,
 	}
+	var positions []Position
 	for {
 		kind, memberName := parser.readIgnoreWhiteSpace()
 		if kind == CurlyBracketEnd {
@@ -714,6 +1137,7 @@ func (parser *Parser) parseStructDeclaration() (Stmt, error) {
 		if kind != Identifier {
 			return nil, fmt.Errorf("expected identifier")
 		}
+		positions = parser.appendPosition(positions, parser.position())
 		_type, err := parser.parseType()
 		if err != nil {
 			return nil, fmt.Errorf("struct type: %w", err)
@@ -724,7 +1148,8 @@ func (parser *Parser) parseStructDeclaration() (Stmt, error) {
 		})
 	}
 	return StmtStructDeclaration{
-		Type: structType,
+		Type:      structType,
+		Positions: positions,
 	}, nil
 }
 
@@ -749,6 +1174,7 @@ func (parser *Parser) parseUpdateStmt() (Stmt, error) {
 			Struct:   val.Struct,
 			Member:   val.Field,
 			NewValue: newValue,
+			Position: val.Position,
 		}, nil
 	}
 
@@ -781,6 +1207,7 @@ func (parser *Parser) parseReference() (Exp, error) {
 		kind, _ = parser.readIgnoreWhiteSpace()
 		if kind == BoxBracketStart {
 			hasOne = true
+			position := parser.position()
 			numExp, err := parser.ParseExp()
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse index expression in get from list")
@@ -790,8 +1217,9 @@ func (parser *Parser) parseReference() (Exp, error) {
 				return nil, fmt.Errorf("expected ]")
 			}
 			current = ExpGetFromList{
-				List:  current,
-				Index: numExp,
+				List:     current,
+				Index:    numExp,
+				Position: position,
 			}
 			continue
 		}
@@ -802,8 +1230,9 @@ func (parser *Parser) parseReference() (Exp, error) {
 				return nil, fmt.Errorf("expected identifier")
 			}
 			current = ExpReadFromStruct{
-				Field:  identifier,
-				Struct: current,
+				Field:    identifier,
+				Struct:   current,
+				Position: parser.position(),
 			}
 			continue
 		}
@@ -847,14 +1276,14 @@ func (parser *Parser) parseType() (typesystem.Type, error) {
 			return typesystem.Type{}, fmt.Errorf("expected closing angle bracket when parsing list type")
 		}
 		return typesystem.Type{
-			RawType:               typesystem.List,
-			ListElementType:       &elementType,
+			RawType:         typesystem.List,
+			ListElementType: &elementType,
 		}, nil
 	case TypeString:
 		return typesystem.Type{
-			RawType:               typesystem.List,
-			ListElementType:       &typesystem.Type{
-				RawType:               typesystem.Char,
+			RawType: typesystem.List,
+			ListElementType: &typesystem.Type{
+				RawType: typesystem.Char,
 			},
 		}, nil
 	case TypeFunc:
@@ -864,8 +1293,8 @@ func (parser *Parser) parseType() (typesystem.Type, error) {
 			return typesystem.Type{
 				RawType:               typesystem.Function,
 				FunctionArgumentTypes: nil,
-				FunctionReturnType:    &typesystem.Type{
-					RawType:               typesystem.Void,
+				FunctionReturnType: &typesystem.Type{
+					RawType: typesystem.Void,
 				},
 			}, nil
 		}
@@ -889,9 +1318,9 @@ func (parser *Parser) parseType() (typesystem.Type, error) {
 		}
 		size := len(types)
 		result := typesystem.Type{
-			RawType:               typesystem.Function,
+			RawType: typesystem.Function,
 		}
-		for i := 0; i < size - 1; i++ {
+		for i := 0; i < size-1; i++ {
 			current := types[i]
 			result.FunctionArgumentTypes = append(result.FunctionArgumentTypes, typesystem.NamedType{
 				Name: "",
@@ -900,14 +1329,49 @@ func (parser *Parser) parseType() (typesystem.Type, error) {
 		}
 		result.FunctionReturnType = &types[size-1]
 		return result, nil
+	case RoundBracketStart:
+		var elements []typesystem.Type
+		for {
+			elementType, err := parser.parseType()
+			if err != nil {
+				return typesystem.Type{}, fmt.Errorf("failed to parse tuple element type: %w", err)
+			}
+			elements = append(elements, elementType)
+			kind, _ = parser.readIgnoreWhiteSpace()
+			if kind == Comma {
+				continue
+			}
+			if kind == RoundBracketEnd {
+				break
+			}
+			return typesystem.Type{}, fmt.Errorf("expected comma or ) in tuple type")
+		}
+		if len(elements) < 2 {
+			return typesystem.Type{}, fmt.Errorf("a tuple type needs at least two elements")
+		}
+		return typesystem.NewTuple(elements), nil
+	case TypeOption:
+		kind, _ = parser.readIgnoreWhiteSpace()
+		if kind != AngleBracketStart {
+			return typesystem.Type{}, fmt.Errorf("expected opening angle bracket")
+		}
+		elementType, err := parser.parseType()
+		if err != nil {
+			return typesystem.Type{}, fmt.Errorf("failed to parse option element type: %w", err)
+		}
+		kind, _ = parser.readIgnoreWhiteSpace()
+		if kind != AngleBracketEnd {
+			return typesystem.Type{}, fmt.Errorf("expected closing angle bracket when parsing option type")
+		}
+		return typesystem.NewOption(elementType), nil
 	case At:
 		kind, name := parser.readIgnoreWhiteSpace()
 		if kind != Identifier {
 			return typesystem.NewInvalid(), fmt.Errorf("expected identifier")
 		}
 		return typesystem.Type{
-			RawType:               typesystem.Struct,
-			StructName:            name,
+			RawType:    typesystem.Struct,
+			StructName: name,
 		}, nil
 	default:
 		return typesystem.Type{}, fmt.Errorf("unsupported type")
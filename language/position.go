@@ -0,0 +1,50 @@
+package language
+
+import (
+	"callmemaybe/language/memorymodel"
+	"errors"
+	"fmt"
+)
+
+// Position is a place in the source. Lines and columns start at 1, and
+// columns count characters. Nodes that were parsed without positions have
+// the zero Position.
+type Position = memorymodel.Position
+
+// PositionError is an error at a place in the source
+type PositionError struct {
+	Position Position
+	Err      error
+}
+
+func (err *PositionError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", err.Position.Line, err.Position.Column, err.Err)
+}
+
+func (err *PositionError) Unwrap() error {
+	return err.Err
+}
+
+// errorAt adds the position to the error, unless it is unknown or the
+// error already has a more precise one
+func errorAt(position Position, err error) error {
+	var positioned *PositionError
+	if !position.IsValid() || errors.As(err, &positioned) {
+		return err
+	}
+	return &PositionError{Position: position, Err: err}
+}
+
+// errorAt adds the position of the statement at the index to the error
+func (stmt StmtSeq) errorAt(index int, err error) error {
+	return errorAt(positionAt(stmt.Positions, index), err)
+}
+
+// positionAt is the position at the index, or the zero Position if the
+// positions were not recorded
+func positionAt(positions []Position, index int) Position {
+	if index < len(positions) {
+		return positions[index]
+	}
+	return Position{}
+}
@@ -3,7 +3,9 @@ package language
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
+	"sort"
 	"unicode"
 )
 
@@ -43,6 +45,7 @@ const (
 	TypeString
 	TypeList
 	TypeFunc
+	TypeOption
 	Whitespace
 	PrintLn
 	Return
@@ -50,7 +53,13 @@ const (
 	Placeholder
 	True
 	False
+	None
+	Some
 	If
+	Const
+	Fn
+	Extern
+	Export
 	Equals
 	EOF
 	Error
@@ -60,33 +69,92 @@ const eof = rune(0)
 
 type Token int
 
+// Comment is a line comment that starts with // and ends at the end of the
+// line, or a block comment between /* and */ that may contain other block
+// comments. Text includes the slashes and stars.
+type Comment struct {
+	Text     string
+	Position Position
+}
+
 type Tokenizer struct {
 	reader *bufio.Reader
+	// position is where the next character starts, and previous is where
+	// the last character that was read started
+	position Position
+	previous Position
+	atEOF    bool
+	// pending are the comments in the whitespace that was read since the
+	// last token that is not whitespace, and trivia are the comments before
+	// the last token
+	pending []Comment
+	trivia  []Comment
 }
 
 func NewTokenizer(reader io.Reader) *Tokenizer {
 	return &Tokenizer{
-		reader: bufio.NewReader(reader),
+		reader:   bufio.NewReader(reader),
+		position: Position{Line: 1, Column: 1},
 	}
 }
 
+// Position is where the next token starts
+func (tokenizer *Tokenizer) Position() Position {
+	return tokenizer.position
+}
+
 func (tokenizer *Tokenizer) read() rune {
 	character, _, err := tokenizer.reader.ReadRune()
 	if err != nil {
+		tokenizer.atEOF = true
 		return eof
 	}
+	tokenizer.atEOF = false
+	tokenizer.previous = tokenizer.position
+	if character == '\n' {
+		tokenizer.position = Position{Line: tokenizer.position.Line + 1, Column: 1}
+	} else {
+		tokenizer.position.Column++
+	}
 	return character
 }
 
 func (tokenizer *Tokenizer) unread() {
+	if tokenizer.atEOF {
+		return
+	}
 	tokenizer.reader.UnreadRune()
+	tokenizer.position = tokenizer.previous
 }
 
 func validIdentifierChar(r rune) bool {
 	return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r == '_'
 }
 
+// NextToken reads the next token. Comments are read as whitespace, and are
+// attached as trivia to the token that follows them.
 func (tokenizer *Tokenizer) NextToken() (Token, string) {
+	kind, text := tokenizer.nextToken()
+	if kind == Whitespace {
+		tokenizer.trivia = nil
+	} else {
+		tokenizer.trivia = tokenizer.pending
+		tokenizer.pending = nil
+	}
+	return kind, text
+}
+
+// Trivia returns the comments between the last token and the token before
+// it that is not whitespace, or nothing if the last token is whitespace
+func (tokenizer *Tokenizer) Trivia() []Comment {
+	return tokenizer.trivia
+}
+
+func (tokenizer *Tokenizer) nextToken() (Token, string) {
+	if tokenizer.startsComment() {
+		return tokenizer.whitespace()
+	}
+
 	character := tokenizer.read()
 
 	if unicode.IsSpace(character) {
@@ -173,7 +241,7 @@ func (tokenizer *Tokenizer) NextToken() (Token, string) {
 	case '#':
 		return Hash, string(character)
 	}
-	
+
 	return Error, ""
 }
 
@@ -231,11 +299,21 @@ func (tokenizer *Tokenizer) character() (Token, string) {
 	return Character, string(character)
 }
 
+// whitespace reads spaces, newlines and comments
 func (tokenizer *Tokenizer) whitespace() (Token, string) {
 	var buffer bytes.Buffer
-	buffer.WriteRune(tokenizer.read())
 
 	for {
+		if tokenizer.startsComment() {
+			position := tokenizer.position
+			text, ok := tokenizer.comment()
+			if !ok {
+				return Error, fmt.Sprintf("unterminated block comment starting at %d:%d", position.Line, position.Column)
+			}
+			tokenizer.pending = append(tokenizer.pending, Comment{Text: text, Position: position})
+			buffer.WriteString(text)
+			continue
+		}
 		character := tokenizer.read()
 		if character == eof || !unicode.IsSpace(character) {
 			tokenizer.unread()
@@ -247,6 +325,51 @@ func (tokenizer *Tokenizer) whitespace() (Token, string) {
 	return Whitespace, buffer.String()
 }
 
+// startsComment is true if the next characters are // or /*. They are
+// peeked at, since only one character can be unread.
+func (tokenizer *Tokenizer) startsComment() bool {
+	next, _ := tokenizer.reader.Peek(2)
+	return len(next) == 2 && next[0] == '/' && (next[1] == '/' || next[1] == '*')
+}
+
+// comment reads a comment. It fails if a block comment is not closed.
+func (tokenizer *Tokenizer) comment() (string, bool) {
+	var buffer bytes.Buffer
+	buffer.WriteRune(tokenizer.read())
+	if tokenizer.read() == '/' {
+		buffer.WriteRune('/')
+		for {
+			character := tokenizer.read()
+			if character == eof || character == '\n' {
+				tokenizer.unread()
+				return buffer.String(), true
+			}
+			buffer.WriteRune(character)
+		}
+	}
+	buffer.WriteRune('*')
+	depth := 1
+	for depth > 0 {
+		character := tokenizer.read()
+		if character == eof && tokenizer.atEOF {
+			return "", false
+		}
+		buffer.WriteRune(character)
+		next, err := tokenizer.reader.Peek(1)
+		if err != nil {
+			continue
+		}
+		if character == '/' && next[0] == '*' {
+			buffer.WriteRune(tokenizer.read())
+			depth++
+		} else if character == '*' && next[0] == '/' {
+			buffer.WriteRune(tokenizer.read())
+			depth--
+		}
+	}
+	return buffer.String(), true
+}
+
 func (tokenizer *Tokenizer) number() (Token, string) {
 	var buffer bytes.Buffer
 	first := tokenizer.read()
@@ -267,13 +390,47 @@ func (tokenizer *Tokenizer) number() (Token, string) {
 	return Number, buffer.String()
 }
 
+var keywords = map[string]Token{
+	"println": PrintLn,
+	"int":     TypeInt,
+	"char":    TypeChar,
+	"list":    TypeList,
+	"return":  Return,
+	"if":      If,
+	"const":   Const,
+	"fn":      Fn,
+	"extern":  Extern,
+	"export":  Export,
+	"true":    True,
+	"false":   False,
+	"bool":    TypeBool,
+	"func":    TypeFunc,
+	"loop":    Loop,
+	"struct":  Struct,
+	"string":  TypeString,
+	"len":     Length,
+	"option":  TypeOption,
+	"none":    None,
+	"some":    Some,
+}
+
+// Keywords are the words that can not be used as identifiers, in alphabetical order
+func Keywords() []string {
+	var words []string
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
+
 func (tokenizer *Tokenizer) identifier() (Token, string) {
 	var buffer bytes.Buffer
 	buffer.WriteRune(tokenizer.read())
 
 	for {
 		character := tokenizer.read()
-		if !validIdentifierChar(character) && !unicode.IsDigit(character){
+		if !validIdentifierChar(character) && !unicode.IsDigit(character) {
 			tokenizer.unread()
 			break
 		}
@@ -282,36 +439,8 @@ func (tokenizer *Tokenizer) identifier() (Token, string) {
 
 	word := buffer.String()
 
-	switch word {
-	case "println":
-		return PrintLn, word
-	case "int":
-		return TypeInt, word
-	case "char":
-		return TypeChar, word
-	case "list":
-		return TypeList, word
-	case "return":
-		return Return, word
-	case "if":
-		return If, word
-	case "true":
-		return True, word
-	case "false":
-		return False, word
-	case "bool":
-		return TypeBool, word
-	case "func":
-		return TypeFunc, word
-	case "loop":
-		return Loop, word
-	case "struct":
-		return Struct, word
-	case "string":
-		return TypeString, word
-	case "len":
-		return Length, word
+	if kind, ok := keywords[word]; ok {
+		return kind, word
 	}
-
 	return Identifier, word
 }
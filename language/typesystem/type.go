@@ -1,5 +1,10 @@
 package typesystem
 
+import (
+	"fmt"
+	"strings"
+)
+
 type RawType int
 
 const (
@@ -11,9 +16,12 @@ const (
 	List
 	Function
 	Struct
+	Option
+	Tuple
 )
 
-var passable = []RawType{Int, Char, Bool, List, Function, Struct}
+var passable = []RawType{Int, Char, Bool, List, Function, Struct, Option, Tuple}
+var pointer = []RawType{List, Function, Struct}
 var comparable = []RawType{Int, Char, Bool}
 
 type Type struct {
@@ -23,6 +31,8 @@ type Type struct {
 	FunctionReturnType    *Type
 	StructName            string
 	StructMembers         []NamedType
+	OptionElementType     *Type
+	TupleElementTypes     []Type
 }
 
 type NamedType struct {
@@ -42,6 +52,12 @@ func (t Type) IsComparable() bool {
 	return contains(t.RawType, comparable)
 }
 
+// IsPointer is true for types whose values are never zero, which lets an
+// option of such a type use zero as none instead of boxing the value.
+func (t Type) IsPointer() bool {
+	return contains(t.RawType, pointer)
+}
+
 func (t Type) IsStorableOnStack() bool {
 	return t.RawType != Invalid && t.RawType != Void
 }
@@ -81,9 +97,70 @@ func (t Type) Equals(o Type) bool {
 		}
 	}
 
+	if t.RawType == Tuple {
+		if len(t.TupleElementTypes) != len(o.TupleElementTypes) {
+			return false
+		}
+
+		for i := range t.TupleElementTypes {
+			if !t.TupleElementTypes[i].Equals(o.TupleElementTypes[i]) {
+				return false
+			}
+		}
+	}
+
+	if t.RawType == Option {
+		// A nil element type is the type of none, which fits any option
+		if t.OptionElementType != nil && o.OptionElementType != nil {
+			if !(*t.OptionElementType).Equals(*o.OptionElementType) {
+				return false
+			}
+		}
+	}
+
 	return true
 }
 
+// String formats the type the way it is written in source code
+func (t Type) String() string {
+	switch t.RawType {
+	case Void:
+		return "void"
+	case Int:
+		return "int"
+	case Char:
+		return "char"
+	case Bool:
+		return "bool"
+	case List:
+		if t.ListElementType.RawType == Char {
+			return "string"
+		}
+		return fmt.Sprintf("list<%s>", t.ListElementType)
+	case Function:
+		var types []string
+		for _, arg := range t.FunctionArgumentTypes {
+			types = append(types, arg.Type.String())
+		}
+		types = append(types, t.FunctionReturnType.String())
+		return fmt.Sprintf("func<%s>", strings.Join(types, ", "))
+	case Struct:
+		return "@" + t.StructName
+	case Option:
+		if t.OptionElementType == nil {
+			return "none"
+		}
+		return fmt.Sprintf("option<%s>", t.OptionElementType)
+	case Tuple:
+		var types []string
+		for _, element := range t.TupleElementTypes {
+			types = append(types, element.String())
+		}
+		return fmt.Sprintf("(%s)", strings.Join(types, ", "))
+	}
+	return "invalid"
+}
+
 func NewInt() Type {
 	return Type{
 		RawType: Int,
@@ -102,6 +179,26 @@ func NewBool() Type {
 	}
 }
 
+func NewNone() Type {
+	return Type{
+		RawType: Option,
+	}
+}
+
+func NewOption(element Type) Type {
+	return Type{
+		RawType:           Option,
+		OptionElementType: &element,
+	}
+}
+
+func NewTuple(elements []Type) Type {
+	return Type{
+		RawType:           Tuple,
+		TupleElementTypes: elements,
+	}
+}
+
 func NewInvalid() Type {
 	return Type{
 		RawType: Invalid,
@@ -0,0 +1,119 @@
+package lsp
+
+import (
+	"callmemaybe/language"
+	"callmemaybe/language/typesystem"
+	"strings"
+	"unicode"
+)
+
+// complete offers the members of a struct after "?x.", the structs after
+// "@", and otherwise the variables and functions that are visible and the
+// keywords. The word that is being typed is left for the editor to match.
+func (server *server) complete(params textDocumentPositionParams) []completionItem {
+	items := []completionItem{}
+	doc, ok := server.documents[params.TextDocument.URI]
+	if !ok {
+		return items
+	}
+	at := doc.fromProtocol(params.Position)
+	analysis := doc.analysisWhileEditing(at.Line)
+	text := doc.before(at)
+	end := len(text)
+	for end > 0 && isWordCharacter(text[end-1]) {
+		end--
+	}
+	text = text[:end]
+
+	if len(text) > 0 && text[len(text)-1] == '.' {
+		if analysis == nil {
+			return items
+		}
+		kind, ok := referenceType(analysis, at, text[:len(text)-1])
+		if !ok {
+			return items
+		}
+		for _, member := range kind.StructMembers {
+			items = append(items, completionItem{Label: member.Name, Kind: completionField, Detail: member.Type.String()})
+		}
+		return items
+	}
+
+	if analysis == nil {
+		return keywords(items)
+	}
+	scope, ok := analysis.ScopeAt(at)
+	if len(text) > 0 && text[len(text)-1] == '@' {
+		for _, kind := range scope.Structs {
+			items = append(items, completionItem{Label: kind.StructName, Kind: completionStruct, Detail: kind.String()})
+		}
+		return items
+	}
+	if ok {
+		for _, element := range scope.Elements {
+			kind := completionVariable
+			if element.Type.RawType == typesystem.Function {
+				kind = completionFunction
+			}
+			items = append(items, completionItem{Label: element.Name, Kind: kind, Detail: element.Type.String()})
+		}
+	}
+	return keywords(items)
+}
+
+func keywords(items []completionItem) []completionItem {
+	for _, keyword := range language.Keywords() {
+		items = append(items, completionItem{Label: keyword, Kind: completionKeyword})
+	}
+	return items
+}
+
+// referenceType returns the declaration of the struct that a reference like
+// "?a.b" in the end of the text reads from
+func referenceType(analysis *language.Analysis, at language.Position, text []rune) (typesystem.Type, bool) {
+	start := len(text)
+	for start > 0 && (isWordCharacter(text[start-1]) || text[start-1] == '.') {
+		start--
+	}
+	if start == 0 || text[start-1] != '?' {
+		return typesystem.Type{}, false
+	}
+	names := strings.Split(string(text[start:]), ".")
+	element, ok := analysis.Lookup(at, names[0])
+	if !ok {
+		return typesystem.Type{}, false
+	}
+	kind := element.Type
+	for _, name := range names[1:] {
+		kind, ok = structType(analysis, at, kind)
+		if !ok {
+			return typesystem.Type{}, false
+		}
+		found := false
+		for _, member := range kind.StructMembers {
+			if member.Name == name {
+				kind = member.Type
+				found = true
+			}
+		}
+		if !found {
+			return typesystem.Type{}, false
+		}
+	}
+	return structType(analysis, at, kind)
+}
+
+// structType returns the declaration of a struct type, which has its members
+func structType(analysis *language.Analysis, at language.Position, kind typesystem.Type) (typesystem.Type, bool) {
+	if kind.RawType != typesystem.Struct {
+		return typesystem.Type{}, false
+	}
+	if declared, ok := analysis.StructType(at, kind.StructName); ok {
+		return declared, true
+	}
+	return kind, len(kind.StructMembers) > 0
+}
+
+func isWordCharacter(character rune) bool {
+	return character == '_' || unicode.IsLetter(character) || unicode.IsDigit(character)
+}
@@ -0,0 +1,192 @@
+package lsp
+
+import (
+	"encoding/json"
+)
+
+// The subset of the Language Server Protocol that the server implements.
+// Lines and characters start at 0, and characters are counted in UTF-16
+// code units.
+
+type request struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   responseError    `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+const (
+	errorMethodNotFound = -32601
+	errorInvalidParams  = -32602
+	errorRequestFailed  = -32803
+)
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+// didChangeParams has the full text of the document in every change, since
+// the server only supports full synchronisation
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type diagnostic struct {
+	Range    textRange `json:"range"`
+	Severity int       `json:"severity"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+}
+
+const severityError = 1
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    textRange     `json:"range"`
+}
+
+// The kinds of completion items
+const (
+	completionFunction = 3
+	completionField    = 5
+	completionVariable = 6
+	completionKeyword  = 14
+	completionStruct   = 22
+)
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// parameterInformation has the start and end of the parameter in the label
+// of its signature
+type parameterInformation struct {
+	Label [2]int `json:"label"`
+}
+
+type signatureInformation struct {
+	Label      string                 `json:"label"`
+	Parameters []parameterInformation `json:"parameters"`
+}
+
+type signatureHelp struct {
+	Signatures      []signatureInformation `json:"signatures"`
+	ActiveSignature int                    `json:"activeSignature"`
+	ActiveParameter int                    `json:"activeParameter"`
+}
+
+type location struct {
+	URI   string    `json:"uri"`
+	Range textRange `json:"range"`
+}
+
+type referenceParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type renameParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+	NewName      string                 `json:"newName"`
+}
+
+type textEdit struct {
+	Range   textRange `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+// workspaceEdit has the edits of every document that changes
+type workspaceEdit struct {
+	Changes map[string][]textEdit `json:"changes"`
+}
+
+const textDocumentSyncFull = 1
+
+type initializeResult struct {
+	Capabilities struct {
+		TextDocumentSync struct {
+			OpenClose bool `json:"openClose"`
+			Change    int  `json:"change"`
+		} `json:"textDocumentSync"`
+		HoverProvider      bool `json:"hoverProvider"`
+		CompletionProvider struct {
+			TriggerCharacters []string `json:"triggerCharacters"`
+		} `json:"completionProvider"`
+		SignatureHelpProvider struct {
+			TriggerCharacters []string `json:"triggerCharacters"`
+		} `json:"signatureHelpProvider"`
+		DefinitionProvider bool `json:"definitionProvider"`
+		ReferencesProvider bool `json:"referencesProvider"`
+		RenameProvider     bool `json:"renameProvider"`
+	} `json:"capabilities"`
+	ServerInfo struct {
+		Name string `json:"name"`
+	} `json:"serverInfo"`
+}
@@ -0,0 +1,336 @@
+package lsp
+
+import (
+	"bufio"
+	"callmemaybe/language"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// document is an open file, which is analysed whenever it changes. parsed
+// is the analysis of the last version that could be parsed.
+type document struct {
+	lines    [][]rune
+	analysis *language.Analysis
+	parsed   *language.Analysis
+}
+
+func newDocument(text string, previous *document) *document {
+	doc := &document{analysis: language.Analyze(text)}
+	for _, line := range strings.Split(text, "\n") {
+		doc.lines = append(doc.lines, []rune(strings.TrimSuffix(line, "\r")))
+	}
+	doc.parsed = doc.analysis
+	if !doc.analysis.Parsed && previous != nil {
+		doc.parsed = previous.parsed
+	}
+	return doc
+}
+
+// analysisWhileEditing returns an analysis that knows what is visible at the
+// line, even though the line is usually incomplete while it is edited. If
+// the document can not be parsed, it is analysed again without the line,
+// and otherwise the last version that could be parsed is used.
+func (doc *document) analysisWhileEditing(line int) *language.Analysis {
+	if doc.analysis.Parsed || line < 1 || line > len(doc.lines) {
+		return doc.parsed
+	}
+	var text strings.Builder
+	for i, characters := range doc.lines {
+		if i == line-1 {
+			// Spaces keep the end of the program after the cursor
+			text.WriteString(strings.Repeat(" ", len(characters)+1))
+		} else {
+			text.WriteString(string(characters))
+		}
+		text.WriteString("\n")
+	}
+	analysis := language.Analyze(text.String())
+	if analysis.Parsed {
+		return analysis
+	}
+	return doc.parsed
+}
+
+// before returns the characters of the line before the position
+func (doc *document) before(at language.Position) []rune {
+	if at.Line < 1 || at.Line > len(doc.lines) {
+		return nil
+	}
+	line := doc.lines[at.Line-1]
+	if at.Column-1 < len(line) {
+		return line[:at.Column-1]
+	}
+	return line
+}
+
+type server struct {
+	reader    *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+}
+
+// Serve runs a language server that reads messages from in and writes
+// messages to out, until the client tells it to exit. Diagnostics are
+// published whenever a document is opened or changed, hovering shows the
+// types of identifiers and expressions, completion and signature help use
+// what is visible where the cursor is, and identifiers and the members of
+// structs can be followed to their declarations, listed and renamed.
+func Serve(in io.Reader, out io.Writer) error {
+	server := &server{
+		reader:    bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]*document),
+	}
+	for {
+		message, err := server.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if message.Method == "exit" {
+			return nil
+		}
+		err = server.handle(message)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read reads a message, which is JSON after headers like those of HTTP
+func (server *server) read() (request, error) {
+	headers, err := textproto.NewReader(server.reader).ReadMIMEHeader()
+	if err != nil {
+		return request{}, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return request{}, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	content := make([]byte, length)
+	_, err = io.ReadFull(server.reader, content)
+	if err != nil {
+		return request{}, err
+	}
+	var message request
+	err = json.Unmarshal(content, &message)
+	if err != nil {
+		return request{}, fmt.Errorf("invalid message: %w", err)
+	}
+	return message, nil
+}
+
+func (server *server) write(message interface{}) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(server.out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	return err
+}
+
+func (server *server) reply(message request, result interface{}) error {
+	return server.write(response{JSONRPC: "2.0", ID: message.ID, Result: result})
+}
+
+func (server *server) fail(message request, code int, text string) error {
+	return server.write(errorResponse{JSONRPC: "2.0", ID: message.ID, Error: responseError{Code: code, Message: text}})
+}
+
+// handle answers requests and acts on notifications. Notifications that the
+// server does not know are ignored, as the protocol requires.
+func (server *server) handle(message request) error {
+	switch message.Method {
+	case "initialize":
+		var result initializeResult
+		result.Capabilities.TextDocumentSync.OpenClose = true
+		result.Capabilities.TextDocumentSync.Change = textDocumentSyncFull
+		result.Capabilities.HoverProvider = true
+		result.Capabilities.CompletionProvider.TriggerCharacters = []string{".", "@"}
+		result.Capabilities.SignatureHelpProvider.TriggerCharacters = []string{"(", ","}
+		result.Capabilities.DefinitionProvider = true
+		result.Capabilities.ReferencesProvider = true
+		result.Capabilities.RenameProvider = true
+		result.ServerInfo.Name = "cmm"
+		return server.reply(message, result)
+	case "shutdown":
+		return server.reply(message, nil)
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return nil
+		}
+		return server.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(message.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return server.update(params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return nil
+		}
+		delete(server.documents, params.TextDocument.URI)
+		return server.publish(params.TextDocument.URI, []diagnostic{})
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return server.fail(message, errorInvalidParams, "invalid hover parameters")
+		}
+		return server.reply(message, server.hover(params))
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return server.fail(message, errorInvalidParams, "invalid completion parameters")
+		}
+		return server.reply(message, server.complete(params))
+	case "textDocument/signatureHelp":
+		var params textDocumentPositionParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return server.fail(message, errorInvalidParams, "invalid signature help parameters")
+		}
+		return server.reply(message, server.signatureHelp(params))
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return server.fail(message, errorInvalidParams, "invalid definition parameters")
+		}
+		return server.reply(message, server.definition(params))
+	case "textDocument/references":
+		var params referenceParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return server.fail(message, errorInvalidParams, "invalid references parameters")
+		}
+		return server.reply(message, server.references(params))
+	case "textDocument/rename":
+		var params renameParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return server.fail(message, errorInvalidParams, "invalid rename parameters")
+		}
+		edit, err := server.rename(params)
+		if err != nil {
+			return server.fail(message, errorRequestFailed, err.Error())
+		}
+		return server.reply(message, edit)
+	}
+	if message.ID != nil {
+		return server.fail(message, errorMethodNotFound, fmt.Sprintf("unsupported method %s", message.Method))
+	}
+	return nil
+}
+
+// update analyses the new text of the document, and publishes its diagnostics
+func (server *server) update(uri string, text string) error {
+	doc := newDocument(text, server.documents[uri])
+	server.documents[uri] = doc
+	diagnostics := []diagnostic{}
+	for _, found := range doc.analysis.Diagnostics {
+		end := found.Position
+		end.Column += found.Length
+		if found.Length == 0 {
+			end.Column = doc.lineLength(found.Position.Line) + 1
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    textRange{Start: doc.toProtocol(found.Position), End: doc.toProtocol(end)},
+			Severity: severityError,
+			Source:   "cmm",
+			Message:  found.Message,
+		})
+	}
+	return server.publish(uri, diagnostics)
+}
+
+func (server *server) publish(uri string, diagnostics []diagnostic) error {
+	return server.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+// hover shows the type of what is under the cursor, or nothing if there is
+// no identifier or expression with a known type there
+func (server *server) hover(params textDocumentPositionParams) interface{} {
+	doc, ok := server.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	kind, start, length, ok := doc.analysis.TypeAt(doc.fromProtocol(params.Position))
+	if !ok {
+		return nil
+	}
+	value := kind.String()
+	// Variables and declared members are shown with their names
+	if occurrence, ok := doc.analysis.OccurrenceAt(start); ok && (occurrence.Element != nil || occurrence.Declaration) {
+		value = occurrence.Name + ": " + value
+	}
+	end := start
+	end.Column += length
+	return hover{
+		Contents: markupContent{Kind: "markdown", Value: "```callmemaybe\n" + value + "\n```"},
+		Range:    textRange{Start: doc.toProtocol(start), End: doc.toProtocol(end)},
+	}
+}
+
+func (doc *document) lineLength(line int) int {
+	if line < 1 || line > len(doc.lines) {
+		return 0
+	}
+	return len(doc.lines[line-1])
+}
+
+// toProtocol converts a position in characters to one in UTF-16 code units
+func (doc *document) toProtocol(at language.Position) position {
+	if at.Line < 1 || at.Line > len(doc.lines) {
+		return position{Line: at.Line - 1, Character: at.Column - 1}
+	}
+	return position{Line: at.Line - 1, Character: utf16Offset(doc.lines[at.Line-1], at.Column-1)}
+}
+
+// fromProtocol converts a position in UTF-16 code units to one in characters
+func (doc *document) fromProtocol(at position) language.Position {
+	result := language.Position{Line: at.Line + 1, Column: 1}
+	if at.Line < 0 || at.Line >= len(doc.lines) {
+		return result
+	}
+	units := 0
+	for _, character := range doc.lines[at.Line] {
+		units += utf16Length(character)
+		if units > at.Character {
+			break
+		}
+		result.Column++
+	}
+	return result
+}
+
+// utf16Offset is the number of UTF-16 code units before the character at
+// the index. Characters after the end of the line are one unit each.
+func utf16Offset(line []rune, index int) int {
+	offset := 0
+	for i := 0; i < index; i++ {
+		if i < len(line) {
+			offset += utf16Length(line[i])
+		} else {
+			offset++
+		}
+	}
+	return offset
+}
+
+func utf16Length(character rune) int {
+	if character >= 0x10000 {
+		return 2
+	}
+	return 1
+}
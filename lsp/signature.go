@@ -0,0 +1,128 @@
+package lsp
+
+import (
+	"callmemaybe/language"
+	"callmemaybe/language/typesystem"
+	"strings"
+)
+
+// bracket is a bracket that is open before the cursor. callee is the name
+// of the function if it starts the arguments of a call like "#f(".
+type bracket struct {
+	callee string
+	commas int
+}
+
+// signatureHelp shows the arguments of the innermost call that the cursor
+// is in, and which of them is being typed
+func (server *server) signatureHelp(params textDocumentPositionParams) interface{} {
+	doc, ok := server.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	at := doc.fromProtocol(params.Position)
+	open := doc.openBrackets(at)
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i].callee == "" {
+			continue
+		}
+		analysis := doc.analysisWhileEditing(at.Line)
+		if analysis == nil {
+			return nil
+		}
+		element, ok := analysis.Lookup(at, open[i].callee)
+		if !ok || element.Type.RawType != typesystem.Function {
+			return nil
+		}
+		return signatureHelp{
+			Signatures:      []signatureInformation{signature(open[i].callee, element.Type)},
+			ActiveParameter: open[i].commas,
+		}
+	}
+	return nil
+}
+
+// signature is like the declaration of the function, as in "f(a int) int"
+func signature(name string, kind typesystem.Type) signatureInformation {
+	var label strings.Builder
+	var parameters []parameterInformation
+	label.WriteString(name + "(")
+	for i, argument := range kind.FunctionArgumentTypes {
+		if i > 0 {
+			label.WriteString(", ")
+		}
+		start := label.Len()
+		if argument.Name != "" {
+			label.WriteString(argument.Name + " ")
+		}
+		label.WriteString(argument.Type.String())
+		parameters = append(parameters, parameterInformation{Label: [2]int{start, label.Len()}})
+	}
+	label.WriteString(")")
+	if kind.FunctionReturnType != nil && kind.FunctionReturnType.RawType != typesystem.Void {
+		label.WriteString(" " + kind.FunctionReturnType.String())
+	}
+	return signatureInformation{Label: label.String(), Parameters: parameters}
+}
+
+// openBrackets returns the round, box and curly brackets that are open at
+// the position, and counts the commas that are directly inside them.
+// Brackets in strings and chars are skipped.
+func (doc *document) openBrackets(at language.Position) []bracket {
+	var open []bracket
+	var quote rune
+	escaped := false
+	for i, line := range doc.lines {
+		if i >= at.Line {
+			break
+		}
+		if i == at.Line-1 {
+			line = doc.before(at)
+		}
+		for j, character := range line {
+			switch {
+			case escaped:
+				escaped = false
+			case quote != 0 && character == '\\':
+				escaped = true
+			case quote != 0:
+				if character == quote {
+					quote = 0
+				}
+			case character == '"' || character == '\'':
+				quote = character
+			case character == '(':
+				open = append(open, bracket{callee: callee(line[:j])})
+			case character == '[' || character == '{':
+				open = append(open, bracket{})
+			case character == ')' || character == ']' || character == '}':
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			case character == ',' && len(open) > 0:
+				open[len(open)-1].commas++
+			}
+		}
+	}
+	return open
+}
+
+// callee returns the name of the function if the text ends with "#name"
+func callee(text []rune) string {
+	end := len(text)
+	for end > 0 && text[end-1] == ' ' {
+		end--
+	}
+	start := end
+	for start > 0 && isWordCharacter(text[start-1]) {
+		start--
+	}
+	hash := start
+	for hash > 0 && text[hash-1] == ' ' {
+		hash--
+	}
+	if start == end || hash == 0 || text[hash-1] != '#' {
+		return ""
+	}
+	return string(text[start:end])
+}
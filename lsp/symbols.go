@@ -0,0 +1,60 @@
+package lsp
+
+import (
+	"callmemaybe/language"
+	"fmt"
+)
+
+// definition returns where the identifier at the cursor is declared, or
+// nothing if it is not declared in the document
+func (server *server) definition(params textDocumentPositionParams) interface{} {
+	doc, ok := server.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	occurrence, ok := doc.analysis.Definition(doc.fromProtocol(params.Position))
+	if !ok {
+		return nil
+	}
+	return location{URI: params.TextDocument.URI, Range: doc.occurrenceRange(occurrence)}
+}
+
+// references returns where the identifier at the cursor is used, and where
+// it is declared if the client asks for that
+func (server *server) references(params referenceParams) []location {
+	locations := []location{}
+	doc, ok := server.documents[params.TextDocument.URI]
+	if !ok {
+		return locations
+	}
+	for _, occurrence := range doc.analysis.References(doc.fromProtocol(params.Position)) {
+		if occurrence.Declaration && !params.Context.IncludeDeclaration {
+			continue
+		}
+		locations = append(locations, location{URI: params.TextDocument.URI, Range: doc.occurrenceRange(occurrence)})
+	}
+	return locations
+}
+
+// rename replaces every occurrence of the identifier at the cursor
+func (server *server) rename(params renameParams) (workspaceEdit, error) {
+	doc, ok := server.documents[params.TextDocument.URI]
+	if !ok {
+		return workspaceEdit{}, fmt.Errorf("%s is not open", params.TextDocument.URI)
+	}
+	occurrences, err := doc.analysis.Rename(doc.fromProtocol(params.Position), params.NewName)
+	if err != nil {
+		return workspaceEdit{}, err
+	}
+	edits := []textEdit{}
+	for _, occurrence := range occurrences {
+		edits = append(edits, textEdit{Range: doc.occurrenceRange(occurrence), NewText: params.NewName})
+	}
+	return workspaceEdit{Changes: map[string][]textEdit{params.TextDocument.URI: edits}}, nil
+}
+
+func (doc *document) occurrenceRange(occurrence language.Occurrence) textRange {
+	end := occurrence.Position
+	end.Column += len([]rune(occurrence.Name))
+	return textRange{Start: doc.toProtocol(occurrence.Position), End: doc.toProtocol(end)}
+}
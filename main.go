@@ -1,53 +1,139 @@
 package main
 
 import (
+	"bufio"
+	"callmemaybe/language"
+	"callmemaybe/lsp"
+	"callmemaybe/utils"
 	"fmt"
 	"github.com/alecthomas/kong"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"callmemaybe/utils"
 )
 
 type Arguments struct {
 	Build Build `cmd:"build"`
 	X86   X86   `cmd:"x86"`
+	Run   Run   `cmd:"run"`
+	Repl  Repl  `cmd:"repl"`
+	Exec  Exec  `cmd:"exec"`
+	Lsp   Lsp   `cmd:"lsp" help:"Run a language server on stdin and stdout."`
+	Fmt   Fmt   `cmd:"fmt" help:"Format source files."`
 }
 
 type Build struct {
-	File string `arg:"" type:"path"`
+	File         string   `arg:"" type:"path"`
+	Optimize     bool     `short:"O" help:"Run the peephole optimiser on the generated assembly."`
+	Registers    bool     `help:"Keep local variables and temporaries in registers instead of on the stack."`
+	Link         []string `type:"path" help:"Object files or archives to link with."`
+	Libraries    []string `short:"l" name:"library" help:"Libraries to link with, like -l m for libm."`
+	Lib          bool     `help:"Build a static library libout.a with the exported functions, and a C header out.h."`
+	Shared       bool     `help:"Build a shared library libout.so instead of a static library."`
+	Nasm         bool     `help:"Assemble with nasm instead of the built-in encoder."`
+	Freestanding bool     `help:"Build a static executable without libc, which only uses Linux system calls."`
+	Backend      string   `enum:"x86,c,bytecode" default:"x86" help:"Generate x86-64 directly, translate to C and compile it with gcc, or compile to bytecode in out.cmmb."`
 }
 
 type X86 struct {
+	File      string `arg:"" type:"path"`
+	Optimize  bool   `short:"O" help:"Run the peephole optimiser on the generated assembly."`
+	Registers bool   `help:"Keep local variables and temporaries in registers instead of on the stack."`
+}
+
+type Run struct {
 	File string `arg:"" type:"path"`
 }
 
+type Repl struct{}
+
+type Exec struct {
+	File string `arg:"" type:"path"`
+}
+
+type Lsp struct{}
+
+type Fmt struct {
+	Files []string `arg:"" type:"path"`
+	Write bool     `short:"w" help:"Write the formatted source back to the files instead of printing it."`
+	Check bool     `name:"check" short:"c" help:"List the files that are not formatted, and fail if there are any. Can also be written -check."`
+}
+
 func (build *Build) Run() error {
-	nasmTemp := "out.nasm"
 	oTemp := "out.o"
 	content, err := utils.ReadFile(build.File)
 	if err != nil {
 		return err
 	}
-	nasm, err := utils.Compile(content)
+	options := utils.Options{Optimize: build.Optimize, Registers: build.Registers, Nasm: build.Nasm, Freestanding: build.Freestanding}
+	if build.Backend == "c" {
+		if build.Lib || build.Shared || build.Freestanding {
+			return fmt.Errorf("the C backend can only build executables that use libc")
+		}
+		options.Backend = utils.BackendC
+	}
+	if build.Backend == "bytecode" {
+		if build.Lib || build.Shared || build.Freestanding {
+			return fmt.Errorf("bytecode can only be built for programs")
+		}
+		return build.bytecode(content)
+	}
+	if build.Lib || build.Shared {
+		if build.Freestanding {
+			return fmt.Errorf("libraries can not be freestanding")
+		}
+		return build.library(content, options)
+	}
+	err = utils.CompileObject(content, oTemp, options)
 	if err != nil {
 		println(err.Error())
 		return nil
 	}
-	err = utils.WriteFile(nasmTemp, nasm)
+	if build.Freestanding {
+		err = utils.LinkFreestanding(oTemp, utils.LinkFlags(build.Link, build.Libraries)...)
+	} else {
+		err = utils.Link(oTemp, utils.LinkFlags(build.Link, build.Libraries)...)
+	}
 	if err != nil {
-		return err
+		println(err.Error())
+		return nil
 	}
-	_, err = exec.Command("nasm", "-f", "elf64", nasmTemp).CombinedOutput()
+	os.Remove(oTemp)
+	return nil
+}
+
+// bytecode compiles the program to bytecode in out.cmmb, which can be run
+// with cmm exec
+func (build *Build) bytecode(content string) error {
+	compiled, err := utils.CompileBytecode(content)
+	if err != nil {
+		println(err.Error())
+		return nil
+	}
+	return ioutil.WriteFile("out.cmmb", compiled, 0644)
+}
+
+// library builds the exported functions of the program into a library,
+// where the top-level code runs when the library is loaded
+func (build *Build) library(content string, options utils.Options) error {
+	oTemp := "out.o"
+	header, err := utils.CompileLibrary(content, oTemp, "out", options)
 	if err != nil {
 		println(err.Error())
 		return nil
 	}
-	_, err = exec.Command("gcc", "-no-pie", "-o", "out", oTemp, "-lc").CombinedOutput()
+	err = utils.WriteFile("out.h", header)
+	if err != nil {
+		return err
+	}
+	if build.Shared {
+		err = utils.LinkShared(oTemp, "libout.so", utils.LinkFlags(build.Link, build.Libraries)...)
+	} else {
+		err = utils.Archive("libout.a", append([]string{oTemp}, build.Link...)...)
+	}
 	if err != nil {
 		println(err.Error())
 		return nil
 	}
-	os.Remove(nasmTemp)
 	os.Remove(oTemp)
 	return nil
 }
@@ -57,7 +143,7 @@ func (args *X86) Run() error {
 	if err != nil {
 		return err
 	}
-	nasm, err := utils.Compile(content)
+	nasm, err := utils.Compile(content, utils.Options{Optimize: args.Optimize, Registers: args.Registers})
 	if err != nil {
 		return nil
 	}
@@ -65,7 +151,93 @@ func (args *X86) Run() error {
 	return nil
 }
 
+func (args *Run) Run() error {
+	content, err := utils.ReadFile(args.File)
+	if err != nil {
+		return err
+	}
+	stdout := bufio.NewWriter(os.Stdout)
+	err = utils.Interpret(content, stdout)
+	stdout.Flush()
+	return err
+}
+
+func (args *Repl) Run() error {
+	return utils.Repl(os.Stdin, os.Stdout)
+}
+
+func (args *Exec) Run() error {
+	compiled, err := ioutil.ReadFile(args.File)
+	if err != nil {
+		return err
+	}
+	return utils.Exec(compiled, os.Stdout)
+}
+
+func (args *Lsp) Run() error {
+	return lsp.Serve(os.Stdin, os.Stdout)
+}
+
+// Run prints the formatted files, writes them back with -w, or checks that
+// they are formatted with --check
+func (args *Fmt) Run() error {
+	unformatted := 0
+	for _, file := range args.Files {
+		content, err := utils.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		formatted, err := language.Format(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		switch {
+		case args.Check:
+			if formatted != content {
+				fmt.Println(file)
+				unformatted++
+			}
+		case args.Write:
+			if formatted != content {
+				err = utils.WriteFile(file, formatted)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("%d of %d files are not formatted", unformatted, len(args.Files))
+	}
+	return nil
+}
+
+// singleDashFlags are long flags of fmt that are also accepted with a single
+// dash, like the flags of gofmt
+var singleDashFlags = map[string]string{
+	"-check": "--check",
+}
+
+// rewriteSingleDashFlags replaces the single dash flags of fmt with their
+// long form. Other commands are left alone, and so are file names after --.
+func rewriteSingleDashFlags(args []string) {
+	if len(args) < 2 || args[1] != "fmt" {
+		return
+	}
+	for i, argument := range args[2:] {
+		if argument == "--" {
+			return
+		}
+		if flag, ok := singleDashFlags[argument]; ok {
+			args[i+2] = flag
+		}
+	}
+}
+
 func main() {
+	rewriteSingleDashFlags(os.Args)
 	var arguments Arguments
 	ctx := kong.Parse(&arguments)
 	err := ctx.Run()
@@ -0,0 +1,50 @@
+package test
+
+import (
+	"callmemaybe/utils"
+	"os"
+	"testing"
+)
+
+// benchmarkProgram compiles the program once, and measures how long the
+// executable takes to run
+func benchmarkProgram(b *testing.B, path string, output string, options utils.Options) {
+	defer os.Remove("out")
+	defer os.Remove("out.o")
+
+	program, err := utils.ReadFile(path)
+	if err != nil {
+		b.Fatalf("failed to read file: %v", err)
+	}
+	err = utils.CompileObject(program, "out.o", options)
+	if err != nil {
+		b.Fatalf("failed to compile: %v", err)
+	}
+	err = utils.Link("./out.o")
+	if err != nil {
+		b.Fatalf("failed to link: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stdout, err := utils.RunExecutable("out")
+		if err != nil {
+			b.Fatalf("failed to run executable: %v", err)
+		}
+		if stdout != output {
+			b.Fatalf("got:\n%s\nexpected:\n%s\n", stdout, output)
+		}
+	}
+}
+
+func BenchmarkFibonacciOnStack(b *testing.B) {
+	benchmarkProgram(b, "benchmarks/fibonacci.cmm", "390626\n", utils.Options{Optimize: true})
+}
+
+func BenchmarkFibonacciInRegisters(b *testing.B) {
+	benchmarkProgram(b, "benchmarks/fibonacci.cmm", "390626\n", utils.Options{Optimize: true, Registers: true})
+}
+
+func BenchmarkFibonacciInC(b *testing.B) {
+	benchmarkProgram(b, "benchmarks/fibonacci.cmm", "390626\n", utils.Options{Optimize: true, Backend: utils.BackendC})
+}
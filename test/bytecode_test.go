@@ -0,0 +1,73 @@
+package test
+
+import (
+	"bytes"
+	"callmemaybe/language/bytecode"
+	"callmemaybe/utils"
+	"testing"
+)
+
+func compileBytecode(t *testing.T, program string) []byte {
+	compiled, err := utils.CompileBytecode(program)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	return compiled
+}
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	compiled := compileBytecode(t, "fn twice(n int) int {\n    return n * 2\n}\nprintln #twice(21)\nprintln \"done\"\n")
+	program, err := bytecode.Decode(compiled)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if !bytes.Equal(program.Encode(), compiled) {
+		t.Errorf("encoding the decoded program changed it")
+	}
+
+	var stdout bytes.Buffer
+	err = utils.Exec(compiled, &stdout)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if stdout.String() != "42\ndone\n" {
+		t.Errorf("got:\n%s", stdout.String())
+	}
+}
+
+func TestBytecodeRejectsOtherVersions(t *testing.T) {
+	compiled := compileBytecode(t, "println 1\n")
+	compiled[len(bytecode.Magic)] = byte(2 * (bytecode.Version + 1))
+	_, err := bytecode.Decode(compiled)
+	if err == nil {
+		t.Errorf("decoded bytecode with another version")
+	}
+}
+
+func TestBytecodeRejectsOtherFiles(t *testing.T) {
+	_, err := bytecode.Decode([]byte("\x7fELF"))
+	if err == nil {
+		t.Errorf("decoded a file that is not bytecode")
+	}
+}
+
+func TestBytecodeRejectsTruncatedFiles(t *testing.T) {
+	compiled := compileBytecode(t, "x = 5\nprintln x\n")
+	for length := len(bytecode.Magic); length < len(compiled); length++ {
+		_, err := bytecode.Decode(compiled[:length])
+		if err == nil {
+			t.Errorf("decoded bytecode that was truncated to %d bytes", length)
+		}
+	}
+}
+
+func TestBytecodeRejectsInvalidOperands(t *testing.T) {
+	program := &bytecode.Program{}
+	main := program.Functions[program.AddFunction("main")]
+	main.Emit(bytecode.OpLoad, 3)
+	main.Emit(bytecode.OpReturn)
+	_, err := bytecode.Decode(program.Encode())
+	if err == nil {
+		t.Errorf("decoded a load of a local that does not exist")
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"bytes"
+	"callmemaybe/language/assemblyoutput"
+	"debug/elf"
+	"encoding/hex"
+	"testing"
+)
+
+// encodedExpected encodes the instructions in main, and compares the machine
+// code with the output of the GNU assembler for the same instructions
+func encodedExpected(t *testing.T, build func(ao *assemblyoutput.AssemblyOutput), expected string) {
+	ao := assemblyoutput.NewAssemblyOutput()
+	ao.NewSection("main")
+	build(ao)
+	object, err := ao.Elf()
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	file, err := elf.NewFile(bytes.NewReader(object))
+	if err != nil {
+		t.Fatalf("invalid object file: %v", err)
+	}
+	text, err := file.Section(".text").Data()
+	if err != nil {
+		t.Fatalf("failed to read .text: %v", err)
+	}
+	if hex.EncodeToString(text) != expected {
+		t.Errorf("got %s, expected %s", hex.EncodeToString(text), expected)
+	}
+}
+
+func TestEncodePushAndPop(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Push(assemblyoutput.RBP)
+		ao.Push(assemblyoutput.R12)
+		ao.Pop(assemblyoutput.R15)
+		ao.Push(assemblyoutput.Memory{Size: "qword", Base: assemblyoutput.RSP})
+		ao.Push(assemblyoutput.Memory{Size: "qword", Base: assemblyoutput.R12, Displacement: -200})
+	}, "554154415fff342441ffb42438ffffff")
+}
+
+func TestEncodeMov(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Mov(assemblyoutput.RBP, assemblyoutput.RSP)
+		ao.Mov(assemblyoutput.FrameAddress(-8), assemblyoutput.RBX)
+		ao.Mov(assemblyoutput.R13, assemblyoutput.FrameAddress(16))
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Memory{Base: assemblyoutput.RDX, Index: assemblyoutput.RCX, Scale: 8, Displacement: 8})
+		ao.Mov(assemblyoutput.RSP, assemblyoutput.StackAddress(8))
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Immediate(5))
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Immediate(0x123456789))
+		ao.Mov(assemblyoutput.R11, assemblyoutput.RAX)
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Memory{Base: assemblyoutput.R11, Displacement: 8})
+	}, "4889e548895df84c8b6d10488b44ca08488b64240848c7c00500000048b889674523010000004989c3498b4308")
+}
+
+func TestEncodeBytes(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Mov(assemblyoutput.Memory{Size: "byte", Base: assemblyoutput.RSI, Index: assemblyoutput.RCX, Scale: 1}, assemblyoutput.AL)
+		ao.Mov(assemblyoutput.Memory{Size: "byte", Base: assemblyoutput.RSI, Index: assemblyoutput.RCX, Scale: 1}, assemblyoutput.Immediate(0))
+		ao.Movzx(assemblyoutput.RDI, assemblyoutput.Memory{Size: "byte", Base: assemblyoutput.RDX, Index: assemblyoutput.RSI, Scale: 1})
+		ao.Cmp(assemblyoutput.Memory{Size: "byte", Base: assemblyoutput.RAX, Index: assemblyoutput.RCX, Scale: 1}, assemblyoutput.Immediate(0))
+	}, "88040ec6040e00480fb63c32803c0800")
+}
+
+func TestEncodeArithmetic(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.And(assemblyoutput.RSP, assemblyoutput.Immediate(-16))
+		ao.Sub(assemblyoutput.RSP, assemblyoutput.Immediate(1024))
+		ao.Add(assemblyoutput.RAX, assemblyoutput.RBX)
+		ao.Cmp(assemblyoutput.RCX, assemblyoutput.Memory{Base: assemblyoutput.RDX})
+		ao.Imul(assemblyoutput.RAX, assemblyoutput.RBX)
+		ao.Imul(assemblyoutput.RDI, assemblyoutput.Immediate(8))
+		ao.Div(assemblyoutput.RCX)
+		ao.Xor(assemblyoutput.RAX, assemblyoutput.RAX)
+	}, "4883e4f04881ec000400004801d8483b0a480fafc3486bff0848f7f14831c0")
+}
+
+func TestEncodeJumpsAndCalls(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.NewSection("start")
+		ao.Call(assemblyoutput.Memory{Base: assemblyoutput.RBX, Displacement: 16})
+		ao.Je("start")
+		ao.Jmp("end")
+		ao.NewSection("end")
+		ao.Ret()
+	}, "ff53100f84f7ffffffe900000000c3")
+}
+
+func TestEncodeShiftsAndSystemCalls(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Shl(assemblyoutput.RAX, 32)
+		ao.Sar(assemblyoutput.RAX, 32)
+		ao.Neg(assemblyoutput.RAX)
+		ao.Syscall()
+		ao.Mov(assemblyoutput.R10, assemblyoutput.Immediate(0x22))
+	}, "48c1e02048c1f82048f7d80f0549c7c222000000")
+}
+
+func TestEncodeRefusesRedefinedLabels(t *testing.T) {
+	ao := assemblyoutput.NewAssemblyOutput()
+	ao.NewSection("main")
+	ao.NewSection("twice")
+	ao.Ret()
+	ao.NewSection("twice")
+	ao.Ret()
+	_, err := ao.Elf()
+	if err == nil {
+		t.Errorf("expected an error for a redefined label")
+	}
+
+	ao = assemblyoutput.NewAssemblyOutput()
+	ao.NewSection("main")
+	ao.Call(assemblyoutput.Label("main"))
+	ao.PushProcedure("main")
+	ao.Ret()
+	ao.PopProcedure()
+	_, err = ao.Elf()
+	if err == nil {
+		t.Errorf("expected an error for a procedure with the name of a label")
+	}
+}
+
+func TestEncodeIndexChecks(t *testing.T) {
+	encodedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Cmp(assemblyoutput.RCX, assemblyoutput.Memory{Size: "qword", Base: assemblyoutput.RDX})
+		ao.Ud2()
+	}, "483b0a0f0b")
+}
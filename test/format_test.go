@@ -0,0 +1,106 @@
+package test
+
+import (
+	"callmemaybe/language"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func formatExpected(t *testing.T, program string, expected string) {
+	formatted, err := language.Format(program)
+	if err != nil {
+		t.Fatalf("failed to format: %v", err)
+	}
+	if formatted != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", formatted, expected)
+	}
+}
+
+func parseWithoutPositions(program string) (language.Stmt, error) {
+	return language.NewParser(strings.NewReader(program)).Parse()
+}
+
+func TestFormatLayout(t *testing.T) {
+	program := "add2 = | x int |int{\nreturn x+2\n}\nl=<int,3>[1,2 ,#add2(1)]\nstruct Point{x int\n  y char}\np=@Point{x:?l[0]   y:'a'}\n?p.x=-?p.x*2\n"
+	expected := "add2 = |x int| int {\n    return x + 2\n}\nl = <int, 3>[1, 2, #add2(1)]\nstruct Point {\n    x int\n    y char\n}\np = @Point{\n    x: ?l[0]\n    y: 'a'\n}\n?p.x = -?p.x * 2\n"
+	formatExpected(t, program, expected)
+}
+
+func TestFormatDeclarations(t *testing.T) {
+	program := "extern fn abs(n int)int\nexport  fn f( a int,b func<int,int> ) (int,bool) {\n  if some x = none {\n  }\n  loop false { return 1,true }\n  return (2,   false)\n}\nconst q , _ = #f(1, |me,n int|int{return n})\ng: func = ||{}\n"
+	expected := "extern fn abs(n int) int\nexport fn f(a int, b func<int, int>) (int, bool) {\n    if some x = none {\n    }\n    loop false {\n        return 1, true\n    }\n    return 2, false\n}\nconst q, _ = #f(1, |me, n int| int {\n    return n\n})\ng: func = || {\n}\n"
+	formatExpected(t, program, expected)
+}
+
+func TestFormatKeepsStringsAndBlankLines(t *testing.T) {
+	program := "s = \"say \\\"hi\\\" \\\\\"\n\n\n\nc = <char, 2>['\\'', '\\\\']\nprintln len(s)\n\nprintln some(c)\n"
+	expected := "s = \"say \\\"hi\\\" \\\\\"\n\nc = <char, 2>['\\'', '\\\\']\nprintln len(s)\n\nprintln some(c)\n"
+	formatExpected(t, program, expected)
+}
+
+func TestFormatFailsOnParseErrors(t *testing.T) {
+	_, err := language.Format("x = )\n")
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// TestFormatTestcases checks that formatting every test program that parses
+// keeps its syntax tree, and that formatting it again changes nothing
+func TestFormatTestcases(t *testing.T) {
+	files, err := filepath.Glob("testcases/*.cmm")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testcases: %v", err)
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, err := parseWithoutPositions(string(content))
+		if err != nil {
+			continue
+		}
+		formatted, err := language.Format(string(content))
+		if err != nil {
+			t.Errorf("%s: failed to format: %v", file, err)
+			continue
+		}
+		reparsed, err := parseWithoutPositions(formatted)
+		if err != nil {
+			t.Errorf("%s: the formatted program does not parse: %v\n%s", file, err, formatted)
+			continue
+		}
+		if !reflect.DeepEqual(original, reparsed) {
+			t.Errorf("%s: formatting changed the syntax tree:\n%s", file, formatted)
+		}
+		again, err := language.Format(formatted)
+		if err != nil || again != formatted {
+			t.Errorf("%s: formatting is not idempotent:\n%s\nthen:\n%s", file, formatted, again)
+		}
+	}
+}
+
+func TestFormatKeepsComments(t *testing.T) {
+	program := "// header\n\n\nx = 1   // one\n// about y\n\ny = 2\nif x == 1 { // check\n    // inside\n  println x\n  // end of block\n}\nz = /* kept */ 3\nstruct P {\n  // first\n  a int /* a */\n\n  b int\n}\n// the end"
+	expected := "// header\n\nx = 1 // one\n// about y\n\ny = 2\nif x == 1 { // check\n    // inside\n    println x\n    // end of block\n}\nz = /* kept */ 3\nstruct P {\n    // first\n    a int /* a */\n\n    b int\n}\n// the end\n"
+	formatExpected(t, program, expected)
+	formatExpected(t, expected, expected)
+}
+
+func TestFormatKeepsCommentsInsideStatements(t *testing.T) {
+	program := "x = 1 /* left */ +  /* right */ 2\ny = #f(1,/* arg */2) // end\nif x  /* cond */ == 3 { // open\n  println x\n}\nz = 1 // split\n + 2\n"
+	expected := "x = 1 /* left */ + /* right */ 2\ny = #f(1, /* arg */ 2) // end\nif x /* cond */ == 3 { // open\n    println x\n}\nz = 1 // split\n    + 2\n"
+	formatExpected(t, program, expected)
+	formatExpected(t, expected, expected)
+}
+
+func TestFormatKeepsCommentsAfterOpeningBrackets(t *testing.T) {
+	program := "if x == 1 /* before */ { /* after */ /* and */ println x }\nf = |a int| int { // body\n  return a\n}\n"
+	expected := "if x == 1 /* before */ { /* after */ /* and */\n    println x\n}\nf = |a int| int { // body\n    return a\n}\n"
+	formatExpected(t, program, expected)
+	formatExpected(t, expected, expected)
+}
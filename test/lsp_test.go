@@ -0,0 +1,463 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"callmemaybe/lsp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type lspMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+type lspDiagnostics struct {
+	URI         string `json:"uri"`
+	Diagnostics []struct {
+		Range struct {
+			Start struct{ Line, Character int }
+			End   struct{ Line, Character int }
+		}
+		Message string
+	}
+}
+
+type lspHover struct {
+	Contents struct {
+		Value string
+	}
+}
+
+func lspRequest(id int, method string, params string) string {
+	content := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":%s}`, id, method, params)
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func lspNotification(method string, params string) string {
+	content := fmt.Sprintf(`{"jsonrpc":"2.0","method":"%s","params":%s}`, method, params)
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func lspOpen(text string) string {
+	quoted, _ := json.Marshal(text)
+	return lspNotification("textDocument/didOpen", fmt.Sprintf(`{"textDocument":{"uri":"file:///a.cmm","languageId":"callmemaybe","version":1,"text":%s}}`, quoted))
+}
+
+func lspHoverAt(id int, line int, character int) string {
+	return lspRequest(id, "textDocument/hover", fmt.Sprintf(`{"textDocument":{"uri":"file:///a.cmm"},"position":{"line":%d,"character":%d}}`, line, character))
+}
+
+// runLsp sends the messages to the server, followed by shutdown and exit,
+// and returns the messages it wrote
+func runLsp(t *testing.T, messages ...string) []lspMessage {
+	input := lspRequest(0, "initialize", `{"capabilities":{}}`) + strings.Join(messages, "") +
+		lspRequest(1000, "shutdown", "null") + lspNotification("exit", "null")
+	var output bytes.Buffer
+	err := lsp.Serve(strings.NewReader(input), &output)
+	if err != nil {
+		t.Fatalf("server failed: %v", err)
+	}
+	var written []lspMessage
+	reader := bufio.NewReader(&output)
+	for {
+		headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid headers: %v", err)
+		}
+		length, _ := strconv.Atoi(headers.Get("Content-Length"))
+		content, err := ioutil.ReadAll(io.LimitReader(reader, int64(length)))
+		if err != nil || len(content) != length {
+			t.Fatalf("truncated message")
+		}
+		var message lspMessage
+		err = json.Unmarshal(content, &message)
+		if err != nil {
+			t.Fatalf("invalid message %s: %v", content, err)
+		}
+		written = append(written, message)
+	}
+	return written
+}
+
+func lspPublished(t *testing.T, messages []lspMessage) []lspDiagnostics {
+	var published []lspDiagnostics
+	for _, message := range messages {
+		if message.Method == "textDocument/publishDiagnostics" {
+			var diagnostics lspDiagnostics
+			err := json.Unmarshal(message.Params, &diagnostics)
+			if err != nil {
+				t.Fatalf("invalid diagnostics: %v", err)
+			}
+			published = append(published, diagnostics)
+		}
+	}
+	return published
+}
+
+func lspResult(t *testing.T, messages []lspMessage, id int) json.RawMessage {
+	for _, message := range messages {
+		if message.ID != nil && *message.ID == id {
+			return message.Result
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+	return nil
+}
+
+func hoverExpected(t *testing.T, messages []lspMessage, id int, expected string) {
+	result := lspResult(t, messages, id)
+	if expected == "" {
+		if string(result) != "null" {
+			t.Errorf("expected no hover for request %d, got %s", id, result)
+		}
+		return
+	}
+	var hover lspHover
+	err := json.Unmarshal(result, &hover)
+	if err != nil {
+		t.Fatalf("invalid hover: %v", err)
+	}
+	if hover.Contents.Value != "```callmemaybe\n"+expected+"\n```" {
+		t.Errorf("hover %d got:\n%s\nexpected:\n%s", id, hover.Contents.Value, expected)
+	}
+}
+
+func TestLspPublishesParseErrors(t *testing.T) {
+	messages := runLsp(t, lspOpen("x = 1\ny = )\n"))
+	published := lspPublished(t, messages)
+	if len(published) != 1 || len(published[0].Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", published)
+	}
+	diagnostic := published[0].Diagnostics[0]
+	if diagnostic.Range.Start.Line != 1 || diagnostic.Range.Start.Character != 4 || diagnostic.Range.End.Character != 5 {
+		t.Errorf("diagnostic is at the wrong place: %+v", diagnostic.Range)
+	}
+}
+
+func TestLspPublishesTypeErrors(t *testing.T) {
+	change := lspNotification("textDocument/didChange", `{"textDocument":{"uri":"file:///a.cmm","version":2},"contentChanges":[{"text":"x = 1\n"}]}`)
+	messages := runLsp(t, lspOpen("x = 1\n\nx = 'c'\n"), change)
+	published := lspPublished(t, messages)
+	if len(published) != 2 {
+		t.Fatalf("expected diagnostics to be published twice, got %v", published)
+	}
+	if len(published[0].Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", published[0].Diagnostics)
+	}
+	diagnostic := published[0].Diagnostics[0]
+	if diagnostic.Range.Start.Line != 2 || diagnostic.Range.Start.Character != 0 || diagnostic.Range.End.Character != 7 {
+		t.Errorf("diagnostic is at the wrong place: %+v", diagnostic.Range)
+	}
+	if diagnostic.Message != "can not assign char to x of type int" {
+		t.Errorf("unexpected message: %s", diagnostic.Message)
+	}
+	if len(published[1].Diagnostics) != 0 {
+		t.Errorf("expected the diagnostics to be cleared, got %v", published[1].Diagnostics)
+	}
+}
+
+func TestLspPublishesDivisionByZero(t *testing.T) {
+	messages := runLsp(t, lspOpen("x = 2\nprintln x + 4 / 0\n"))
+	published := lspPublished(t, messages)
+	if len(published) != 1 || len(published[0].Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", published)
+	}
+	diagnostic := published[0].Diagnostics[0]
+	if diagnostic.Range.Start.Line != 1 || diagnostic.Range.Start.Character != 14 {
+		t.Errorf("diagnostic is at the wrong place: %+v", diagnostic.Range)
+	}
+	if diagnostic.Message != "division by zero" {
+		t.Errorf("unexpected message: %s", diagnostic.Message)
+	}
+}
+
+func TestLspPublishesReservedExports(t *testing.T) {
+	messages := runLsp(t, lspOpen("println 3\nexport fn flushOutput() {\n}\n"))
+	published := lspPublished(t, messages)
+	if len(published) != 1 || len(published[0].Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", published)
+	}
+	diagnostic := published[0].Diagnostics[0]
+	if diagnostic.Range.Start.Line != 1 || diagnostic.Range.Start.Character != 0 {
+		t.Errorf("diagnostic is at the wrong place: %+v", diagnostic.Range)
+	}
+	if diagnostic.Message != "exported function flushOutput clashes with a symbol of the runtime" {
+		t.Errorf("unexpected message: %s", diagnostic.Message)
+	}
+}
+
+func TestLspHover(t *testing.T) {
+	program := "struct Point {\n    x int\n}\nfn twice(n int) int {\n    return n * 2\n}\ntotal = #twice(21)\np = @Point{x: total}\nprintln ?p.x\n"
+	messages := runLsp(t,
+		lspOpen(program),
+		lspHoverAt(1, 6, 2),
+		lspHoverAt(2, 3, 4),
+		lspHoverAt(3, 4, 11),
+		lspHoverAt(4, 6, 8),
+		lspHoverAt(5, 8, 11),
+		lspHoverAt(6, 7, 0),
+		lspHoverAt(7, 6, 6),
+		lspHoverAt(8, 1, 4),
+	)
+	hoverExpected(t, messages, 1, "total: int")
+	hoverExpected(t, messages, 2, "twice: func<int, int>")
+	hoverExpected(t, messages, 3, "n: int")
+	hoverExpected(t, messages, 4, "int")
+	hoverExpected(t, messages, 5, "int")
+	hoverExpected(t, messages, 6, "p: @Point")
+	hoverExpected(t, messages, 7, "")
+	hoverExpected(t, messages, 8, "x: int")
+}
+
+func TestLspRejectsUnknownRequests(t *testing.T) {
+	messages := runLsp(t, lspRequest(1, "textDocument/unknown", "{}"))
+	for _, message := range messages {
+		if message.ID != nil && *message.ID == 1 {
+			if message.Error == nil || message.Error.Code != -32601 {
+				t.Errorf("expected method not found, got %+v", message)
+			}
+			return
+		}
+	}
+	t.Errorf("no response to the unknown request")
+}
+
+type lspCompletionItem struct {
+	Label  string
+	Kind   int
+	Detail string
+}
+
+func lspCompletionAt(id int, line int, character int) string {
+	return lspRequest(id, "textDocument/completion", fmt.Sprintf(`{"textDocument":{"uri":"file:///a.cmm"},"position":{"line":%d,"character":%d}}`, line, character))
+}
+
+func completions(t *testing.T, program string, line int, character int) map[string]lspCompletionItem {
+	messages := runLsp(t, lspOpen(program), lspCompletionAt(1, line, character))
+	var items []lspCompletionItem
+	err := json.Unmarshal(lspResult(t, messages, 1), &items)
+	if err != nil {
+		t.Fatalf("invalid completion: %v", err)
+	}
+	found := make(map[string]lspCompletionItem)
+	for _, item := range items {
+		found[item.Label] = item
+	}
+	return found
+}
+
+func TestLspCompletesVariablesInScope(t *testing.T) {
+	program := "fn twice(n int) int {\n    m = n\n    \n    return m * 2\n}\ncount = 3\nx = co"
+	found := completions(t, program, 6, 6)
+	if found["count"] != (lspCompletionItem{Label: "count", Kind: 6, Detail: "int"}) {
+		t.Errorf("count is missing: %v", found["count"])
+	}
+	if found["twice"] != (lspCompletionItem{Label: "twice", Kind: 3, Detail: "func<int, int>"}) {
+		t.Errorf("twice is missing: %v", found["twice"])
+	}
+	if _, ok := found["println"]; !ok {
+		t.Errorf("keywords are missing")
+	}
+	if _, ok := found["n"]; ok {
+		t.Errorf("the argument of twice is not visible outside of it")
+	}
+
+	found = completions(t, program, 2, 4)
+	if _, ok := found["n"]; !ok {
+		t.Errorf("the argument is missing in the body")
+	}
+	if _, ok := found["m"]; !ok {
+		t.Errorf("the variable is missing in the body")
+	}
+	if _, ok := found["count"]; ok {
+		t.Errorf("variables declared outside of the function are not visible in it")
+	}
+}
+
+func TestLspCompletesStructs(t *testing.T) {
+	program := "struct Point {\n    x int\n    y char\n}\nstruct Line {\n    from @Point\n}\nl = @Line{from: @Point{x: 1 y: 'a'}}\n"
+	found := completions(t, program+"println ?l.from.\n", 8, 16)
+	if len(found) != 2 || found["x"].Detail != "int" || found["y"].Detail != "char" || found["x"].Kind != 5 {
+		t.Errorf("expected the members of Point, got %v", found)
+	}
+	found = completions(t, program+"q = @", 8, 5)
+	if len(found) != 2 || found["Point"].Kind != 22 || found["Line"].Kind != 22 {
+		t.Errorf("expected the structs, got %v", found)
+	}
+}
+
+func TestLspSignatureHelp(t *testing.T) {
+	program := "fn add(a int, b int) int {\n    return a + b\n}\nx = #add(1, (2 + 3), \"(,\""
+	help := func(id int, character int) string {
+		return lspRequest(id, "textDocument/signatureHelp", fmt.Sprintf(`{"textDocument":{"uri":"file:///a.cmm"},"position":{"line":3,"character":%d}}`, character))
+	}
+	messages := runLsp(t, lspOpen(program), help(1, 9), help(2, 26), help(3, 3))
+	var signature struct {
+		Signatures []struct {
+			Label      string
+			Parameters []struct{ Label [2]int }
+		}
+		ActiveParameter int
+	}
+	err := json.Unmarshal(lspResult(t, messages, 1), &signature)
+	if err != nil || len(signature.Signatures) != 1 {
+		t.Fatalf("expected a signature, got %s", lspResult(t, messages, 1))
+	}
+	if signature.Signatures[0].Label != "add(a int, b int) int" || signature.ActiveParameter != 0 {
+		t.Errorf("unexpected signature %+v", signature)
+	}
+	if len(signature.Signatures[0].Parameters) != 2 || signature.Signatures[0].Parameters[1].Label != [2]int{11, 16} {
+		t.Errorf("unexpected parameters %+v", signature.Signatures[0].Parameters)
+	}
+	err = json.Unmarshal(lspResult(t, messages, 2), &signature)
+	if err != nil || signature.ActiveParameter != 2 {
+		t.Errorf("expected the third parameter to be active, got %s", lspResult(t, messages, 2))
+	}
+	if string(lspResult(t, messages, 3)) != "null" {
+		t.Errorf("expected no signature outside of the call")
+	}
+}
+
+type lspLocation struct {
+	URI   string
+	Range struct {
+		Start struct{ Line, Character int }
+		End   struct{ Line, Character int }
+	}
+}
+
+func lspPositionParams(line int, character int, extra string) string {
+	return fmt.Sprintf(`{"textDocument":{"uri":"file:///a.cmm"},"position":{"line":%d,"character":%d}%s}`, line, character, extra)
+}
+
+// starts returns where the locations start, as in "1:4"
+func starts(locations []lspLocation) string {
+	var found []string
+	for _, location := range locations {
+		found = append(found, fmt.Sprintf("%d:%d", location.Range.Start.Line, location.Range.Start.Character))
+	}
+	return strings.Join(found, " ")
+}
+
+const symbolsProgram = "struct Point {\n    x int\n}\nfn norm(p @Point) int {\n    return ?p.x\n}\np = @Point{x: 1}\n?p.x = #norm(p) + 1\nprintln ?p.x\n"
+
+func TestLspDefinition(t *testing.T) {
+	messages := runLsp(t,
+		lspOpen(symbolsProgram),
+		lspRequest(1, "textDocument/definition", lspPositionParams(8, 11, "")),
+		lspRequest(2, "textDocument/definition", lspPositionParams(8, 9, "")),
+		lspRequest(3, "textDocument/definition", lspPositionParams(4, 12, "")),
+		lspRequest(4, "textDocument/definition", lspPositionParams(8, 2, "")),
+	)
+	expected := map[int]string{1: "1:4", 2: "6:0", 3: "3:8"}
+	for id, start := range expected {
+		var location lspLocation
+		err := json.Unmarshal(lspResult(t, messages, id), &location)
+		if err != nil {
+			t.Fatalf("invalid location: %v", err)
+		}
+		if got := starts([]lspLocation{location}); got != start || location.URI != "file:///a.cmm" {
+			t.Errorf("definition %d is at %s in %s, expected %s", id, got, location.URI, start)
+		}
+	}
+	if string(lspResult(t, messages, 4)) != "null" {
+		t.Errorf("expected no definition of a keyword")
+	}
+}
+
+func TestLspReferences(t *testing.T) {
+	messages := runLsp(t,
+		lspOpen(symbolsProgram),
+		lspRequest(1, "textDocument/references", lspPositionParams(6, 11, `,"context":{"includeDeclaration":true}`)),
+		lspRequest(2, "textDocument/references", lspPositionParams(6, 0, `,"context":{"includeDeclaration":false}`)),
+		lspRequest(3, "textDocument/references", lspPositionParams(3, 8, `,"context":{"includeDeclaration":true}`)),
+	)
+	expected := map[int]string{
+		1: "1:4 4:14 6:11 7:3 8:11",
+		2: "7:1 7:13 8:9",
+		3: "3:8 4:12",
+	}
+	for id, locations := range expected {
+		var found []lspLocation
+		err := json.Unmarshal(lspResult(t, messages, id), &found)
+		if err != nil {
+			t.Fatalf("invalid locations: %v", err)
+		}
+		if starts(found) != locations {
+			t.Errorf("references %d are at %s, expected %s", id, starts(found), locations)
+		}
+	}
+}
+
+func TestLspRename(t *testing.T) {
+	rename := func(id int, line int, character int, name string) string {
+		return lspRequest(id, "textDocument/rename", lspPositionParams(line, character, fmt.Sprintf(`,"newName":"%s"`, name)))
+	}
+	messages := runLsp(t,
+		lspOpen(symbolsProgram),
+		rename(1, 4, 14, "y"),
+		rename(2, 6, 0, "q"),
+		rename(3, 6, 0, "norm"),
+		rename(4, 6, 0, "1a"),
+		rename(5, 6, 0, "if"),
+		rename(6, 3, 3, "_"),
+	)
+	expected := map[int]string{
+		1: "1:4 4:14 6:11 7:3 8:11",
+		2: "6:0 7:1 7:13 8:9",
+	}
+	for id, locations := range expected {
+		var edit struct {
+			Changes map[string][]struct {
+				lspLocation
+				NewText string
+			}
+		}
+		err := json.Unmarshal(lspResult(t, messages, id), &edit)
+		if err != nil {
+			t.Fatalf("invalid edit: %v", err)
+		}
+		var found []lspLocation
+		for _, change := range edit.Changes["file:///a.cmm"] {
+			found = append(found, change.lspLocation)
+			if change.Range.End.Character-change.Range.Start.Character != 1 {
+				t.Errorf("rename %d replaces more than the name: %+v", id, change.Range)
+			}
+		}
+		if starts(found) != locations {
+			t.Errorf("rename %d edits %s, expected %s", id, starts(found), locations)
+		}
+	}
+	for _, message := range messages {
+		if message.ID != nil && *message.ID >= 3 && *message.ID <= 6 && message.Error == nil {
+			t.Errorf("expected rename %d to be refused, got %s", *message.ID, message.Result)
+		}
+	}
+}
+
+func TestLspRenameRefusesErrorsAndExterns(t *testing.T) {
+	for _, program := range []string{"x = 1\nx = 'c'\n", "extern fn abs(n int) int\nx = #abs(1)\n"} {
+		rename := lspRequest(1, "textDocument/rename", lspPositionParams(1, 5, `,"newName":"y"`))
+		for _, message := range runLsp(t, lspOpen(program), rename) {
+			if message.ID != nil && *message.ID == 1 && message.Error == nil {
+				t.Errorf("expected the rename in %q to be refused, got %s", program, message.Result)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"callmemaybe/utils"
+	"strings"
 	"testing"
 )
 
@@ -392,3 +393,207 @@ func TestCase96(t *testing.T) {
 func TestCase97(t *testing.T) {
 	utils.AssertProgramOutput("testcases/097.cmm", "1\n", t)
 }
+
+func TestCase98(t *testing.T) {
+	utils.AssertProgramOutput("testcases/098.cmm", "2\n1\n0\n", t)
+}
+
+func TestCase99(t *testing.T) {
+	utils.AssertProgramOutput("testcases/099.cmm", "2\n1\n", t)
+}
+
+func TestCase100(t *testing.T) {
+	utils.AssertCompilerFails("testcases/100.cmm", t)
+}
+
+func TestCase101(t *testing.T) {
+	utils.AssertCompilerFails("testcases/101.cmm", t)
+}
+
+func TestCase102(t *testing.T) {
+	utils.AssertProgramOutput("testcases/102.cmm", "3\n", t)
+}
+
+func TestCase103(t *testing.T) {
+	utils.AssertCompilerFails("testcases/103.cmm", t)
+}
+
+func TestCase104(t *testing.T) {
+	utils.AssertProgramOutput("testcases/104.cmm", "3\n1\n", t)
+}
+
+func TestCase105(t *testing.T) {
+	utils.AssertProgramOutput("testcases/105.cmm", "1\n1\n0\n", t)
+}
+
+func TestCase106(t *testing.T) {
+	utils.AssertCompilerFails("testcases/106.cmm", t)
+}
+
+func TestCase107(t *testing.T) {
+	utils.AssertCompilerFails("testcases/107.cmm", t)
+}
+
+func TestCase108(t *testing.T) {
+	utils.AssertProgramOutput("testcases/108.cmm", "Petter\n22\n", t)
+}
+
+func TestCase109(t *testing.T) {
+	utils.AssertCompilerFails("testcases/109.cmm", t)
+}
+
+func TestCase110(t *testing.T) {
+	utils.AssertCompilerFails("testcases/110.cmm", t)
+}
+
+func TestCase111(t *testing.T) {
+	utils.AssertProgramOutput("testcases/111.cmm", "hello\nhello\n3\n", t)
+}
+
+func TestCase112(t *testing.T) {
+	utils.AssertCompilerFails("testcases/112.cmm", t)
+}
+
+func TestCase113(t *testing.T) {
+	utils.AssertCompilerFails("testcases/113.cmm", t)
+}
+
+func TestCase114(t *testing.T) {
+	utils.AssertProgramOutput("testcases/114.cmm", "2\n4\n", t)
+}
+
+func TestCase115(t *testing.T) {
+	utils.AssertProgramOutput("testcases/115.cmm", "6\ncmm\n", t)
+}
+
+func TestCase116(t *testing.T) {
+	utils.AssertCompilerFails("testcases/116.cmm", t)
+}
+
+func TestCase117(t *testing.T) {
+	utils.AssertCompilerFails("testcases/117.cmm", t)
+}
+
+func TestCase118(t *testing.T) {
+	utils.AssertProgramOutput("testcases/118.cmm", "1\n1\n", t)
+}
+
+func TestCase119(t *testing.T) {
+	utils.AssertProgramOutput("testcases/119.cmm", "hello\n15\n16\n", t)
+}
+
+func TestCase120(t *testing.T) {
+	utils.AssertCompilerFails("testcases/120.cmm", t)
+}
+
+func TestCase121(t *testing.T) {
+	utils.AssertCompilerFails("testcases/121.cmm", t)
+}
+
+func TestCase122(t *testing.T) {
+	utils.AssertCompilerFails("testcases/122.cmm", t)
+}
+
+func TestCase123(t *testing.T) {
+	utils.AssertProgramOutput("testcases/123.cmm", "10000000\n", t)
+}
+
+func TestCase124(t *testing.T) {
+	utils.AssertProgramOutput("testcases/124.cmm", "21\n10\n", t)
+}
+
+func TestCase125(t *testing.T) {
+	utils.AssertProgramOutput("testcases/125.cmm", "86400\n5\n5\n-3\n3\n1\na\n5\nb\n1\n", t)
+}
+
+func TestCase126(t *testing.T) {
+	utils.AssertCompilerFails("testcases/126.cmm", t)
+}
+
+func TestCase127(t *testing.T) {
+	utils.AssertCompilerFails("testcases/127.cmm", t)
+}
+
+func TestCase128(t *testing.T) {
+	utils.AssertCompilerFails("testcases/128.cmm", t)
+}
+
+func TestCase129(t *testing.T) {
+	utils.AssertProgramOutput("testcases/129.cmm", "15\n25\n63\n15\n", t)
+}
+
+func TestCase142(t *testing.T) {
+	utils.AssertProgramOutput("testcases/142.cmm", "6\n36\n30\n2\n", t)
+}
+
+func TestCase130(t *testing.T) {
+	utils.AssertProgramOutput("testcases/130.cmm", "10\n1\n3\n4\n3628800\n", t)
+}
+
+func TestCase131(t *testing.T) {
+	utils.AssertProgramOutput("testcases/131.cmm", "87654321\n3\n9\n10\n2\n20000022\n", t)
+}
+
+func TestCase132(t *testing.T) {
+	utils.AssertLinkedProgramOutput("testcases/132.cmm", "42\n5\n1235\nQ\n0\n!A\n", nil, t)
+}
+
+func TestCase133(t *testing.T) {
+	utils.AssertLinkedProgramOutput("testcases/133.cmm", "36\n1\n0\n4\nhello from c\n12\n", []string{"testcases/133.c"}, t)
+}
+
+func TestCase134(t *testing.T) {
+	utils.AssertCompilerFails("testcases/134.cmm", t)
+}
+
+func TestCase135(t *testing.T) {
+	utils.AssertLibraryOutput("testcases/135.cmm", "testcases/135.c", "103\nAb\n1 0\nhello\n", t)
+}
+
+func TestCase136(t *testing.T) {
+	utils.AssertCompilerFails("testcases/136.cmm", t)
+}
+
+func TestCase137(t *testing.T) {
+	utils.AssertProgramOutput("testcases/137.cmm", "3\n-5\n-2147483648\n"+strings.Repeat("abcdefgh\n", 1000), t)
+}
+
+func TestCase138(t *testing.T) {
+	utils.AssertProgramOutput("testcases/138.cmm", "7\n// not a comment\n", t)
+}
+
+func TestCase139(t *testing.T) {
+	utils.AssertCompilerFails("testcases/139.cmm", t)
+}
+
+func TestCase140(t *testing.T) {
+	utils.AssertCompilerFails("testcases/140.cmm", t)
+}
+
+func TestCase141(t *testing.T) {
+	utils.AssertCompilerFails("testcases/141.cmm", t)
+}
+
+func TestCase143(t *testing.T) {
+	utils.AssertProgramCrashes("testcases/143.cmm", t)
+}
+
+func TestCase144(t *testing.T) {
+	utils.AssertProgramCrashes("testcases/144.cmm", t)
+}
+
+func TestCase145(t *testing.T) {
+	utils.AssertProgramOutput("testcases/145.cmm", "1\n", t)
+}
+
+func TestCase146(t *testing.T) {
+	utils.AssertCompilerFails("testcases/146.cmm", t)
+}
+
+func TestCase147(t *testing.T) {
+	utils.AssertCompilerFails("testcases/147.cmm", t)
+}
+
+func TestCase148(t *testing.T) {
+	utils.AssertCompilerFails("testcases/148.cmm", t)
+}
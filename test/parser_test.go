@@ -3,6 +3,7 @@ package test
 import (
 	"callmemaybe/language"
 	"callmemaybe/language/typesystem"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -67,7 +68,7 @@ func TestParenthesesInPlusExpression(t *testing.T) {
 	expected := language.ExpPlus{
 		Left: language.ExpParentheses{
 			Inside: language.ExpPlus{
-				Left:  language.ExpNum{1},
+				Left:  language.ExpNum{Value: 1},
 				Right: language.ExpNum{Value: 2},
 			},
 		},
@@ -137,7 +138,7 @@ func TestFunctionAssign(t *testing.T) {
 							Type: typesystem.NewInt(),
 						}},
 						FunctionReturnType: &typesystem.Type{
-							RawType:               typesystem.Void,
+							RawType: typesystem.Void,
 						},
 					},
 				},
@@ -214,3 +215,191 @@ func TestSimpleFunctionCallWithoutArgs(t *testing.T) {
 	}
 	parseExpectedStmt(t, str, expected)
 }
+
+func TestIfSome(t *testing.T) {
+	str := "if some x = y { println x }"
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtIfSome{
+				Identifier: "x",
+				Expression: language.ExpIdentifier{Name: "y"},
+				Body: language.StmtSeq{
+					Statements: []language.Stmt{
+						language.StmtPrintln{
+							Expression: language.ExpIdentifier{Name: "x"},
+						},
+					},
+				},
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestOptionType(t *testing.T) {
+	str := "f = | x option<int> | { }"
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtAssign{
+				Identifier: "f",
+				Expression: language.ExpFunction{
+					Body: language.StmtSeq{},
+					Type: typesystem.Type{
+						RawType: typesystem.Function,
+						FunctionArgumentTypes: []typesystem.NamedType{{
+							Name: "x",
+							Type: typesystem.NewOption(typesystem.NewInt()),
+						}},
+						FunctionReturnType: &typesystem.Type{
+							RawType: typesystem.Void,
+						},
+					},
+				},
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestDestructuringAssign(t *testing.T) {
+	str := "q, r = (1, 2)"
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtAssign{
+				Identifiers: []string{"q", "r"},
+				Expression: language.ExpTuple{
+					Elements: []language.Exp{
+						language.ExpNum{Value: 1},
+						language.ExpNum{Value: 2},
+					},
+				},
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestAssignWithTypeAnnotation(t *testing.T) {
+	str := "x: int = 1"
+	intType := typesystem.NewInt()
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtAssign{
+				Identifier: "x",
+				Expression: language.ExpNum{Value: 1},
+				Type:       &intType,
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestFunctionDeclaration(t *testing.T) {
+	str := "fn id(a int) int { return a }"
+	intType := typesystem.NewInt()
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtFunctionDeclaration{
+				Name: "id",
+				Function: language.ExpFunction{
+					Body: language.StmtSeq{
+						Statements: []language.Stmt{
+							language.StmtReturn{
+								Expression: language.ExpIdentifier{Name: "a"},
+							},
+						},
+					},
+					Type: typesystem.Type{
+						RawType: typesystem.Function,
+						FunctionArgumentTypes: []typesystem.NamedType{{
+							Name: "a",
+							Type: typesystem.NewInt(),
+						}},
+						FunctionReturnType: &intType,
+					},
+				},
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestExternWithoutReturnType(t *testing.T) {
+	str := "extern fn srand(seed int)\nprintln 1"
+	voidType := typesystem.Type{RawType: typesystem.Void}
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtExtern{
+				Name: "srand",
+				Type: typesystem.Type{
+					RawType: typesystem.Function,
+					FunctionArgumentTypes: []typesystem.NamedType{{
+						Name: "seed",
+						Type: typesystem.NewInt(),
+					}},
+					FunctionReturnType: &voidType,
+				},
+			},
+			language.StmtPrintln{
+				Expression: language.ExpNum{Value: 1},
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestExportedFunctionDeclaration(t *testing.T) {
+	str := "export fn unit() { }"
+	voidType := typesystem.Type{RawType: typesystem.Void}
+	expected := language.StmtSeq{
+		Statements: []language.Stmt{
+			language.StmtFunctionDeclaration{
+				Name: "unit",
+				Function: language.ExpFunction{
+					Body: language.StmtSeq{},
+					Type: typesystem.Type{
+						RawType:            typesystem.Function,
+						FunctionReturnType: &voidType,
+					},
+				},
+				Exported: true,
+			},
+		},
+	}
+	parseExpectedStmt(t, str, expected)
+}
+
+func TestParserRecordsPositions(t *testing.T) {
+	parser := language.NewParser(strings.NewReader("x = 1\nif some y = x {\n  println ?y.z\n}\n"))
+	parser.Positions = true
+	actual, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	seq := actual.(language.StmtSeq)
+	expected := []language.Position{{Line: 1, Column: 1}, {Line: 2, Column: 1}}
+	if !reflect.DeepEqual(seq.Positions, expected) {
+		t.Errorf("statements are at %v", seq.Positions)
+	}
+	ifSome := seq.Statements[1].(language.StmtIfSome)
+	if ifSome.Position != (language.Position{Line: 2, Column: 9}) {
+		t.Errorf("binding is at %v", ifSome.Position)
+	}
+	if ifSome.Expression.(language.ExpIdentifier).Position != (language.Position{Line: 2, Column: 13}) {
+		t.Errorf("identifier is at %v", ifSome.Expression)
+	}
+	read := ifSome.Body.(language.StmtSeq).Statements[0].(language.StmtPrintln).Expression.(language.ExpReadFromStruct)
+	if read.Position != (language.Position{Line: 3, Column: 14}) {
+		t.Errorf("field is at %v", read.Position)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	parser := language.NewParser(strings.NewReader("x = 1\ny = )\n"))
+	parser.Positions = true
+	_, err := parser.Parse()
+	var positioned *language.PositionError
+	if !errors.As(err, &positioned) || positioned.Position != (language.Position{Line: 2, Column: 5}) {
+		t.Errorf("expected an error at 2:5, got %v", err)
+	}
+}
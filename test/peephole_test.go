@@ -0,0 +1,122 @@
+package test
+
+import (
+	"callmemaybe/language/assemblyoutput"
+	"reflect"
+	"testing"
+)
+
+func optimizedExpected(t *testing.T, build func(ao *assemblyoutput.AssemblyOutput), expected []string) {
+	ao := assemblyoutput.NewAssemblyOutput()
+	build(ao)
+	ao.Optimize()
+	var operations []string
+	for _, operation := range ao.MainOperations {
+		operations = append(operations, operation.String())
+	}
+	if !reflect.DeepEqual(operations, expected) {
+		t.Errorf("got:\n%v\nexpected:\n%v\n", operations, expected)
+	}
+}
+
+func TestPushFollowedByPop(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Push(assemblyoutput.RAX)
+		ao.Pop(assemblyoutput.RBX)
+	}, []string{
+		"mov rbx, rax",
+	})
+}
+
+func TestPushAndPopAroundUnrelatedMove(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Push(assemblyoutput.RAX)
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Immediate(2))
+		ao.Pop(assemblyoutput.RBX)
+		ao.Add(assemblyoutput.RAX, assemblyoutput.RBX)
+	}, []string{
+		"mov rbx, rax",
+		"mov rax, 2",
+		"add rax, rbx",
+	})
+}
+
+func TestPushAndPopAroundStackAccessIsKept(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Push(assemblyoutput.RAX)
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.StackAddress(8))
+		ao.Pop(assemblyoutput.RBX)
+	}, []string{
+		"push rax",
+		"mov rax, [rsp+8]",
+		"pop rbx",
+	})
+}
+
+func TestJumpToNextLabel(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Cmp(assemblyoutput.RAX, assemblyoutput.RBX)
+		ao.Je("unique1")
+		ao.Jne("unique2")
+		ao.NewSection("unique1")
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Immediate(1))
+		ao.NewSection("unique2")
+	}, []string{
+		"cmp rax, rbx",
+		"jne unique2",
+		"mov rax, 1",
+		"unique2:",
+	})
+}
+
+func TestDeadMove(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Immediate(1))
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.StackAddress(8))
+		ao.Mov(assemblyoutput.RBX, assemblyoutput.RBX)
+	}, []string{
+		"mov rax, [rsp+8]",
+	})
+}
+
+func TestConstantCompareAndBranch(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.Mov(assemblyoutput.RAX, assemblyoutput.Immediate(1))
+		ao.Cmp(assemblyoutput.RAX, assemblyoutput.Immediate(1))
+		ao.Je("unique1")
+		ao.Jne("unique2")
+		ao.NewSection("unique1")
+		ao.Push(assemblyoutput.RAX)
+		ao.NewSection("unique2")
+	}, []string{
+		"mov rax, 1",
+		"push rax",
+	})
+}
+
+func TestMemoryOperandFormatting(t *testing.T) {
+	operands := map[assemblyoutput.Operand]string{
+		assemblyoutput.StackAddress(0):  "[rsp]",
+		assemblyoutput.StackAddress(16): "[rsp+16]",
+		assemblyoutput.Memory{Size: "qword", Base: assemblyoutput.RDX, Index: assemblyoutput.RCX, Scale: 8, Displacement: 8}: "qword [rdx+rcx*8+8]",
+		assemblyoutput.Memory{Label: "unique1"}: "[unique1]",
+	}
+	for operand, expected := range operands {
+		if operand.String() != expected {
+			t.Errorf("got %s, expected %s", operand.String(), expected)
+		}
+	}
+}
+
+func TestEmptyFrame(t *testing.T) {
+	optimizedExpected(t, func(ao *assemblyoutput.AssemblyOutput) {
+		ao.EnterFrame(nil)
+		ao.SetFrameSize(0)
+		ao.LeaveFrame()
+	}, []string{
+		"push rbp",
+		"mov rbp, rsp",
+		"mov rsp, rbp",
+		"pop rbp",
+	})
+}
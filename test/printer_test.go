@@ -0,0 +1,31 @@
+package test
+
+import (
+	"callmemaybe/language/assemblyoutput"
+	"testing"
+)
+
+func TestNasmOutput(t *testing.T) {
+	ao := assemblyoutput.NewAssemblyOutput()
+	ao.Externs = []string{"printf"}
+	ao.Data = []assemblyoutput.Data{{Label: "format", Bytes: []byte("%d\n\x00")}}
+	ao.NewGlobal("unique1")
+	ao.Mov(assemblyoutput.RAX, assemblyoutput.StackAddress(0))
+	ao.Mov(assemblyoutput.Memory{Label: "unique1"}, assemblyoutput.RAX)
+	ao.Add(assemblyoutput.RAX, assemblyoutput.StackAddress(8))
+	ao.Ret()
+	expected := `extern printf
+global main
+section .data
+format: db '%d', 10, 0
+unique1: dq 0
+section .text
+mov rax, [rsp]
+mov [unique1], rax
+add rax, [rsp+8]
+ret
+`
+	if nasm := ao.Nasm(); nasm != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s\n", nasm, expected)
+	}
+}
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"callmemaybe/language/memorymodel"
+	"reflect"
+	"testing"
+)
+
+func TestLinearScanReusesRegistersAfterTheLastUse(t *testing.T) {
+	intervals := []memorymodel.Interval{
+		{Name: "a", Start: 1, End: 3},
+		{Name: "b", Start: 2, End: 9},
+		{Name: "c", Start: 4, End: 6},
+		{Name: "d", Start: 7, End: 8},
+	}
+	registers := memorymodel.LinearScan(intervals)
+	expected := []string{"r12", "r13", "r12", "r12"}
+	if !reflect.DeepEqual(registers, expected) {
+		t.Errorf("got %v, expected %v", registers, expected)
+	}
+}
+
+func TestLinearScanSpillsTheIntervalThatEndsLast(t *testing.T) {
+	intervals := []memorymodel.Interval{
+		{Name: "a", Start: 1, End: 20},
+		{Name: "b", Start: 2, End: 8},
+		{Name: "c", Start: 3, End: 9},
+		{Name: "d", Start: 4, End: 10},
+		{Name: "e", Start: 5, End: 7},
+		{Name: "f", Start: 6, End: 30},
+	}
+	registers := memorymodel.LinearScan(intervals)
+	expected := []string{"", "r13", "r14", "r15", "r12", ""}
+	if !reflect.DeepEqual(registers, expected) {
+		t.Errorf("got %v, expected %v", registers, expected)
+	}
+}
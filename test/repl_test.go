@@ -0,0 +1,50 @@
+package test
+
+import (
+	"bytes"
+	"callmemaybe/utils"
+	"strings"
+	"testing"
+)
+
+func replExpected(t *testing.T, input string, expected string) {
+	var output bytes.Buffer
+	err := utils.Repl(strings.NewReader(input), &output)
+	if err != nil {
+		t.Errorf("repl failed: %v", err)
+		return
+	}
+	if output.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s\n", output.String(), expected)
+	}
+}
+
+func TestReplExpressions(t *testing.T) {
+	input := "x = 40\nx + 2\n:type x == 2\nsome('a')\n(1, \"hi\")\n"
+	expected := "> > 42 : int\n> bool\n> some('a') : option<char>\n> (1, \"hi\") : (int, string)\n> \n"
+	replExpected(t, input, expected)
+}
+
+func TestReplMultiLineInput(t *testing.T) {
+	input := "struct Point {\n  x int\n  y int\n}\nfn origin() @Point {\n  return @Point{\n    x: 0\n    y: 0\n  }\n}\np = #origin\n?p.y\n"
+	expected := "> ... ... ... > ... ... ... ... ... > > 0 : int\n> \n"
+	replExpected(t, input, expected)
+}
+
+func TestReplErrors(t *testing.T) {
+	input := "a = 1\nb = a / 0\nb\na = 'c'\nprintln a\n:unknown\n"
+	expected := "> > error: division by zero\n> error: missing from context: b\n> error: statement in sequence: can not assign char to a of type int\n> 1\n> error: unknown command :unknown\n> \n"
+	replExpected(t, input, expected)
+}
+
+func TestReplReset(t *testing.T) {
+	input := "a = 1\n:reset\na = 'c'\na\n:quit\na\n"
+	expected := "> > > > 'c' : char\n> "
+	replExpected(t, input, expected)
+}
+
+func TestReplAst(t *testing.T) {
+	input := ":ast 1 + ?xs[0]\n"
+	expected := "> ExpPlus\n  Left: ExpNum\n    Value: 1\n  Right: ExpGetFromList\n    List: ExpIdentifier\n      Name: \"xs\"\n    Index: ExpNum\n      Value: 0\n> \n"
+	replExpected(t, input, expected)
+}
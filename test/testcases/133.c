@@ -0,0 +1,23 @@
+#include <stdbool.h>
+
+long sum8(long a, long b, long c, long d, long e, long f, long g, long h) {
+    return a + b + c + d + e + f + g + h;
+}
+
+bool is_digit(char c) {
+    return c >= '0' && c <= '9';
+}
+
+long count(const char *s, char c) {
+    long n = 0;
+    for (; *s; s++) {
+        if (*s == c) {
+            n++;
+        }
+    }
+    return n;
+}
+
+const char *greeting(void) {
+    return "hello from c";
+}
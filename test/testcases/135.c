@@ -0,0 +1,13 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include "out.h"
+
+int main(void) {
+    printf("%ld\n", add(1, 2));
+    printf("%c%c\n", shout('a'), shout('b'));
+    printf("%d %d\n", isEmpty(""), isEmpty("x"));
+    char *s = greet();
+    printf("%s\n", s);
+    free(s);
+    return 0;
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"callmemaybe/language"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -87,3 +88,74 @@ func TestMessyString(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestLineComment(t *testing.T) {
+	tokenizer := language.NewTokenizer(strings.NewReader("x // a note\n= 1"))
+	first, _ := tokenizer.NextToken()
+	second, text := tokenizer.NextToken()
+	third, _ := tokenizer.NextToken()
+	if first != language.Identifier || second != language.Whitespace || text != " // a note\n" || third != language.Assign {
+		t.Error()
+	}
+	expected := []language.Comment{{Text: "// a note", Position: language.Position{Line: 1, Column: 3}}}
+	if !reflect.DeepEqual(tokenizer.Trivia(), expected) {
+		t.Errorf("unexpected trivia %v", tokenizer.Trivia())
+	}
+	fourth, _ := tokenizer.NextToken()
+	if fourth != language.Whitespace || tokenizer.Trivia() != nil {
+		t.Error()
+	}
+}
+
+func TestNestedBlockComment(t *testing.T) {
+	tokenizer := language.NewTokenizer(strings.NewReader("/* a /* b */ c */ /**/x"))
+	first, _ := tokenizer.NextToken()
+	second, text := tokenizer.NextToken()
+	if first != language.Whitespace || second != language.Identifier || text != "x" {
+		t.Error()
+	}
+	expected := []language.Comment{
+		{Text: "/* a /* b */ c */", Position: language.Position{Line: 1, Column: 1}},
+		{Text: "/**/", Position: language.Position{Line: 1, Column: 19}},
+	}
+	if !reflect.DeepEqual(tokenizer.Trivia(), expected) {
+		t.Errorf("unexpected trivia %v", tokenizer.Trivia())
+	}
+}
+
+func TestUnclosedBlockCommentFails(t *testing.T) {
+	tokenizer := language.NewTokenizer(strings.NewReader("/* a /* b */"))
+	first, message := tokenizer.NextToken()
+	if first != language.Error || message != "unterminated block comment starting at 1:1" {
+		t.Errorf("got %v %q", first, message)
+	}
+}
+
+func TestUnterminatedBlockCommentFails(t *testing.T) {
+	tokenizer := language.NewTokenizer(strings.NewReader("x\n  /* a"))
+	tokenizer.NextToken()
+	second, message := tokenizer.NextToken()
+	if second != language.Error || message != "unterminated block comment starting at 2:3" {
+		t.Errorf("got %v %q", second, message)
+	}
+}
+
+func TestDivideIsNotAComment(t *testing.T) {
+	tokenizer := language.NewTokenizer(strings.NewReader("a /b"))
+	tokenizer.NextToken()
+	tokenizer.NextToken()
+	third, _ := tokenizer.NextToken()
+	if third != language.Divide {
+		t.Error()
+	}
+}
+
+func TestCommentBeforeEndOfFile(t *testing.T) {
+	tokenizer := language.NewTokenizer(strings.NewReader("x\n// the end"))
+	tokenizer.NextToken()
+	tokenizer.NextToken()
+	last, _ := tokenizer.NextToken()
+	if last != language.EOF || len(tokenizer.Trivia()) != 1 || tokenizer.Trivia()[0].Text != "// the end" {
+		t.Error()
+	}
+}
@@ -3,50 +3,250 @@ package utils
 import (
 	"callmemaybe/language"
 	"callmemaybe/language/assemblyoutput"
+	"callmemaybe/language/bytecode"
 	"callmemaybe/language/memorymodel"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"strings"
 )
 
-func Compile(program string) (string, error) {
+// Options selects the optional passes and backend modes of the compiler
+type Options struct {
+	Optimize  bool
+	Registers bool
+	Library   bool
+	// Nasm assembles the program with nasm instead of the built-in encoder
+	Nasm bool
+	// Freestanding programs do not use libc, and are linked with ld alone
+	Freestanding bool
+	// Backend is BackendC to compile the program through C with gcc, and
+	// empty for the x86 backend
+	Backend string
+}
+
+// BackendC translates programs to C, which is compiled with gcc
+const BackendC = "c"
+
+// Compile compiles a program to NASM assembly
+func Compile(program string, options Options) (string, error) {
+	ao, _, err := compile(program, options)
+	if err != nil {
+		return "", err
+	}
+	return ao.Nasm(), nil
+}
+
+// CompileObject compiles a program to an ELF object file
+func CompileObject(program string, file string, options Options) error {
+	ao, ast, err := compile(program, options)
+	if err != nil {
+		return err
+	}
+	if options.Backend == BackendC {
+		return writeCObject(ast, file, options)
+	}
+	return writeObject(ao, file, options)
+}
+
+// Interpret checks the program, and runs it with the interpreter instead of
+// compiling it. What the program prints is written to out.
+func Interpret(program string, out io.Writer) error {
+	_, ast, err := compile(program, Options{})
+	if err != nil {
+		return err
+	}
+	return language.Interpret(ast, out)
+}
+
+// CompileBytecode checks the program, and compiles it to the bytecode format
+// that Exec runs
+func CompileBytecode(program string) ([]byte, error) {
+	_, ast, err := compile(program, Options{})
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := language.GenerateBytecode(ast)
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Encode(), nil
+}
+
+// Exec runs a program that was compiled with CompileBytecode on the virtual
+// machine. What the program prints is written to out.
+func Exec(compiled []byte, out io.Writer) error {
+	program, err := bytecode.Decode(compiled)
+	if err != nil {
+		return err
+	}
+	return bytecode.Run(program, out)
+}
+
+// CompileLibrary compiles a program to an object file for a library, and
+// returns a C header named after the library that declares its exported
+// functions
+func CompileLibrary(program string, file string, name string, options Options) (string, error) {
+	options.Library = true
+	ao, ast, err := compile(program, options)
+	if err != nil {
+		return "", err
+	}
+	header, err := language.Header(ast, name)
+	if err != nil {
+		return "", err
+	}
+	return header, writeObject(ao, file, options)
+}
+
+func compile(program string, options Options) (*assemblyoutput.AssemblyOutput, language.Stmt, error) {
 	parser := language.NewParser(strings.NewReader(program))
 	ast, err := parser.Parse()
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	ast, err = language.Fold(ast)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	ao := assemblyoutput.NewAssemblyOutput()
+	ao.Library = options.Library
+	ao.Freestanding = options.Freestanding
 	mm := memorymodel.NewMemoryModel()
-	ao.Start()
-	err = ast.Generate(ao, mm)
-	ao.End(mm.CurrentStackSize)
-
+	mm.UseRegisters = options.Registers
+	err = language.GenerateProgram(ast, ao, mm)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	assembly := ""
-	for i := range ao.MainOperations {
-		assembly += ao.MainOperations[i] + "\n"
+	if options.Optimize {
+		ao.Optimize()
 	}
+	return ao, ast, nil
+}
 
-	for i := range ao.EvaluatedProcedures {
-		proc := ao.EvaluatedProcedures[i]
-		assembly += proc.Name + ":\n"
-		for j := range proc.Operations {
-			assembly += proc.Operations[j] + "\n"
+// writeObject encodes the program as an object file, or assembles it with
+// nasm from an assembly file next to the object file
+func writeObject(ao *assemblyoutput.AssemblyOutput, file string, options Options) error {
+	if options.Nasm {
+		source := strings.TrimSuffix(file, ".o") + ".nasm"
+		defer os.Remove(source)
+		err := WriteFile(source, ao.Nasm())
+		if err != nil {
+			return err
 		}
-
+		return Assemble(source)
 	}
+	object, err := ao.Elf()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, object, 0644)
+}
 
-	return assembly, nil
+// writeCObject translates the program to C in a file next to the object
+// file, and compiles it with gcc. Signed overflow wraps around like it does
+// in the x86 backend.
+func writeCObject(ast language.Stmt, file string, options Options) error {
+	program, err := language.GenerateC(ast)
+	if err != nil {
+		return err
+	}
+	source := strings.TrimSuffix(file, ".o") + ".c"
+	defer os.Remove(source)
+	err = WriteFile(source, program)
+	if err != nil {
+		return err
+	}
+	flags := []string{"-fwrapv", "-fno-builtin"}
+	if options.Optimize {
+		flags = append(flags, "-O2")
+	}
+	_, err = CompileC(source, flags...)
+	return err
 }
 
+// Assemble assembles the file with nasm, to an object file with the same name
 func Assemble(file string) error {
-	_, err := exec.Command("nasm", "-f", "elf64", file).CombinedOutput()
-	return err
+	output, err := exec.Command("nasm", "-f", "elf64", file).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
 }
 
-func Link(file string) error {
-	_, err := exec.Command("gcc", "-no-pie", "-o", "out", file, "-lc").CombinedOutput()
-	return err
+// Link links the object file with libc into an executable named out. The
+// flags are passed on to gcc, so they can add objects and libraries.
+func Link(file string, flags ...string) error {
+	arguments := append([]string{"-no-pie", "-o", "out", file}, flags...)
+	output, err := exec.Command("gcc", append(arguments, "-lc")...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+// LinkFreestanding links the object file into a static executable named out,
+// without libc. The flags are passed on to ld.
+func LinkFreestanding(file string, flags ...string) error {
+	arguments := append([]string{"-static", "-o", "out", file}, flags...)
+	output, err := exec.Command("ld", arguments...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+// Archive puts the object files in a static library
+func Archive(library string, files ...string) error {
+	os.Remove(library)
+	output, err := exec.Command("ar", append([]string{"rcs", library}, files...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+// LinkShared links the object file into a shared library
+func LinkShared(file string, library string, flags ...string) error {
+	arguments := append([]string{"-shared", "-o", library, file}, flags...)
+	output, err := exec.Command("gcc", append(arguments, "-lc")...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+// LinkCProgram compiles a C program into an executable named out, with the
+// current directory on the include path
+func LinkCProgram(source string, flags ...string) error {
+	arguments := append([]string{"-I.", "-o", "out", source}, flags...)
+	output, err := exec.Command("gcc", arguments...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+// LinkFlags are the gcc flags for linking with extra objects and libraries
+func LinkFlags(objects []string, libraries []string) []string {
+	flags := append([]string{}, objects...)
+	for _, library := range libraries {
+		flags = append(flags, "-l"+library)
+	}
+	return flags
+}
+
+// CompileC compiles a C source file to an object file next to it, and
+// returns the path of the object file. The flags are passed on to gcc.
+func CompileC(file string, flags ...string) (string, error) {
+	object := strings.TrimSuffix(file, ".c") + ".o"
+	arguments := append([]string{"-c", "-o", object, file}, flags...)
+	output, err := exec.Command("gcc", arguments...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, output)
+	}
+	return object, nil
 }
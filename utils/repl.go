@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bufio"
+	"callmemaybe/language"
+	"callmemaybe/language/typesystem"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// repl keeps the statements that have been run, which every new input is
+// type checked together with, and the interpreter session that holds their
+// variables
+type repl struct {
+	out     io.Writer
+	history []language.Stmt
+	session *language.Session
+}
+
+// Repl reads statements from input and runs them with the interpreter. Input
+// continues on the next line while it has unclosed braces. The value and
+// type of bare expressions are printed, and lines that start with a colon
+// are commands:
+//
+//	:type <expression>  prints the type of the expression
+//	:ast <expression>   prints the syntax tree of the expression or statement
+//	:reset              forgets all variables, functions and structs
+//	:quit               stops the repl
+func Repl(input io.Reader, out io.Writer) error {
+	r := &repl{out: out}
+	r.reset()
+	scanner := bufio.NewScanner(input)
+	var lines []string
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		source := strings.Join(lines, "\n")
+		if openBraces(source) > 0 {
+			fmt.Fprint(out, "... ")
+			continue
+		}
+		lines = nil
+		if !r.handle(strings.TrimSpace(source)) {
+			return nil
+		}
+		fmt.Fprint(out, "> ")
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// handle runs one input, and returns false if the repl should stop
+func (r *repl) handle(source string) bool {
+	var err error
+	switch {
+	case source == "":
+	case source == ":quit":
+		return false
+	case source == ":reset":
+		r.reset()
+	case strings.HasPrefix(source, ":type "):
+		var kind typesystem.Type
+		kind, err = r.typeOf(strings.TrimPrefix(source, ":type "))
+		if err == nil {
+			fmt.Fprintln(r.out, kind)
+		}
+	case strings.HasPrefix(source, ":ast "):
+		err = r.printAST(strings.TrimPrefix(source, ":ast "))
+	case strings.HasPrefix(source, ":"):
+		err = fmt.Errorf("unknown command %s", strings.Fields(source)[0])
+	default:
+		if exp, parseErr := parseExpression(source); parseErr == nil {
+			err = r.evaluate(exp)
+		} else {
+			err = r.run(source)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+	}
+	return true
+}
+
+func (r *repl) reset() {
+	r.history = nil
+	r.session = language.NewSession(r.out)
+}
+
+func (r *repl) program() language.Stmt {
+	return language.StmtSeq{Statements: r.history}
+}
+
+// run checks and runs the statements. They are only kept if they run
+// without errors, so the variables they declare always exist.
+func (r *repl) run(source string) error {
+	ast, err := language.NewParser(strings.NewReader(source)).Parse()
+	if err != nil {
+		return err
+	}
+	ast, err = language.Fold(ast)
+	if err != nil {
+		return err
+	}
+	statements := ast.(language.StmtSeq).Statements
+	_, err = language.Check(language.StmtSeq{Statements: append(r.history[:len(r.history):len(r.history)], statements...)}, nil)
+	if err != nil {
+		return err
+	}
+	err = r.session.Run(ast)
+	if err != nil {
+		return err
+	}
+	r.history = append(r.history, statements...)
+	return nil
+}
+
+// evaluate prints the value and the type of the expression
+func (r *repl) evaluate(exp language.Exp) error {
+	exp, kind, err := r.check(exp)
+	if err != nil {
+		return err
+	}
+	value, err := r.session.Evaluate(exp, kind)
+	if err != nil || kind.RawType == typesystem.Void {
+		return err
+	}
+	fmt.Fprintf(r.out, "%s : %s\n", value, kind)
+	return nil
+}
+
+func (r *repl) typeOf(source string) (typesystem.Type, error) {
+	exp, err := parseExpression(source)
+	if err != nil {
+		return typesystem.NewInvalid(), err
+	}
+	_, kind, err := r.check(exp)
+	return kind, err
+}
+
+// check folds the expression and type checks it after the statements that
+// have been run
+func (r *repl) check(exp language.Exp) (language.Exp, typesystem.Type, error) {
+	folded, err := language.Fold(language.StmtAssign{Identifier: "_", Expression: exp})
+	if err != nil {
+		return nil, typesystem.NewInvalid(), err
+	}
+	exp = folded.(language.StmtAssign).Expression
+	kind, err := language.Check(r.program(), exp)
+	return exp, kind, err
+}
+
+func (r *repl) printAST(source string) error {
+	var node interface{}
+	node, err := parseExpression(source)
+	if err != nil {
+		node, err = language.NewParser(strings.NewReader(source)).Parse()
+		if err != nil {
+			return err
+		}
+	}
+	var builder strings.Builder
+	writeAST(&builder, reflect.ValueOf(node), "")
+	fmt.Fprint(r.out, builder.String())
+	return nil
+}
+
+func parseExpression(source string) (language.Exp, error) {
+	return language.NewParser(strings.NewReader(source)).ParseExpression()
+}
+
+// writeAST writes a node of the syntax tree with the name of its type, and
+// its fields indented below it. Types are written like in programs.
+func writeAST(builder *strings.Builder, node reflect.Value, indent string) {
+	switch node.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if node.IsNil() {
+			builder.WriteString("nil\n")
+			return
+		}
+		writeAST(builder, node.Elem(), indent)
+	case reflect.Struct:
+		if kind, ok := node.Interface().(typesystem.Type); ok {
+			builder.WriteString(kind.String() + "\n")
+			return
+		}
+		builder.WriteString(node.Type().Name() + "\n")
+		for i := 0; i < node.NumField(); i++ {
+			// Positions are not part of the syntax, and the repl does not record them
+			if name := node.Type().Field(i).Name; name == "Position" || name == "Positions" {
+				continue
+			}
+			builder.WriteString(fmt.Sprintf("%s  %s: ", indent, node.Type().Field(i).Name))
+			writeAST(builder, node.Field(i), indent+"  ")
+		}
+	case reflect.Slice:
+		if node.Len() == 0 {
+			builder.WriteString("[]\n")
+			return
+		}
+		builder.WriteString("\n")
+		for i := 0; i < node.Len(); i++ {
+			builder.WriteString(indent + "  - ")
+			writeAST(builder, node.Index(i), indent+"    ")
+		}
+	case reflect.String:
+		builder.WriteString(fmt.Sprintf("%q\n", node.String()))
+	default:
+		builder.WriteString(fmt.Sprintf("%v\n", node.Interface()))
+	}
+}
+
+// openBraces is the number of braces that are opened and not closed in the
+// source, outside of strings and chars
+func openBraces(source string) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, c := range source {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		}
+	}
+	return depth
+}
@@ -1,40 +1,155 @@
 package utils
 
 import (
+	"bytes"
 	"os"
+	"os/exec"
 	"testing"
 )
 
+// configurations are the compiler options that every program is tested with,
+// so that optional passes and modes never change behaviour. Programs are
+// also assembled with nasm, to compare it with the built-in encoder.
+var configurations = []Options{
+	{},
+	{Optimize: true},
+	{Registers: true},
+	{Registers: true, Optimize: true},
+	{Nasm: true},
+	{Nasm: true, Registers: true, Optimize: true},
+}
+
+// freestandingConfigurations are added for programs that do not depend on
+// libc, to compare the freestanding runtime with libc
+var freestandingConfigurations = []Options{
+	{Freestanding: true},
+	{Freestanding: true, Registers: true, Optimize: true},
+	{Freestanding: true, Nasm: true},
+}
+
+// hasNasm is whether nasm is installed. Configurations that assemble with it
+// are left out when it is not.
+func hasNasm() bool {
+	_, err := exec.LookPath("nasm")
+	return err == nil
+}
+
+// skipWithoutNasm skips the test when nasm is not installed, so that it is
+// not reported as passing without the configurations that need it. It is
+// deferred, so that the other configurations still run.
+func skipWithoutNasm(t *testing.T) {
+	if !hasNasm() {
+		t.Skip("nasm is not installed")
+	}
+}
+
+// cConfigurations are added for programs that the C backend can compile
+var cConfigurations = []Options{
+	{Backend: BackendC},
+	{Backend: BackendC, Optimize: true},
+}
+
 func AssertProgramOutput(path string, output string, t *testing.T) {
-	defer os.Remove("out")
-	defer os.Remove("out.nasm")
-	defer os.Remove("out.o")
+	defer skipWithoutNasm(t)
+	for _, options := range append(append(configurations, freestandingConfigurations...), cConfigurations...) {
+		assertProgramOutput(path, output, options, nil, t)
+	}
+	assertInterpretedOutput(path, output, t)
+	assertBytecodeOutput(path, output, t)
+}
 
+// assertInterpretedOutput runs the program with the interpreter, which has
+// to print the same as the compiled program
+func assertInterpretedOutput(path string, output string, t *testing.T) {
 	program, err := ReadFile(path)
 	if err != nil {
 		t.Errorf("failed to read file: %v", err)
 		return
 	}
 
-	nasm, err := Compile(program)
+	var stdout bytes.Buffer
+	err = Interpret(program, &stdout)
 	if err != nil {
-		t.Errorf("failed to compile: %v", err)
+		t.Errorf("failed to interpret: %v", err)
 		return
 	}
 
-	err = WriteFile("out.nasm", nasm)
+	if stdout.String() != output {
+		t.Errorf("interpreter got:\n%s\nexpected:\n%s\n", stdout.String(), output)
+	}
+}
+
+// AssertLinkedProgramOutput is like AssertProgramOutput, but links the
+// program with libc and the given C source files, so it can use functions
+// from them
+func AssertLinkedProgramOutput(path string, output string, sources []string, t *testing.T) {
+	defer skipWithoutNasm(t)
+	var objects []string
+	for _, source := range sources {
+		object, err := CompileC(source)
+		if err != nil {
+			t.Errorf("failed to compile %s: %v", source, err)
+			return
+		}
+		defer os.Remove(object)
+		objects = append(objects, object)
+	}
+	for _, options := range append(configurations, cConfigurations...) {
+		assertProgramOutput(path, output, options, objects, t)
+	}
+}
+
+// assertBytecodeOutput compiles the program to bytecode, and runs it on the
+// virtual machine, which has to print the same as the compiled program
+func assertBytecodeOutput(path string, output string, t *testing.T) {
+	program, err := ReadFile(path)
 	if err != nil {
-		t.Errorf("failed to write file: %v", err)
+		t.Errorf("failed to read file: %v", err)
 		return
 	}
 
-	err = Assemble("out.nasm")
+	compiled, err := CompileBytecode(program)
 	if err != nil {
-		t.Errorf("failed to assemble: %v", err)
+		t.Errorf("failed to compile to bytecode: %v", err)
 		return
 	}
 
-	err = Link("./out.o")
+	var stdout bytes.Buffer
+	err = Exec(compiled, &stdout)
+	if err != nil {
+		t.Errorf("failed to run bytecode: %v", err)
+		return
+	}
+
+	if stdout.String() != output {
+		t.Errorf("virtual machine got:\n%s\nexpected:\n%s\n", stdout.String(), output)
+	}
+}
+
+func assertProgramOutput(path string, output string, options Options, objects []string, t *testing.T) {
+	if options.Nasm && !hasNasm() {
+		return
+	}
+	defer os.Remove("out")
+	defer os.Remove("out.o")
+
+	program, err := ReadFile(path)
+	if err != nil {
+		t.Errorf("failed to read file: %v", err)
+		return
+	}
+
+	err = CompileObject(program, "out.o", options)
+	if err != nil {
+		t.Errorf("failed to compile: %v", err)
+		return
+	}
+
+	if options.Freestanding {
+		err = LinkFreestanding("./out.o", objects...)
+	} else {
+		err = Link("./out.o", objects...)
+	}
 	if err != nil {
 		t.Errorf("failed to link: %v", err)
 		return
@@ -47,7 +162,7 @@ func AssertProgramOutput(path string, output string, t *testing.T) {
 	}
 
 	if stdout != output {
-		t.Errorf("got:\n%s\nexpected:\n%s\n", stdout, output)
+		t.Errorf("%+v got:\n%s\nexpected:\n%s\n", options, stdout, output)
 	}
 }
 
@@ -58,18 +173,43 @@ func AssertCompilerFails(path string, t *testing.T) {
 		return
 	}
 
-	_, err = Compile(program)
+	_, err = Compile(program, Options{})
 	if err == nil {
 		t.Errorf("did not fail to compile")
 		return
 	}
 }
 
+func AssertProgramCrashes(path string, t *testing.T) {
+	defer skipWithoutNasm(t)
+	for _, options := range append(append(configurations, freestandingConfigurations...), cConfigurations...) {
+		assertProgramCrashes(path, options, t)
+	}
 
+	program, err := ReadFile(path)
+	if err != nil {
+		t.Errorf("failed to read file: %v", err)
+		return
+	}
+	var stdout bytes.Buffer
+	if Interpret(program, &stdout) == nil {
+		t.Errorf("interpreted program should crash")
+	}
+	compiled, err := CompileBytecode(program)
+	if err != nil {
+		t.Errorf("failed to compile to bytecode: %v", err)
+		return
+	}
+	if Exec(compiled, &stdout) == nil {
+		t.Errorf("program on the virtual machine should crash")
+	}
+}
 
-func AssertProgramCrashes(path string, t *testing.T) {
+func assertProgramCrashes(path string, options Options, t *testing.T) {
+	if options.Nasm && !hasNasm() {
+		return
+	}
 	defer os.Remove("out")
-	defer os.Remove("out.nasm")
 	defer os.Remove("out.o")
 
 	program, err := ReadFile(path)
@@ -78,33 +218,90 @@ func AssertProgramCrashes(path string, t *testing.T) {
 		return
 	}
 
-	nasm, err := Compile(program)
+	err = CompileObject(program, "out.o", options)
 	if err != nil {
 		t.Errorf("failed to compile: %v", err)
 		return
 	}
 
-	err = WriteFile("out.nasm", nasm)
+	if options.Freestanding {
+		err = LinkFreestanding("./out.o")
+	} else {
+		err = Link("./out.o")
+	}
 	if err != nil {
-		t.Errorf("failed to write file: %v", err)
+		t.Errorf("failed to link: %v", err)
 		return
 	}
 
-	err = Assemble("out.nasm")
+	_, err = RunExecutable("out")
+	if err == nil {
+		t.Errorf("%+v program should crash", options)
+		return
+	}
+}
+
+// AssertLibraryOutput builds the program as a static and as a shared library,
+// and checks the output of a C program that is linked with each of them
+func AssertLibraryOutput(path string, program string, output string, t *testing.T) {
+	defer skipWithoutNasm(t)
+	for _, options := range configurations {
+		assertLibraryOutput(path, program, output, options, false, t)
+		assertLibraryOutput(path, program, output, options, true, t)
+	}
+}
+
+func assertLibraryOutput(path string, program string, output string, options Options, shared bool, t *testing.T) {
+	if options.Nasm && !hasNasm() {
+		return
+	}
+	defer os.Remove("out")
+	defer os.Remove("out.o")
+	defer os.Remove("out.h")
+	defer os.Remove("libout.a")
+	defer os.Remove("libout.so")
+
+	source, err := ReadFile(path)
 	if err != nil {
-		t.Errorf("failed to assemble: %v", err)
+		t.Errorf("failed to read file: %v", err)
 		return
 	}
 
-	err = Link("./out.o")
+	header, err := CompileLibrary(source, "out.o", "out", options)
+	if err != nil {
+		t.Errorf("failed to compile: %v", err)
+		return
+	}
+
+	err = WriteFile("out.h", header)
+	if err != nil {
+		t.Errorf("failed to write file: %v", err)
+		return
+	}
+
+	if shared {
+		err = LinkShared("out.o", "libout.so")
+		if err == nil {
+			err = LinkCProgram(program, "-L.", "-lout", "-Wl,-rpath,$ORIGIN")
+		}
+	} else {
+		err = Archive("libout.a", "out.o")
+		if err == nil {
+			err = LinkCProgram(program, "libout.a")
+		}
+	}
 	if err != nil {
 		t.Errorf("failed to link: %v", err)
 		return
 	}
 
-	_, err = RunExecutable("out")
-	if err == nil {
-		t.Errorf("program should crash")
+	stdout, err := RunExecutable("out")
+	if err != nil {
+		t.Errorf("failed to run executable: %v", err)
 		return
 	}
+
+	if stdout != output {
+		t.Errorf("%+v shared: %v got:\n%s\nexpected:\n%s\n", options, shared, stdout, output)
+	}
 }
@@ -0,0 +1,27 @@
+const vscode = require('vscode');
+const { LanguageClient } = require('vscode-languageclient/node');
+
+let client;
+
+// activate starts `cmm lsp`, which reports errors and shows types on hover
+function activate(context) {
+    const command = vscode.workspace.getConfiguration('callmemaybe').get('path') || 'cmm';
+    const serverOptions = {
+        command: command,
+        args: ['lsp'],
+    };
+    const clientOptions = {
+        documentSelector: [{ scheme: 'file', language: 'callmemaybe' }],
+    };
+    client = new LanguageClient('callmemaybe', 'Call Me Maybe', serverOptions, clientOptions);
+    context.subscriptions.push(client.start());
+}
+
+function deactivate() {
+    if (client) {
+        return client.stop();
+    }
+    return undefined;
+}
+
+module.exports = { activate, deactivate };